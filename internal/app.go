@@ -1,37 +1,42 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"github.com/dentych/taskeroo/internal/app"
 	"github.com/dentych/taskeroo/internal/controllers"
 	"github.com/dentych/taskeroo/internal/database"
 	"github.com/dentych/taskeroo/internal/telegram"
+	"github.com/dentych/taskeroo/internal/util"
 	"github.com/foolin/goview"
 	"github.com/foolin/goview/supports/ginview"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const (
-	CookieKeyUserID  = "auth_userid"
-	CookieKeySession = "auth_session"
-
-	KeyUserID  = "userID"
-	KeySession = "session"
-)
-
-var (
-	Time31Days = 31 * 24 * time.Hour
-)
-
 var secureCookies bool
 
 func Run() {
 	router := gin.Default()
+	err := router.SetTrustedProxies(trustedProxies())
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES must be IP addresses or CIDR ranges separated by commas: %s\n", err)
+	}
+
+	port := "8080"
+	portEnv := os.Getenv("PORT")
+	if portEnv != "" {
+		port = portEnv
+	}
+	baseURL := baseURL(port)
 
 	var dsn string
 	if os.Getenv("ENVIRONMENT") == "prod" {
@@ -48,6 +53,16 @@ func Run() {
 		log.Fatalf("Failed to establish database connection: %s\n", err)
 	}
 
+	err = database.MigrateEmailVerification(db)
+	if err != nil {
+		log.Fatalf("Failed to migrate email verification: %s\n", err)
+	}
+
+	err = database.MigrateMembershipRoles(db)
+	if err != nil {
+		log.Fatalf("Failed to migrate membership roles: %s\n", err)
+	}
+
 	err = db.AutoMigrate(
 		&database.User{},
 		&database.Session{},
@@ -56,24 +71,86 @@ func Run() {
 		&database.GroupDiscord{},
 		&database.DiscordUsername{},
 		&database.Telegram{},
+		&database.Invitation{},
+		&database.Membership{},
+		&database.Activity{},
+		&database.JoinRequest{},
+		&database.Completion{},
+		&database.Device{},
+		&database.PasswordReset{},
+		&database.RecoveryCode{},
+		&database.Passkey{},
+		&database.PasskeyChallenge{},
+		&database.OAuthAccount{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database models: %s\n", err)
 	}
 
+	err = database.MigrateUserGroups(db)
+	if err != nil {
+		log.Fatalf("Failed to migrate group memberships: %s\n", err)
+	}
+
+	err = database.MigrateUserEmailIndex(db)
+	if err != nil {
+		log.Fatalf("Failed to migrate user email index: %s\n", err)
+	}
+
+	err = database.MigrateSessionExpiry(db, app.SessionLifetime)
+	if err != nil {
+		log.Fatalf("Failed to migrate session expiry: %s\n", err)
+	}
+
+	err = database.MigrateSessionTokens(db)
+	if err != nil {
+		log.Fatalf("Failed to migrate session tokens: %s\n", err)
+	}
+
+	key := secretKey()
+	encKey := encryptionKey(key)
+	err = database.MigrateWebhookTokens(db, func(secret string) (string, error) {
+		return util.Encrypt(encKey, secret)
+	})
+	if err != nil {
+		log.Fatalf("Failed to migrate webhook tokens: %s\n", err)
+	}
+
+	transactor := database.NewTransactor(db)
 	userRepo := database.NewUserRepo(db)
 	sessionRepo := database.NewSessionRepo(db)
 	groupRepo := database.NewGroupRepo(db)
 	taskRepo := database.NewTaskRepo(db)
 	notificationRepo := database.NewNotificationRepo(db)
 	telegramRepo := database.NewTelegramRepo(db)
+	invitationRepo := database.NewInvitationRepo(db)
+	membershipRepo := database.NewMembershipRepo(db)
+	activityRepo := database.NewActivityRepo(db)
+	joinRequestRepo := database.NewJoinRequestRepo(db)
+	completionRepo := database.NewCompletionRepo(db)
+	deviceRepo := database.NewDeviceRepo(db)
+	passwordResetRepo := database.NewPasswordResetRepo(db)
+	recoveryCodeRepo := database.NewRecoveryCodeRepo(db)
+	passkeyRepo := database.NewPasskeyRepo(db)
+	oauthAccountRepo := database.NewOAuthAccountRepo(db)
 	telegramClient := telegram.NewTelegram(telegramRepo, os.Getenv("TELEGRAM_TOKEN"))
 
 	telegramLogic := app.NewTelegramLogic(telegramRepo, telegramClient)
-	authService := app.NewAuthLogic(sessionRepo, userRepo, groupRepo)
 	notificationLogic := app.NewNotificationLogic(notificationRepo, userRepo, groupRepo, telegramRepo, telegramLogic)
-	taskLogic := app.NewTaskLogic(taskRepo, userRepo, groupRepo, notificationLogic)
-	scheduler := app.NewScheduler(notificationLogic, taskLogic, groupRepo)
+	invitationLogic := app.NewInvitationLogic(transactor, invitationRepo, userRepo, groupRepo, membershipRepo, activityRepo, notificationLogic)
+	groupLogic := app.NewGroupLogic(transactor, groupRepo, userRepo, membershipRepo, taskRepo, invitationRepo, joinRequestRepo, activityRepo, notificationLogic)
+	loginLimiter := app.NewLoginLimiter(app.NewMemoryAttemptStore(time.Hour, 100000), userRepo, notificationLogic)
+	authService := app.NewAuthLogic(transactor, sessionRepo, userRepo, groupRepo, membershipRepo, passwordResetRepo, recoveryCodeRepo, invitationLogic, groupLogic, loginLimiter, mailer(), baseURL, key, encKey)
+	taskLogic := app.NewTaskLogic(transactor, taskRepo, userRepo, groupRepo, membershipRepo, activityRepo, completionRepo, notificationLogic, encKey)
+	childLogic := app.NewChildLogic(transactor, userRepo, groupRepo, membershipRepo, activityRepo)
+	activityLogic := app.NewActivityLogic(activityRepo, userRepo, groupRepo)
+	kioskLogic := app.NewKioskLogic(deviceRepo, groupRepo, userRepo, membershipRepo, taskLogic)
+	privacyLogic := app.NewPrivacyLogic(transactor, userRepo, sessionRepo, groupRepo, membershipRepo, taskRepo, activityRepo, completionRepo, joinRequestRepo, telegramRepo, notificationRepo, passwordResetRepo, recoveryCodeRepo, passkeyRepo, oauthAccountRepo, groupLogic)
+	passkeyLogic := app.NewPasskeyLogic(passkeyRepo, userRepo, groupRepo, authService, key)
+	oauthLogic := app.NewOAuthLogic(oauthAccountRepo, userRepo, groupRepo, authService, oauthProviders(), key)
+	scheduler := app.NewScheduler(notificationLogic, taskLogic, authService, passkeyLogic, groupRepo, trashRetention())
+
+	telegramLogic.HandleAction(app.ActionClaimTask, taskLogic.HandleClaimAction)
 
 	goviewConfig := goview.DefaultConfig
 	if os.Getenv("ENVIRONMENT") != "prod" {
@@ -81,13 +158,24 @@ func Run() {
 	}
 	router.HTMLRender = ginview.New(goviewConfig)
 
+	router.Use(controllers.CSRFMiddleware(key, secureCookies, "/webhook/", "/task/debug/"))
+	router.Use(controllers.OAuthProvidersMiddleware(oauthLogic))
+
 	protectedRouter := router.Group("")
-	protectedRouter.Use(controllers.AuthMiddleware(authService))
+	sessionCookie := controllers.NewSessionCookie(sessionKeys(key), secureCookies)
+	protectedRouter.Use(controllers.AuthMiddleware(authService, groupLogic, sessionCookie))
 
-	controllers.NewAuthController(router, protectedRouter, authService, secureCookies)
-	controllers.NewGroupController(protectedRouter, groupRepo, userRepo)
-	controllers.NewTaskController(router, protectedRouter, userRepo, taskLogic)
+	controllers.NewAuthController(router, protectedRouter, authService, loginLimiter, sessionCookie)
+	controllers.NewGroupController(protectedRouter, groupLogic, authService, userRepo)
+	controllers.NewInvitationController(protectedRouter, invitationLogic, authService, baseURL)
+	controllers.NewTaskController(router, protectedRouter, userRepo, taskLogic, baseURL)
 	controllers.NewNotificationController(protectedRouter, notificationLogic)
+	controllers.NewActivityController(protectedRouter, activityLogic)
+	controllers.NewChildController(protectedRouter, childLogic)
+	controllers.NewKioskController(router, protectedRouter, kioskLogic, baseURL, secureCookies)
+	controllers.NewPrivacyController(protectedRouter, privacyLogic)
+	controllers.NewPasskeyController(router, protectedRouter, passkeyLogic, sessionCookie, baseURL, secureCookies)
+	controllers.NewOAuthController(router, protectedRouter, oauthLogic, sessionCookie, baseURL, secureCookies)
 	controllers.NewTelegramController(protectedRouter, telegramLogic)
 	controllers.NewPWAController(router)
 
@@ -97,20 +185,180 @@ func Run() {
 		log.Fatalf("Failed to start, because Telegram Bot could not start: %s\n", err)
 	}
 
-	port := "8080"
-	portEnv := os.Getenv("PORT")
-	if portEnv != "" {
-		port = portEnv
-	}
 	err = router.Run(fmt.Sprintf(":%s", port))
 	if err != nil {
 		log.Fatalf("Error running server: %s\n", err)
 	}
 }
 
-func clearCookies(ctx *gin.Context) {
-	ctx.SetCookie(CookieKeyUserID, "", -1, "", "", secureCookies, true)
-	ctx.SetCookie(CookieKeySession, "", -1, "", "", secureCookies, true)
+// trashRetention returns how long deleted tasks are kept, configured in days by TRASH_RETENTION_DAYS.
+func trashRetention() time.Duration {
+	days := 30
+	if value := os.Getenv("TRASH_RETENTION_DAYS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Fatalf("TRASH_RETENTION_DAYS must be a positive number of days, but was: %s\n", value)
+		}
+		days = parsed
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// mailer returns an SMTPMailer if SMTP_HOST is set, and otherwise a LogMailer which only writes emails to the log.
+func mailer() app.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Printf("SMTP_HOST is not set, so emails are written to the log instead of being sent.\n")
+		return app.NewLogMailer()
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		log.Fatalf("SMTP_FROM is not set, but is required when SMTP_HOST is set.")
+	}
+
+	return app.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+}
+
+// trustedProxies returns the IP addresses or CIDR ranges of reverse proxies from TRUSTED_PROXIES, separated by
+// commas. The client IP address, which failed logins are counted by, is only taken from the X-Forwarded-For header of
+// requests from these, as anyone else can put anything in it. Without TRUSTED_PROXIES, no proxies are trusted.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// baseURL returns the URL the application is reached on from BASE_URL, like https://taskeroo.example.com, which links
+// in emails are built from. The host of a request can't be used, as anyone can send a request with another host. It is
+// required in production. Otherwise the application is assumed to run on localhost with the given port.
+func baseURL(port string) string {
+	value := os.Getenv("BASE_URL")
+	if value == "" {
+		if os.Getenv("ENVIRONMENT") == "prod" {
+			log.Fatalf("BASE_URL is not set, but is required.")
+		}
+		return "http://localhost:" + port
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		log.Fatalf("BASE_URL must be the scheme and host the application is reached on, like https://taskeroo.example.com, but was: %s\n", value)
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// secretKey returns the key from SECRET_KEY, which signs tokens like the links in verification emails. It is required
+// in production. Otherwise a random key is used, so links stop working when the application restarts.
+func secretKey() []byte {
+	key := os.Getenv("SECRET_KEY")
+	if key != "" {
+		return []byte(key)
+	}
+	if os.Getenv("ENVIRONMENT") == "prod" {
+		log.Fatalf("SECRET_KEY is not set, but is required.")
+	}
+
+	log.Printf("SECRET_KEY is not set, so a random key is used until the application restarts.\n")
+	randomKey, err := util.RandomToken(32)
+	if err != nil {
+		log.Fatalf("Failed to generate secret key: %s\n", err)
+	}
+	return []byte(randomKey)
+}
+
+// encryptionKey returns the key from ENCRYPTION_KEY, which encrypts secrets stored in the database, like the TOTP
+// secrets of two-factor authentication. It is required in production, as the secrets can't be decrypted if it is
+// changed. Otherwise the secret key is used.
+func encryptionKey(secretKey []byte) []byte {
+	key := os.Getenv("ENCRYPTION_KEY")
+	if key != "" {
+		return []byte(key)
+	}
+	if os.Getenv("ENVIRONMENT") == "prod" {
+		log.Fatalf("ENCRYPTION_KEY is not set, but is required.")
+	}
+
+	return secretKey
+}
+
+// sessionKeys returns the keys from SESSION_KEYS, separated by commas, which sign the session cookie. The first key
+// signs new cookies, while cookies signed with the others are still accepted, so a key can be rotated by adding a new
+// key first and removing the old one when the cookies signed with it have expired. Without SESSION_KEYS, the secret
+// key is used.
+func sessionKeys(secretKey []byte) [][]byte {
+	var keys [][]byte
+	for _, key := range strings.Split(os.Getenv("SESSION_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+	if len(keys) == 0 {
+		return [][]byte{secretKey}
+	}
+	return keys
+}
+
+// oauthProviderName is the format of the names in OIDC_PROVIDERS, which are used in URLs.
+var oauthProviderName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// oauthProviders returns the providers users can log in with. Google is enabled by GOOGLE_CLIENT_ID and
+// GOOGLE_CLIENT_SECRET, and GitHub by GITHUB_CLIENT_ID and GITHUB_CLIENT_SECRET. Any other OpenID Connect provider is
+// added by putting its name in OIDC_PROVIDERS, separated by commas, and setting OIDC_<NAME>_ISSUER,
+// OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and optionally OIDC_<NAME>_DISPLAY_NAME. A provider which can't be
+// reached when the application starts is left out.
+func oauthProviders() []*util.OAuthProvider {
+	type oidcConfig struct {
+		id, name, issuer, clientID, clientSecret string
+	}
+	var configs []oidcConfig
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		configs = append(configs, oidcConfig{"google", "Google", "https://accounts.google.com", clientID, os.Getenv("GOOGLE_CLIENT_SECRET")})
+	}
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !oauthProviderName.MatchString(id) || id == "google" || id == "github" {
+			log.Fatalf("OIDC_PROVIDERS must be lowercase names other than google and github, but contained: %s\n", id)
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		config := oidcConfig{id, os.Getenv(prefix + "DISPLAY_NAME"), os.Getenv(prefix + "ISSUER"), os.Getenv(prefix + "CLIENT_ID"), os.Getenv(prefix + "CLIENT_SECRET")}
+		if config.issuer == "" || config.clientID == "" {
+			log.Fatalf("%sISSUER and %sCLIENT_ID are required for the provider %s.\n", prefix, prefix, id)
+		}
+		if config.name == "" {
+			config.name = id
+		}
+		configs = append(configs, config)
+	}
+
+	var providers []*util.OAuthProvider
+	for _, config := range configs {
+		provider, err := util.DiscoverOIDCProvider(context.Background(), config.id, config.name, config.issuer, config.clientID, config.clientSecret)
+		if err != nil {
+			log.Printf("Failed to discover the OpenID Connect provider %s, so logging in with it is disabled: %s\n", config.id, err)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		providers = append(providers, util.NewGitHubProvider(clientID, os.Getenv("GITHUB_CLIENT_SECRET")))
+	}
+
+	return providers
 }
 
 func HTML(ctx *gin.Context, status int, templateName string, obj gin.H) {
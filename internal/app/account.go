@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Account is what a user can change about their own account.
+type Account struct {
+	Name             string
+	Email            string
+	EmailVerified    bool
+	TwoFactorEnabled bool
+	// RecoveryCodesLeft is the number of unused recovery codes, if two-factor authentication is enabled.
+	RecoveryCodesLeft int64
+}
+
+func (a *AuthLogic) GetAccount(ctx context.Context, userID string) (Account, error) {
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return Account{}, err
+	}
+	if isManaged(user) {
+		return Account{}, internalerrors.ErrManagedUser
+	}
+
+	account := Account{
+		Name:             user.Name,
+		Email:            user.Email,
+		EmailVerified:    emailVerified(user),
+		TwoFactorEnabled: user.TOTPEnabledAt != nil,
+	}
+	if account.TwoFactorEnabled {
+		account.RecoveryCodesLeft, err = a.recoveryCodeRepo.CountUnused(ctx, userID)
+		if err != nil {
+			return Account{}, err
+		}
+	}
+
+	return account, nil
+}
+
+// ChangeName changes the name the user is shown with in their groups.
+func (a *AuthLogic) ChangeName(ctx context.Context, userID string, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return internalerrors.ErrEmptyName
+	}
+
+	err := checkNotManaged(ctx, a.userRepo, userID)
+	if err != nil {
+		return err
+	}
+
+	return a.userRepo.SetName(ctx, userID, name)
+}
+
+// ChangeEmail changes the email of the user, after checking their password. The new email must be verified before
+// the user can join groups or receive notifications again, so a verification email is sent to it.
+func (a *AuthLogic) ChangeEmail(ctx context.Context, userID string, email string, password string) error {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return internalerrors.ErrInvalidEmail
+	}
+
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isManaged(user) {
+		return internalerrors.ErrManagedUser
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	if err != nil {
+		return internalerrors.ErrWrongPassword
+	}
+	if email == user.Email {
+		return nil
+	}
+
+	other, err := a.userRepo.GetByEmail(ctx, email)
+	if err == nil && other.ID != userID {
+		return internalerrors.ErrEmailTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	err = a.userRepo.SetEmail(ctx, userID, email)
+	if err != nil {
+		if errors.Is(err, database.ErrEmailExists) {
+			return internalerrors.ErrEmailTaken
+		}
+		return err
+	}
+
+	user.Email = email
+	err = a.sendVerification(ctx, user)
+	if err != nil {
+		log.Printf("Failed to send verification email to changed email of user=%s: %s\n", userID, err)
+	}
+
+	return nil
+}
+
+// ChangePassword changes the password of the user, if the current password is right. All other sessions of the user
+// are logged out, while the given session stays logged in.
+func (a *AuthLogic) ChangePassword(ctx context.Context, userID string, hashedToken string, currentPassword string, newPassword string) error {
+	if newPassword == "" {
+		return internalerrors.ErrEmptyPassword
+	}
+
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isManaged(user) {
+		return internalerrors.ErrManagedUser
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(currentPassword))
+	if err != nil {
+		return internalerrors.ErrWrongPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 0)
+	if err != nil {
+		return err
+	}
+
+	return a.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := a.userRepo.WithTx(tx).SetPassword(ctx, userID, string(hashedPassword))
+		if err != nil {
+			return err
+		}
+
+		err = a.passwordResetRepo.WithTx(tx).MarkAllUsedForUser(ctx, userID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return a.sessionRepo.WithTx(tx).DeleteAllForUserExcept(ctx, userID, hashedToken)
+	})
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	ActivityTaskCreated   = "task.created"
+	ActivityTaskEdited    = "task.edited"
+	ActivityTaskDeleted   = "task.deleted"
+	ActivityTaskRestored  = "task.restored"
+	ActivityTaskPurged    = "task.purged"
+	ActivityTaskCompleted = "task.completed"
+	ActivityTaskPostponed = "task.postponed"
+	// ActivityTaskTriggered is when a task is made due by its webhook.
+	ActivityTaskTriggered = "task.triggered"
+	// ActivityTaskClaimed is when a member takes a common task for its current occurrence.
+	ActivityTaskClaimed   = "task.claimed"
+	ActivityTaskUnclaimed = "task.unclaimed"
+	// ActivityTaskUsed is when uses are added to a usage task, by a member or its webhook.
+	ActivityTaskUsed             = "task.used"
+	ActivityGroupCreated         = "group.created"
+	ActivityGroupDeleted         = "group.deleted"
+	ActivitySettingsChanged      = "group.settings_changed"
+	ActivityOwnershipTransferred = "group.ownership_transferred"
+	ActivityMemberJoined         = "member.joined"
+	// ActivityMemberApproved is when a request to join the group is approved.
+	ActivityMemberApproved    = "member.approved"
+	ActivityMemberLeft        = "member.left"
+	ActivityMemberRemoved     = "member.removed"
+	ActivityMemberRoleChanged = "member.role_changed"
+	ActivityChildAdded        = "member.child_added"
+	ActivityChildEdited       = "member.child_edited"
+	// ActivityCompletionRequested is when a member whose completions need approval completes a task.
+	ActivityCompletionRequested = "task.completion_requested"
+	ActivityCompletionRejected  = "task.completion_rejected"
+)
+
+// activityPageSize is the number of activities returned at a time.
+const activityPageSize = 50
+
+type ActivityLogic struct {
+	activityRepo *database.ActivityRepo
+	userRepo     *database.UserRepo
+	groupRepo    *database.GroupRepo
+}
+
+// Change is a field that was changed, with its value before and after the change.
+type Change struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type Activity struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	ActorID   *string   `json:"actorId"`
+	ActorName string    `json:"actorName"`
+	SubjectID *string   `json:"subjectId"`
+	Subject   string    `json:"subject"`
+	Changes   []Change  `json:"changes"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Time is when the activity happened, formatted in the timezone and language of the group.
+	Time string `json:"-"`
+}
+
+func NewActivityLogic(activityRepo *database.ActivityRepo, userRepo *database.UserRepo, groupRepo *database.GroupRepo) *ActivityLogic {
+	return &ActivityLogic{
+		activityRepo: activityRepo,
+		userRepo:     userRepo,
+		groupRepo:    groupRepo,
+	}
+}
+
+// GetForGroup returns the activities of the group which happened before the given time and ID, newest first. Use the
+// time and ID of the last returned activity to get the next page.
+func (l *ActivityLogic) GetForGroup(ctx context.Context, userID string, groupID string, before time.Time, beforeID string) ([]Activity, error) {
+	_, err := getMember(ctx, l.userRepo, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	loc := groupLocale(group)
+
+	activities, err := l.activityRepo.GetForGroup(ctx, groupID, before, beforeID, activityPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	userNames := map[string]string{}
+	output := []Activity{}
+	for _, activity := range activities {
+		actorName := "Taskeroo"
+		if activity.ActorID != nil {
+			name, ok := userNames[*activity.ActorID]
+			if !ok {
+				user, err := l.userRepo.Get(ctx, *activity.ActorID)
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+				name = "Ukendt"
+				if user != nil {
+					name = user.Name
+				}
+				userNames[*activity.ActorID] = name
+			}
+			actorName = name
+		}
+
+		var changes []Change
+		if activity.Changes != "" {
+			err = json.Unmarshal([]byte(activity.Changes), &changes)
+			if err != nil {
+				log.Printf("Failed to decode changes of activity=%s: %s\n", activity.ID, err)
+			}
+		}
+
+		createdAt := activity.CreatedAt.In(loc.location)
+		output = append(output, Activity{
+			ID:        activity.ID,
+			Action:    activity.Action,
+			ActorID:   activity.ActorID,
+			ActorName: actorName,
+			SubjectID: activity.SubjectID,
+			Subject:   activity.Subject,
+			Changes:   changes,
+			CreatedAt: activity.CreatedAt,
+			Time:      fmt.Sprintf("%s %s", dateFormat(createdAt, loc), createdAt.Format("15:04")),
+		})
+	}
+
+	return output, nil
+}
+
+// recordActivity appends an activity to the log of the group, using an activity repo which may be part of a
+// transaction. An empty actorID means the activity was done by the system.
+func recordActivity(
+	ctx context.Context,
+	activityRepo *database.ActivityRepo,
+	groupID string,
+	actorID string,
+	action string,
+	subjectID string,
+	subject string,
+	changes []Change,
+) error {
+	activity := database.Activity{
+		ID:        uuid.NewString(),
+		GroupID:   groupID,
+		Action:    action,
+		Subject:   subject,
+		CreatedAt: time.Now(),
+	}
+	if actorID != "" {
+		activity.ActorID = &actorID
+	}
+	if subjectID != "" {
+		activity.SubjectID = &subjectID
+	}
+	if len(changes) > 0 {
+		encoded, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		activity.Changes = string(encoded)
+	}
+
+	return activityRepo.Create(ctx, activity)
+}
+
+// addChange adds a change to the list if the value before and after differ.
+func addChange(changes []Change, field string, before string, after string) []Change {
+	if before == after {
+		return changes
+	}
+	return append(changes, Change{Field: field, Before: before, After: after})
+}
+
+func formatInterval(size int, unit string) string {
+	if unit == "onetime" {
+		return unit
+	}
+	return strconv.Itoa(size) + " " + unit
+}
@@ -5,133 +5,420 @@ import (
 	"errors"
 	"github.com/dentych/taskeroo/internal/database"
 	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"log"
+	"strings"
 	"time"
 )
 
 type AuthLogic struct {
-	sessionRepo *database.SessionRepo
-	userRepo    *database.UserRepo
-	groupRepo   *database.GroupRepo
+	transactor        *database.Transactor
+	sessionRepo       *database.SessionRepo
+	userRepo          *database.UserRepo
+	groupRepo         *database.GroupRepo
+	membershipRepo    *database.MembershipRepo
+	passwordResetRepo *database.PasswordResetRepo
+	recoveryCodeRepo  *database.RecoveryCodeRepo
+	invitationLogic   *InvitationLogic
+	groupLogic        *GroupLogic
+	loginLimiter      *LoginLimiter
+	mailer            Mailer
+	// baseURL is the URL the application is reached on, which links in emails are built from.
+	baseURL string
+	// secretKey signs the tokens in verification emails and of logins waiting for a second factor.
+	secretKey []byte
+	// encryptionKey encrypts the TOTP secrets of users.
+	encryptionKey []byte
 }
 
-func NewAuthLogic(sessionRepo *database.SessionRepo, userRepo *database.UserRepo, groupRepo *database.GroupRepo) *AuthLogic {
+func NewAuthLogic(
+	transactor *database.Transactor,
+	sessionRepo *database.SessionRepo,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	passwordResetRepo *database.PasswordResetRepo,
+	recoveryCodeRepo *database.RecoveryCodeRepo,
+	invitationLogic *InvitationLogic,
+	groupLogic *GroupLogic,
+	loginLimiter *LoginLimiter,
+	mailer Mailer,
+	baseURL string,
+	secretKey []byte,
+	encryptionKey []byte,
+) *AuthLogic {
 	return &AuthLogic{
-		sessionRepo: sessionRepo,
-		userRepo:    userRepo,
-		groupRepo:   groupRepo,
+		transactor:        transactor,
+		sessionRepo:       sessionRepo,
+		userRepo:          userRepo,
+		groupRepo:         groupRepo,
+		membershipRepo:    membershipRepo,
+		passwordResetRepo: passwordResetRepo,
+		recoveryCodeRepo:  recoveryCodeRepo,
+		invitationLogic:   invitationLogic,
+		groupLogic:        groupLogic,
+		loginLimiter:      loginLimiter,
+		mailer:            mailer,
+		baseURL:           baseURL,
+		secretKey:         secretKey,
+		encryptionKey:     encryptionKey,
 	}
 }
 
-func (a *AuthLogic) IsAuthenticated(ctx context.Context, userID string, session string) (bool, error) {
-	_, err := a.sessionRepo.Get(ctx, userID, session)
+// Authenticate returns the session with the token if it exists and hasn't expired. Expired sessions are deleted, and
+// gorm.ErrRecordNotFound is returned as if they didn't exist. Sessions which are used are extended, so they only
+// expire when they haven't been used for SessionLifetime. If the user is no longer a member of the session's current
+// group, the current group is changed to another group the user is a member of, if any.
+func (a *AuthLogic) Authenticate(ctx context.Context, token string) (UserSession, error) {
+	hashedToken := util.HashToken(token)
+	dbSession, err := a.sessionRepo.GetByHashedToken(ctx, hashedToken)
 	if err != nil {
-		return false, err
+		return UserSession{}, err
+	}
+	userID := dbSession.UserID
+
+	now := time.Now()
+	if !dbSession.ExpiresAt.After(now) {
+		err = a.sessionRepo.Delete(ctx, hashedToken)
+		if err != nil {
+			return UserSession{}, err
+		}
+		return UserSession{}, gorm.ErrRecordNotFound
+	}
+
+	renewed := false
+	if now.Sub(dbSession.LastSeenAt) > lastSeenInterval {
+		err = a.sessionRepo.Extend(ctx, hashedToken, now, now.Add(SessionLifetime))
+		if err != nil {
+			log.Printf("Failed to extend session for user=%s: %s\n", userID, err)
+		} else {
+			renewed = true
+		}
+	}
+
+	groupID := dbSession.GroupID
+	if groupID != nil {
+		_, err = a.membershipRepo.Get(ctx, userID, *groupID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return UserSession{}, err
+		}
+		if err != nil {
+			groupID = nil
+		}
+	}
+
+	if groupID == nil {
+		groupID, err = a.defaultGroup(ctx, userID)
+		if err != nil {
+			return UserSession{}, err
+		}
+		if groupID != nil {
+			err = a.sessionRepo.SetGroup(ctx, hashedToken, groupID)
+			if err != nil {
+				return UserSession{}, err
+			}
+		}
+	}
+
+	return UserSession{UserID: userID, HashedToken: hashedToken, GroupID: groupID, Renewed: renewed}, nil
+}
+
+// SwitchGroup changes the current group of the session.
+func (a *AuthLogic) SwitchGroup(ctx context.Context, userID string, hashedToken string, groupID string) error {
+	_, err := getMember(ctx, a.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	return a.sessionRepo.SetGroup(ctx, hashedToken, &groupID)
+}
+
+// defaultGroup returns the group the user joined first, or nil if the user is not a member of any groups.
+func (a *AuthLogic) defaultGroup(ctx context.Context, userID string) (*string, error) {
+	groups, err := a.membershipRepo.GetGroupsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	return true, nil
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	return &groups[0].ID, nil
 }
 
 type UserSession struct {
-	UserID  string
-	Session string
+	UserID string
+	// Token is the token of the session, which is given to the client. It is only set when the session is created,
+	// as only its hash is stored.
+	Token string
+	// HashedToken identifies the session without revealing the token.
+	HashedToken string
+	// GroupID is the current group of the session, or nil if the user is not a member of any groups.
+	GroupID *string
+	// Renewed is true if the expiry of the session was moved forward, so the client should be told to keep the token
+	// for longer.
+	Renewed bool
 }
 
-func (a *AuthLogic) Login(ctx context.Context, email string, password string) (UserSession, error) {
+// Login logs in the user with the email and password. After too many failed attempts from the client's IP address or
+// on the account, a TooManyAttemptsError is returned without checking the password. If the user has two-factor
+// authentication enabled, a TwoFactorRequiredError is returned instead of a session, and the login is completed by
+// LoginWithTwoFactor.
+func (a *AuthLogic) Login(ctx context.Context, email string, password string, client ClientInfo) (UserSession, error) {
+	attempt, err := a.loginLimiter.attempt(ctx, client.IPAddress, emailKey(email))
+	if err != nil {
+		return UserSession{}, err
+	}
+
 	user, err := a.userRepo.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return UserSession{}, err
+	}
+
+	if user == nil || isManaged(user) || bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)) != nil {
+		userID := ""
+		if user != nil && !isManaged(user) {
+			userID = user.ID
+		}
+		err = a.loginLimiter.fail(ctx, attempt, userID)
+		if err != nil {
+			return UserSession{}, err
+		}
+		return UserSession{}, internalerrors.ErrInvalidEmailOrPassword
+	}
+
+	err = a.loginLimiter.succeed(ctx, attempt)
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return UserSession{}, internalerrors.TwoFactorRequiredError{Token: a.twoFactorToken(user.ID, time.Now())}
+	}
+
+	groupID, err := a.defaultGroup(ctx, user.ID)
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	return a.createSession(ctx, user.ID, groupID, client)
+}
+
+// LoginWithPIN logs in a managed member of the group with the given code, e.g. a child on a shared tablet. The
+// member is found by name, as managed members have no email.
+func (a *AuthLogic) LoginWithPIN(ctx context.Context, code string, name string, pin string, client ClientInfo) (UserSession, error) {
+	code, ok := util.NormalizeCode(code)
+	attempt, err := a.loginLimiter.attempt(ctx, client.IPAddress, pinKey(code, name))
+	if err != nil {
+		return UserSession{}, err
+	}
+	if !ok {
+		return UserSession{}, internalerrors.ErrInvalidCodeNameOrPIN
+	}
+
+	group, err := a.groupRepo.GetByCode(ctx, code)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return UserSession{}, internalerrors.ErrInvalidEmailOrPassword
+			return UserSession{}, internalerrors.ErrInvalidCodeNameOrPIN
 		}
 		return UserSession{}, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	users, err := a.userRepo.GetManagedByGroup(ctx, group.ID)
 	if err != nil {
-		return UserSession{}, internalerrors.ErrInvalidEmailOrPassword
+		return UserSession{}, err
+	}
+
+	for _, user := range users {
+		if user.HashedPIN == "" || !strings.EqualFold(user.Name, strings.TrimSpace(name)) {
+			continue
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(user.HashedPIN), []byte(pin))
+		if err != nil {
+			continue
+		}
+
+		err = a.loginLimiter.succeed(ctx, attempt)
+		if err != nil {
+			return UserSession{}, err
+		}
+		return a.createSession(ctx, user.ID, &group.ID, client)
+	}
+
+	return UserSession{}, internalerrors.ErrInvalidCodeNameOrPIN
+}
+
+func (a *AuthLogic) createSession(ctx context.Context, userID string, groupID *string, client ClientInfo) (UserSession, error) {
+	token, err := util.RandomToken(sessionTokenBytes)
+	if err != nil {
+		return UserSession{}, err
 	}
 
-	session := uuid.NewString()
+	hashedToken := util.HashToken(token)
+	now := time.Now()
 	err = a.sessionRepo.Create(ctx, database.Session{
-		UserID:    user.ID,
-		Session:   session,
-		CreatedAt: time.Now(),
+		UserID:      userID,
+		HashedToken: hashedToken,
+		GroupID:     groupID,
+		ExpiresAt:   now.Add(SessionLifetime),
+		LastSeenAt:  now,
+		UserAgent:   client.UserAgent,
+		IPAddress:   client.IPAddress,
+		CreatedAt:   now,
 	})
 	if err != nil {
 		return UserSession{}, err
 	}
 
-	return UserSession{UserID: user.ID, Session: session}, nil
+	return UserSession{UserID: userID, Token: token, HashedToken: hashedToken, GroupID: groupID}, nil
 }
 
+// Register creates a user, and sends an email with a link to verify the email. The user can log in right away, but
+// can't join groups or receive notifications until the email is verified.
 func (a *AuthLogic) Register(ctx context.Context, email, name, password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 0)
 	if err != nil {
 		return err
 	}
 
-	err = a.userRepo.Create(ctx, database.User{
+	user := database.User{
 		ID:             uuid.NewString(),
 		Email:          email,
 		Name:           name,
 		HashedPassword: string(hashedPassword),
 		CreatedAt:      time.Now(),
 		LastLogin:      time.Now(),
-	})
+	}
+	err = a.userRepo.Create(ctx, user)
 	if err != nil {
 		return err
 	}
 
+	err = a.sendVerification(ctx, &user)
+	if err != nil {
+		log.Printf("Failed to send verification email to new user=%s: %s\n", user.ID, err)
+	}
+
 	return nil
 }
 
 type Profile struct {
-	Email      string
-	Name       string
-	GroupID    *string
-	GroupName  string
-	GroupOwner bool
-	Members    []string
+	Email string
+	Name  string
+	// Managed is true if the user has no login of their own, and can't create or join groups.
+	Managed bool
+	// EmailVerified is false until the user has followed the link in the verification email.
+	EmailVerified bool
+	GroupID       *string
+	GroupName     string
+	GroupOwner    bool
+	// Role is the user's role in the group.
+	Role string
+	// Permissions tells whether the user's role grants each of the permissions in Permissions.
+	Permissions map[string]bool
+	Members     []ProfileMember
+	// Invitations are the pending invitations sent to the user's email.
+	Invitations []InvitationInfo
+	// GroupCode and JoinRequests are only set if the user can manage the members of the group.
+	GroupCode    string
+	JoinRequests []JoinRequest
+	// PendingJoinRequests are the user's own requests to join other groups.
+	PendingJoinRequests []PendingJoinRequest
+}
+
+type ProfileMember struct {
+	ID   string
+	Name string
+	Role string
+	// Managed is true for members without their own login, like children.
+	Managed bool
 }
 
-func (a *AuthLogic) GetProfile(ctx context.Context, userID string) (Profile, error) {
+// GetProfile returns the profile of the user, with information about the given group. The group may be empty, if the
+// user is not a member of any groups.
+func (a *AuthLogic) GetProfile(ctx context.Context, userID string, groupID string) (Profile, error) {
 	user, err := a.userRepo.Get(ctx, userID)
 	if err != nil {
 		return Profile{}, err
 	}
 
+	var profileGroupID *string
 	groupName := ""
 	groupOwner := false
-	var members []string
-	if user.GroupID != nil {
-		group, err := a.groupRepo.Get(ctx, *user.GroupID)
+	role := ""
+	var members []ProfileMember
+	groupCode := ""
+	var joinRequests []JoinRequest
+	if groupID != "" {
+		role, err = getRole(ctx, a.groupRepo, a.membershipRepo, userID, groupID)
 		if err != nil {
 			return Profile{}, err
 		}
+		group, err := a.groupRepo.Get(ctx, groupID)
+		if err != nil {
+			return Profile{}, err
+		}
+		profileGroupID = &group.ID
 		groupName = group.Name
 		groupOwner = group.OwnerUserID == user.ID
-		users, err := a.userRepo.GetByGroup(ctx, *user.GroupID)
+		users, err := a.userRepo.GetByGroup(ctx, groupID)
+		if err != nil {
+			return Profile{}, err
+		}
+		memberships, err := a.membershipRepo.GetForGroup(ctx, groupID)
 		if err != nil {
 			return Profile{}, err
 		}
+		roles := map[string]string{}
+		for _, membership := range memberships {
+			roles[membership.UserID] = membership.Role
+		}
+		roles[group.OwnerUserID] = RoleOwner
 
 		for _, user := range users {
-			members = append(members, user.Name)
+			members = append(members, ProfileMember{ID: user.ID, Name: user.Name, Role: roles[user.ID], Managed: isManaged(&user)})
+		}
+
+		if HasPermission(role, PermissionManageMembers) {
+			groupCode, err = a.groupLogic.GetCode(ctx, userID, groupID)
+			if err != nil {
+				return Profile{}, err
+			}
+			joinRequests, err = a.groupLogic.GetJoinRequests(ctx, userID, groupID)
+			if err != nil {
+				return Profile{}, err
+			}
 		}
 	}
 
+	invitations, err := a.invitationLogic.GetPendingForUser(ctx, userID)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	pendingJoinRequests, err := a.groupLogic.GetPendingJoinRequests(ctx, userID)
+	if err != nil {
+		return Profile{}, err
+	}
+
 	return Profile{
-		Email:      user.Email,
-		Name:       user.Name,
-		GroupID:    user.GroupID,
-		GroupName:  groupName,
-		GroupOwner: groupOwner,
-		Members:    members,
+		Email:               user.Email,
+		Managed:             isManaged(user),
+		EmailVerified:       emailVerified(user),
+		Name:                user.Name,
+		GroupID:             profileGroupID,
+		GroupName:           groupName,
+		GroupOwner:          groupOwner,
+		Role:                role,
+		Permissions:         PermissionsForRole(role),
+		Members:             members,
+		Invitations:         invitations,
+		GroupCode:           groupCode,
+		JoinRequests:        joinRequests,
+		PendingJoinRequests: pendingJoinRequests,
 	}, nil
 }
-
-func (a *AuthLogic) LeaveGroup(ctx context.Context, userID string) error {
-	return a.userRepo.SetGroup(ctx, userID, nil)
-}
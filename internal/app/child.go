@@ -0,0 +1,237 @@
+package app
+
+import (
+	"context"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// ChildLogic manages the accounts of members who have no email or password, like children. They are created by a
+// member who can manage members, and may log in with a PIN on a shared device.
+type ChildLogic struct {
+	transactor     *database.Transactor
+	userRepo       *database.UserRepo
+	groupRepo      *database.GroupRepo
+	membershipRepo *database.MembershipRepo
+	activityRepo   *database.ActivityRepo
+}
+
+type Child struct {
+	ID               string
+	Name             string
+	HasPIN           bool
+	RequiresApproval bool
+}
+
+type NewChild struct {
+	Name string
+	// PIN is used to log in on a shared device. An empty PIN means the child can't log in.
+	PIN              string
+	RequiresApproval bool
+}
+
+func NewChildLogic(
+	transactor *database.Transactor,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	activityRepo *database.ActivityRepo,
+) *ChildLogic {
+	return &ChildLogic{
+		transactor:     transactor,
+		userRepo:       userRepo,
+		groupRepo:      groupRepo,
+		membershipRepo: membershipRepo,
+		activityRepo:   activityRepo,
+	}
+}
+
+// Create creates a managed account for a child, and makes it a member of the group with the child role.
+func (l *ChildLogic) Create(ctx context.Context, userID string, groupID string, newChild NewChild) (string, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return "", err
+	}
+
+	hashedPIN, err := hashPIN(newChild.PIN)
+	if err != nil {
+		return "", err
+	}
+
+	childID := uuid.NewString()
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.userRepo.WithTx(tx).Create(ctx, database.User{
+			ID:               childID,
+			Name:             newChild.Name,
+			ManagedBy:        &userID,
+			HashedPIN:        hashedPIN,
+			RequiresApproval: newChild.RequiresApproval,
+			CreatedAt:        time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = l.membershipRepo.WithTx(tx).Create(ctx, childID, groupID, RoleChild)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityChildAdded, childID, newChild.Name, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return childID, nil
+}
+
+// GetForGroup returns the managed members of the group.
+func (l *ChildLogic) GetForGroup(ctx context.Context, userID string, groupID string) ([]Child, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := l.userRepo.GetManagedByGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []Child
+	for _, user := range users {
+		output = append(output, mapChild(user))
+	}
+
+	return output, nil
+}
+
+func (l *ChildLogic) Get(ctx context.Context, userID string, groupID string, childID string) (Child, error) {
+	child, err := l.getChild(ctx, userID, groupID, childID)
+	if err != nil {
+		return Child{}, err
+	}
+
+	return mapChild(*child), nil
+}
+
+// Update changes the name of the child and whether completions need approval. The PIN is only changed if a new one
+// is given, or removed if removePIN is true.
+func (l *ChildLogic) Update(ctx context.Context, userID string, groupID string, childID string, update NewChild, removePIN bool) error {
+	child, err := l.getChild(ctx, userID, groupID, childID)
+	if err != nil {
+		return err
+	}
+
+	hashedPIN := child.HashedPIN
+	if removePIN {
+		hashedPIN = ""
+	} else if update.PIN != "" {
+		hashedPIN, err = hashPIN(update.PIN)
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		userRepo := l.userRepo.WithTx(tx)
+		err := userRepo.UpdateManaged(ctx, childID, update.Name, update.RequiresApproval)
+		if err != nil {
+			return err
+		}
+
+		err = userRepo.SetPIN(ctx, childID, hashedPIN)
+		if err != nil {
+			return err
+		}
+
+		var changes []Change
+		changes = addChange(changes, "name", child.Name, update.Name)
+		changes = addChange(changes, "requiresApproval", strconv.FormatBool(child.RequiresApproval), strconv.FormatBool(update.RequiresApproval))
+		if removePIN && child.HashedPIN != "" {
+			changes = append(changes, Change{Field: "pin", After: "removed"})
+		} else if hashedPIN != child.HashedPIN {
+			changes = append(changes, Change{Field: "pin", After: "changed"})
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityChildEdited, childID, update.Name, changes)
+	})
+}
+
+func (l *ChildLogic) getChild(ctx context.Context, userID string, groupID string, childID string) (*database.User, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	child, err := getMember(ctx, l.userRepo, childID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if child.ManagedBy == nil {
+		return nil, internalerrors.ErrNotManagedUser
+	}
+
+	return child, nil
+}
+
+func mapChild(user database.User) Child {
+	return Child{
+		ID:               user.ID,
+		Name:             user.Name,
+		HasPIN:           user.HashedPIN != "",
+		RequiresApproval: user.RequiresApproval,
+	}
+}
+
+// hashPIN validates and hashes a PIN. An empty PIN gives an empty hash, which can't be used to log in.
+func hashPIN(pin string) (string, error) {
+	if pin == "" {
+		return "", nil
+	}
+	if len(pin) < 4 || len(pin) > 8 {
+		return "", internalerrors.ErrInvalidPIN
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return "", internalerrors.ErrInvalidPIN
+		}
+	}
+
+	hashedPIN, err := bcrypt.GenerateFromPassword([]byte(pin), 0)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashedPIN), nil
+}
+
+// isManaged tells whether the user is a managed account, which can't create or join groups by itself.
+func isManaged(user *database.User) bool {
+	return user.ManagedBy != nil
+}
+
+// needsApproval tells whether the completions of the user must be approved before they count.
+func needsApproval(user *database.User) bool {
+	return isManaged(user) && user.RequiresApproval
+}
+
+// checkNotManaged returns ErrManagedUser if the user is a managed account.
+func checkNotManaged(ctx context.Context, userRepo *database.UserRepo, userID string) error {
+	user, err := userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isManaged(user) {
+		return internalerrors.ErrManagedUser
+	}
+
+	return nil
+}
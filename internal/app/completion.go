@@ -0,0 +1,214 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// PendingCompletion is a completion of a task which awaits approval.
+type PendingCompletion struct {
+	ID        string
+	TaskID    string
+	TaskTitle string
+	UserName  string
+	CreatedAt string
+}
+
+// requestApproval records that the user has completed the task, but doesn't complete it until the completion is
+// approved. The members who can approve completions are notified.
+func (t *TaskLogic) requestApproval(ctx context.Context, user *database.User, task *database.Task) error {
+	err := t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.createCompletion(ctx, t.completionRepo.WithTx(tx), user, task)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), task.GroupID, user.ID, ActivityCompletionRequested, task.ID, task.Title, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyWithPermission(ctx, t.userRepo, t.groupRepo, t.membershipRepo, t.notificationLogic, task.GroupID, PermissionApproveCompletions,
+		fmt.Sprintf("%s har udført opgaven '%s'. Godkend eller afvis det på forsiden.", user.Name, task.Title))
+
+	return nil
+}
+
+// createCompletion creates a pending completion, using a completion repo which may be part of a transaction.
+func (t *TaskLogic) createCompletion(ctx context.Context, completionRepo *database.CompletionRepo, user *database.User, task *database.Task) error {
+	_, err := completionRepo.GetPendingForTask(ctx, task.ID)
+	if err == nil {
+		return internalerrors.ErrCompletionPending
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return completionRepo.Create(ctx, database.Completion{
+		ID:        uuid.NewString(),
+		TaskID:    task.ID,
+		GroupID:   task.GroupID,
+		UserID:    user.ID,
+		Status:    database.CompletionPending,
+		CreatedAt: time.Now(),
+	})
+}
+
+// GetPendingCompletions returns the completions in the group which await approval.
+func (t *TaskLogic) GetPendingCompletions(ctx context.Context, userID string, groupID string) ([]PendingCompletion, error) {
+	err := t.authorize(ctx, userID, groupID, PermissionApproveCompletions)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := t.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	loc := groupLocale(group)
+
+	completions, err := t.completionRepo.GetPendingForGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []PendingCompletion
+	for _, completion := range completions {
+		task, err := t.taskRepo.Get(ctx, completion.TaskID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		userName, err := t.userName(ctx, &completion.UserID)
+		if err != nil {
+			return nil, err
+		}
+		createdAt := completion.CreatedAt.In(loc.location)
+		output = append(output, PendingCompletion{
+			ID:        completion.ID,
+			TaskID:    task.ID,
+			TaskTitle: task.Title,
+			UserName:  userName,
+			CreatedAt: fmt.Sprintf("%s %s", dateFormat(createdAt, loc), createdAt.Format("15:04")),
+		})
+	}
+
+	return output, nil
+}
+
+// ApproveCompletion completes the task on behalf of the member who completed it. The task is read again when it is
+// completed, so a task which was deleted or moved after the completion was requested isn't completed.
+func (t *TaskLogic) ApproveCompletion(ctx context.Context, userID string, groupID string, completionID string) error {
+	completion, _, err := t.getPendingCompletion(ctx, userID, groupID, completionID)
+	if err != nil {
+		return err
+	}
+
+	member, err := getMember(ctx, t.userRepo, completion.UserID, groupID)
+	if err != nil {
+		return err
+	}
+
+	approver, err := t.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var task *database.Task
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.completionRepo.WithTx(tx).Decide(ctx, completion.ID, database.CompletionApproved, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return internalerrors.ErrCompletionNotFound
+			}
+			return err
+		}
+
+		task, err = t.taskRepo.WithTx(tx).GetForUpdate(ctx, completion.TaskID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return internalerrors.ErrCompletionNotFound
+			}
+			return err
+		}
+		if task.GroupID != groupID {
+			return internalerrors.ErrCompletionNotFound
+		}
+
+		err = t.complete(ctx, t.taskRepo.WithTx(tx), member, task)
+		if err != nil {
+			return err
+		}
+
+		changes := []Change{{Field: "approvedBy", After: approver.Name}}
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, member.ID, ActivityTaskCompleted, task.ID, task.Title, changes)
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s har lige udført opgaven '%s'", member.Name, task.Title))
+}
+
+// RejectCompletion rejects the completion, so the task stays due.
+func (t *TaskLogic) RejectCompletion(ctx context.Context, userID string, groupID string, completionID string) error {
+	completion, task, err := t.getPendingCompletion(ctx, userID, groupID, completionID)
+	if err != nil {
+		return err
+	}
+
+	memberName, err := t.userName(ctx, &completion.UserID)
+	if err != nil {
+		return err
+	}
+
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.completionRepo.WithTx(tx).Decide(ctx, completion.ID, database.CompletionRejected, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return internalerrors.ErrCompletionNotFound
+			}
+			return err
+		}
+
+		changes := []Change{{Field: "completedBy", After: memberName}}
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityCompletionRejected, task.ID, task.Title, changes)
+	})
+}
+
+func (t *TaskLogic) getPendingCompletion(ctx context.Context, userID string, groupID string, completionID string) (*database.Completion, *database.Task, error) {
+	err := t.authorize(ctx, userID, groupID, PermissionApproveCompletions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	completion, err := t.completionRepo.Get(ctx, completionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, internalerrors.ErrCompletionNotFound
+		}
+		return nil, nil, err
+	}
+	if completion.GroupID != groupID || completion.Status != database.CompletionPending {
+		return nil, nil, internalerrors.ErrCompletionNotFound
+	}
+
+	task, err := t.taskRepo.Get(ctx, completion.TaskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, internalerrors.ErrCompletionNotFound
+		}
+		return nil, nil, err
+	}
+
+	return completion, task, nil
+}
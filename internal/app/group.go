@@ -0,0 +1,516 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+type GroupLogic struct {
+	transactor        *database.Transactor
+	groupRepo         *database.GroupRepo
+	userRepo          *database.UserRepo
+	membershipRepo    *database.MembershipRepo
+	taskRepo          *database.TaskRepo
+	invitationRepo    *database.InvitationRepo
+	joinRequestRepo   *database.JoinRequestRepo
+	activityRepo      *database.ActivityRepo
+	notificationLogic *NotificationLogic
+}
+
+type GroupSummary struct {
+	ID   string
+	Name string
+}
+
+func NewGroupLogic(
+	transactor *database.Transactor,
+	groupRepo *database.GroupRepo,
+	userRepo *database.UserRepo,
+	membershipRepo *database.MembershipRepo,
+	taskRepo *database.TaskRepo,
+	invitationRepo *database.InvitationRepo,
+	joinRequestRepo *database.JoinRequestRepo,
+	activityRepo *database.ActivityRepo,
+	notificationLogic *NotificationLogic,
+) *GroupLogic {
+	return &GroupLogic{
+		transactor:        transactor,
+		groupRepo:         groupRepo,
+		userRepo:          userRepo,
+		membershipRepo:    membershipRepo,
+		taskRepo:          taskRepo,
+		invitationRepo:    invitationRepo,
+		joinRequestRepo:   joinRequestRepo,
+		activityRepo:      activityRepo,
+		notificationLogic: notificationLogic,
+	}
+}
+
+// Create creates a group owned by the user, makes the user a member of it and returns the ID of the group.
+func (l *GroupLogic) Create(ctx context.Context, userID string, name string) (string, error) {
+	err := checkNotManaged(ctx, l.userRepo, userID)
+	if err != nil {
+		return "", err
+	}
+
+	groupID := uuid.NewString()
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.groupRepo.WithTx(tx).Create(ctx, database.Group{ID: groupID, Name: name, OwnerUserID: userID, CreatedAt: time.Now()})
+		if err != nil {
+			return err
+		}
+
+		// The owner's role follows from the group. The stored role is kept if ownership is transferred.
+		err = l.membershipRepo.WithTx(tx).Create(ctx, userID, groupID, RoleAdmin)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityGroupCreated, groupID, name, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return groupID, nil
+}
+
+// GetGroupsForUser returns the groups the user is a member of.
+func (l *GroupLogic) GetGroupsForUser(ctx context.Context, userID string) ([]GroupSummary, error) {
+	groups, err := l.membershipRepo.GetGroupsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []GroupSummary
+	for _, group := range groups {
+		output = append(output, GroupSummary{ID: group.ID, Name: group.Name})
+	}
+
+	return output, nil
+}
+
+// SetRole changes the role of a member of the group. Only the owner can change roles, and the owner's own role can't
+// be changed. Managed members, like children, always have the child role.
+func (l *GroupLogic) SetRole(ctx context.Context, userID string, groupID string, memberID string, role string) error {
+	if !validRole(role) {
+		return internalerrors.ErrInvalidRole
+	}
+
+	userRole, err := getRole(ctx, l.groupRepo, l.membershipRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+	if userRole != RoleOwner {
+		return internalerrors.ErrUserNotOwner
+	}
+	if memberID == userID {
+		return internalerrors.ErrInvalidRole
+	}
+
+	member, err := getMember(ctx, l.userRepo, memberID, groupID)
+	if err != nil {
+		return err
+	}
+	if isManaged(member) {
+		return internalerrors.ErrManagedUser
+	}
+
+	membership, err := l.membershipRepo.Get(ctx, memberID, groupID)
+	if err != nil {
+		return err
+	}
+
+	return l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.membershipRepo.WithTx(tx).SetRole(ctx, memberID, groupID, role)
+		if err != nil {
+			return err
+		}
+
+		changes := addChange(nil, "role", membership.Role, role)
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityMemberRoleChanged, memberID, member.Name, changes)
+	})
+}
+
+const (
+	// TaskHandoverUnassign makes the tasks of a departing member common tasks for everyone.
+	TaskHandoverUnassign = "unassign"
+	// TaskHandoverReassign assigns the tasks of a departing member to another member.
+	TaskHandoverReassign = "reassign"
+	// TaskHandoverRotate makes the tasks of a departing member rotate between the remaining members.
+	TaskHandoverRotate = "rotate"
+)
+
+// TaskHandover decides what happens to the tasks assigned to a member who leaves or is removed from a group.
+type TaskHandover struct {
+	// Action is one of the TaskHandover constants.
+	Action string
+	// Assignee is the member who takes over the tasks when reassigning.
+	Assignee string
+}
+
+// Leave removes the user from the group. The owner can't leave before transferring ownership.
+func (l *GroupLogic) Leave(ctx context.Context, userID string, groupID string, handover TaskHandover) error {
+	user, err := getMember(ctx, l.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	role, err := getRole(ctx, l.groupRepo, l.membershipRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		return internalerrors.ErrOwnerCannotLeave
+	}
+
+	err = l.removeMember(ctx, userID, groupID, user, ActivityMemberLeft, handover)
+	if err != nil {
+		return err
+	}
+
+	return l.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s har forladt gruppen", user.Name))
+}
+
+// RemoveMember removes another member from the group. Only the owner can remove admins, and the owner can't be
+// removed.
+func (l *GroupLogic) RemoveMember(ctx context.Context, userID string, groupID string, memberID string, handover TaskHandover) error {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return err
+	}
+
+	if memberID == userID {
+		return l.Leave(ctx, userID, groupID, handover)
+	}
+
+	member, err := getMember(ctx, l.userRepo, memberID, groupID)
+	if err != nil {
+		return err
+	}
+
+	userRole, err := getRole(ctx, l.groupRepo, l.membershipRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+	memberRole, err := getRole(ctx, l.groupRepo, l.membershipRepo, memberID, groupID)
+	if err != nil {
+		return err
+	}
+	if memberRole == RoleOwner || (memberRole == RoleAdmin && userRole != RoleOwner) {
+		return internalerrors.ErrUserNotOwner
+	}
+
+	err = l.removeMember(ctx, userID, groupID, member, ActivityMemberRemoved, handover)
+	if err != nil {
+		return err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	err = l.notificationLogic.SendNotification(ctx, memberID, fmt.Sprintf("Du er blevet fjernet fra gruppen '%s'", group.Name))
+	if err != nil {
+		log.Printf("Failed to notify user=%s about being removed from group=%s: %s\n", memberID, groupID, err)
+	}
+
+	return l.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s er blevet fjernet fra gruppen", member.Name))
+}
+
+// removeMember hands over the tasks of the member, deletes the membership and records the activity in a single
+// transaction, using removeMemberTx.
+func (l *GroupLogic) removeMember(
+	ctx context.Context,
+	userID string,
+	groupID string,
+	member *database.User,
+	action string,
+	handover TaskHandover,
+) error {
+	memberID := member.ID
+	users, err := l.userRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	switch handover.Action {
+	case TaskHandoverUnassign, TaskHandoverRotate:
+	case TaskHandoverReassign:
+		if handover.Assignee == memberID {
+			return internalerrors.ErrInvalidTaskHandover
+		}
+		_, err = getMember(ctx, l.userRepo, handover.Assignee, groupID)
+		if err != nil {
+			return internalerrors.ErrInvalidTaskHandover
+		}
+	default:
+		return internalerrors.ErrInvalidTaskHandover
+	}
+
+	return l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		return l.removeMemberTx(ctx, tx, userID, groupID, member, action, handover, users)
+	})
+}
+
+// leaveTx removes the user from the group as part of the transaction tx, like Leave with TaskHandoverUnassign. The
+// user must not be the owner, and the other members aren't notified.
+func (l *GroupLogic) leaveTx(ctx context.Context, tx *gorm.DB, user *database.User, groupID string) error {
+	return l.removeMemberTx(ctx, tx, user.ID, groupID, user, ActivityMemberLeft, TaskHandover{Action: TaskHandoverUnassign}, nil)
+}
+
+// removeMemberTx does the work of removeMember as part of the transaction tx. The handover must have been checked, and
+// users are the members of the group, which are only needed for TaskHandoverRotate.
+func (l *GroupLogic) removeMemberTx(
+	ctx context.Context,
+	tx *gorm.DB,
+	userID string,
+	groupID string,
+	member *database.User,
+	action string,
+	handover TaskHandover,
+	users []database.User,
+) error {
+	memberID := member.ID
+	taskRepo := l.taskRepo.WithTx(tx)
+	tasks, err := taskRepo.GetAllForGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if task.ClaimedBy != nil && *task.ClaimedBy == memberID {
+			err = taskRepo.SetClaimedBy(ctx, task.ID, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if task.Assignee == nil || *task.Assignee != memberID {
+			continue
+		}
+
+		switch handover.Action {
+		case TaskHandoverUnassign:
+			err = taskRepo.SetAssignee(ctx, task.ID, nil)
+		case TaskHandoverReassign:
+			err = taskRepo.SetAssignee(ctx, task.ID, &handover.Assignee)
+		case TaskHandoverRotate:
+			next := nextInRotation(users, memberID)
+			if next != nil && *next == memberID {
+				next = nil
+			}
+			err = taskRepo.SetRotatingAssignee(ctx, task.ID, next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = l.membershipRepo.WithTx(tx).Delete(ctx, memberID, groupID)
+	if err != nil {
+		return err
+	}
+
+	changes := []Change{{Field: "tasks", After: handover.Action}}
+	return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, action, memberID, member.Name, changes)
+}
+
+// TransferOwnership makes another member the owner of the group. The previous owner becomes an admin. Managed members
+// can't own the group.
+func (l *GroupLogic) TransferOwnership(ctx context.Context, userID string, groupID string, newOwnerID string) error {
+	role, err := getRole(ctx, l.groupRepo, l.membershipRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+	if role != RoleOwner {
+		return internalerrors.ErrUserNotOwner
+	}
+
+	newOwner, err := getMember(ctx, l.userRepo, newOwnerID, groupID)
+	if err != nil {
+		return err
+	}
+	if isManaged(newOwner) {
+		return internalerrors.ErrManagedUser
+	}
+	if newOwner.ID == userID {
+		return nil
+	}
+
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.groupRepo.WithTx(tx).SetOwner(ctx, groupID, newOwner.ID)
+		if err != nil {
+			return err
+		}
+
+		err = l.membershipRepo.WithTx(tx).SetRole(ctx, userID, groupID, RoleAdmin)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityOwnershipTransferred, newOwner.ID, newOwner.Name, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s er nu ejer af gruppen", newOwner.Name))
+}
+
+// Delete deletes the group. Its tasks are moved to the trash, its invitations are revoked and all members are
+// removed. Only the owner can delete the group.
+func (l *GroupLogic) Delete(ctx context.Context, userID string, groupID string) error {
+	user, err := getMember(ctx, l.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	if group.OwnerUserID != userID {
+		return internalerrors.ErrUserNotOwner
+	}
+
+	members, err := l.userRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		return l.deleteTx(ctx, tx, userID, group, members)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if member.ID == userID {
+			continue
+		}
+		err = l.notificationLogic.SendNotification(ctx, member.ID, fmt.Sprintf("%s har slettet gruppen '%s'", user.Name, group.Name))
+		if err != nil {
+			log.Printf("Failed to notify user=%s about deletion of group=%s: %s\n", member.ID, groupID, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteTx does the work of Delete as part of the transaction tx. The user must be the owner, and members are the
+// members of the group, who aren't notified.
+func (l *GroupLogic) deleteTx(ctx context.Context, tx *gorm.DB, userID string, group *database.Group, members []database.User) error {
+	err := l.taskRepo.WithTx(tx).DeleteAllForGroup(ctx, group.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = l.invitationRepo.WithTx(tx).RevokeAllForGroup(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+
+	err = l.joinRequestRepo.WithTx(tx).RejectAllForGroup(ctx, group.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	membershipRepo := l.membershipRepo.WithTx(tx)
+	for _, member := range members {
+		err = membershipRepo.Delete(ctx, member.ID, group.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordActivity(ctx, l.activityRepo.WithTx(tx), group.ID, userID, ActivityGroupDeleted, group.ID, group.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	return l.groupRepo.WithTx(tx).Delete(ctx, group.ID)
+}
+
+// GetSettings returns the settings of the group. Every member can see the settings.
+func (l *GroupLogic) GetSettings(ctx context.Context, userID string, groupID string) (GroupSettings, error) {
+	_, err := getMember(ctx, l.userRepo, userID, groupID)
+	if err != nil {
+		return GroupSettings{}, err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return GroupSettings{}, err
+	}
+
+	return GroupSettings{
+		Timezone:     group.Timezone,
+		Language:     group.Language,
+		ReminderTime: group.ReminderTime,
+	}, nil
+}
+
+func (l *GroupLogic) UpdateSettings(ctx context.Context, userID string, groupID string, settings GroupSettings) error {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionChangeSettings)
+	if err != nil {
+		return err
+	}
+
+	if _, err := time.LoadLocation(settings.Timezone); err != nil || settings.Timezone == "" {
+		return internalerrors.ErrInvalidTimezone
+	}
+	if !validLanguage(settings.Language) {
+		return internalerrors.ErrInvalidLanguage
+	}
+	reminderTime, err := time.Parse(reminderTimeLayout, settings.ReminderTime)
+	if err != nil {
+		return internalerrors.ErrInvalidReminderTime
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	var changes []Change
+	changes = addChange(changes, "timezone", group.Timezone, settings.Timezone)
+	changes = addChange(changes, "language", group.Language, settings.Language)
+	changes = addChange(changes, "reminderTime", group.ReminderTime, reminderTime.Format(reminderTimeLayout))
+
+	return l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.groupRepo.WithTx(tx).UpdateSettings(ctx, groupID, settings.Timezone, settings.Language, reminderTime.Format(reminderTimeLayout))
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivitySettingsChanged, groupID, group.Name, changes)
+	})
+}
+
+// getMember returns the user if they are a member of the group. It returns ErrUserNotInGroup when no group is
+// given, and ErrUserNotMemberOfGroup when the user is not a member.
+func getMember(ctx context.Context, userRepo *database.UserRepo, userID string, groupID string) (*database.User, error) {
+	if groupID == "" {
+		return nil, internalerrors.ErrUserNotInGroup
+	}
+
+	user, err := userRepo.GetMember(ctx, userID, groupID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, internalerrors.ErrUserNotMemberOfGroup
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,388 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+)
+
+type InvitationLogic struct {
+	transactor        *database.Transactor
+	invitationRepo    *database.InvitationRepo
+	userRepo          *database.UserRepo
+	groupRepo         *database.GroupRepo
+	membershipRepo    *database.MembershipRepo
+	activityRepo      *database.ActivityRepo
+	notificationLogic *NotificationLogic
+}
+
+type Invitation struct {
+	ID    string
+	Token string
+	// Email is set for invitations sent to a specific person, who might not have registered yet.
+	Email *string
+	// MaxUses is how many times the invitation can be accepted. 0 means unlimited.
+	MaxUses   int
+	Uses      int
+	ExpiresAt string
+}
+
+type NewInvitation struct {
+	Email    *string
+	MaxUses  int
+	ValidFor time.Duration
+}
+
+// InvitationInfo is what a user sees before accepting or declining an invitation.
+type InvitationInfo struct {
+	Token       string
+	GroupName   string
+	InviterName string
+	Valid       bool
+}
+
+func NewInvitationLogic(
+	transactor *database.Transactor,
+	invitationRepo *database.InvitationRepo,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	activityRepo *database.ActivityRepo,
+	notificationLogic *NotificationLogic,
+) *InvitationLogic {
+	return &InvitationLogic{
+		transactor:        transactor,
+		invitationRepo:    invitationRepo,
+		userRepo:          userRepo,
+		groupRepo:         groupRepo,
+		membershipRepo:    membershipRepo,
+		activityRepo:      activityRepo,
+		notificationLogic: notificationLogic,
+	}
+}
+
+func (l *InvitationLogic) Create(ctx context.Context, userID string, groupID string, newInvitation NewInvitation) (Invitation, error) {
+	user, group, err := l.getManagedGroup(ctx, userID, groupID)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	token, err := util.RandomToken(16)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	var email *string
+	if newInvitation.Email != nil && strings.TrimSpace(*newInvitation.Email) != "" {
+		trimmed := strings.TrimSpace(*newInvitation.Email)
+		email = &trimmed
+	}
+
+	invitation := database.Invitation{
+		ID:        uuid.NewString(),
+		Token:     token,
+		GroupID:   group.ID,
+		CreatedBy: user.ID,
+		Email:     email,
+		MaxUses:   newInvitation.MaxUses,
+		ExpiresAt: time.Now().Add(newInvitation.ValidFor),
+		CreatedAt: time.Now(),
+	}
+	err = l.invitationRepo.Create(ctx, invitation)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	if email != nil {
+		invitee, err := l.userRepo.GetByEmail(ctx, *email)
+		if err == nil {
+			msg := fmt.Sprintf("%s har inviteret dig til gruppen '%s'. Se invitationen på din profil.", user.Name, group.Name)
+			err = l.notificationLogic.SendNotification(ctx, invitee.ID, msg)
+			if err != nil {
+				log.Printf("Failed to notify user=%s about invitation=%s: %s\n", invitee.ID, invitation.ID, err)
+			}
+		}
+	}
+
+	return mapInvitation(invitation, group), nil
+}
+
+// GetActive returns the invitations of the user's group which can still be accepted.
+func (l *InvitationLogic) GetActive(ctx context.Context, userID string, groupID string) ([]Invitation, error) {
+	_, group, err := l.getManagedGroup(ctx, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := l.invitationRepo.GetActiveForGroup(ctx, group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []Invitation
+	for _, invitation := range invitations {
+		if invitation.MaxUses > 0 && invitation.Uses >= invitation.MaxUses {
+			continue
+		}
+		output = append(output, mapInvitation(invitation, group))
+	}
+
+	return output, nil
+}
+
+func (l *InvitationLogic) Get(ctx context.Context, userID string, groupID string, invitationID string) (Invitation, error) {
+	_, group, err := l.getManagedGroup(ctx, userID, groupID)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	invitation, err := l.invitationRepo.Get(ctx, invitationID)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	if invitation.GroupID != group.ID {
+		return Invitation{}, internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return mapInvitation(*invitation, group), nil
+}
+
+// Revoke makes an invitation unusable. Only members who can manage members can revoke invitations.
+func (l *InvitationLogic) Revoke(ctx context.Context, userID string, groupID string, invitationID string) error {
+	_, group, err := l.getManagedGroup(ctx, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	invitation, err := l.invitationRepo.Get(ctx, invitationID)
+	if err != nil {
+		return err
+	}
+
+	if invitation.GroupID != group.ID {
+		return internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return l.invitationRepo.Revoke(ctx, invitationID)
+}
+
+func (l *InvitationLogic) GetInfo(ctx context.Context, token string) (InvitationInfo, error) {
+	invitation, err := l.invitationRepo.GetByToken(ctx, token)
+	if err != nil {
+		return InvitationInfo{}, err
+	}
+
+	return l.getInfo(ctx, *invitation)
+}
+
+// GetPendingForUser returns the valid invitations sent to the email of the user.
+func (l *InvitationLogic) GetPendingForUser(ctx context.Context, userID string) ([]InvitationInfo, error) {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := l.invitationRepo.GetActiveForEmail(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []InvitationInfo
+	for _, invitation := range invitations {
+		_, err = l.membershipRepo.Get(ctx, userID, invitation.GroupID)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		info, err := l.getInfo(ctx, invitation)
+		if err != nil {
+			return nil, err
+		}
+		if info.Valid {
+			output = append(output, info)
+		}
+	}
+
+	return output, nil
+}
+
+// Accept makes the user a member of the group the invitation is for, and returns the ID of the group.
+func (l *InvitationLogic) Accept(ctx context.Context, userID string, token string) (string, error) {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if isManaged(user) {
+		return "", internalerrors.ErrManagedUser
+	}
+	if !emailVerified(user) {
+		return "", internalerrors.ErrEmailNotVerified
+	}
+
+	invitation, err := l.invitationRepo.GetByToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = l.membershipRepo.Get(ctx, userID, invitation.GroupID)
+	if err == nil {
+		return "", internalerrors.ErrUserAlreadyInGroup
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	err = l.join(ctx, *user, *invitation)
+	if err != nil {
+		return "", err
+	}
+
+	return invitation.GroupID, nil
+}
+
+// Decline declines an invitation. Invitations sent to the user's email can not be used afterwards, while shared
+// links stay valid for others.
+func (l *InvitationLogic) Decline(ctx context.Context, userID string, token string) error {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	invitation, err := l.invitationRepo.GetByToken(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	if invitation.Email == nil || !strings.EqualFold(*invitation.Email, user.Email) {
+		return nil
+	}
+
+	return l.invitationRepo.Revoke(ctx, invitation.ID)
+}
+
+// AttachOnSignup adds a newly verified user to the group of the newest valid invitation sent to their email.
+func (l *InvitationLogic) AttachOnSignup(ctx context.Context, userID string) error {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !emailVerified(user) {
+		return internalerrors.ErrEmailNotVerified
+	}
+
+	invitations, err := l.invitationRepo.GetActiveForEmail(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+
+	for _, invitation := range invitations {
+		if !invitationValid(invitation) {
+			continue
+		}
+		return l.join(ctx, *user, invitation)
+	}
+
+	return nil
+}
+
+func (l *InvitationLogic) join(ctx context.Context, user database.User, invitation database.Invitation) error {
+	if !invitationValid(invitation) {
+		return internalerrors.ErrInvitationNotValid
+	}
+
+	if invitation.Email != nil && !strings.EqualFold(*invitation.Email, user.Email) {
+		return internalerrors.ErrInvitationNotValid
+	}
+
+	err := l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.invitationRepo.WithTx(tx).Use(ctx, invitation.ID, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The invitation was used up, revoked or expired since it was read.
+				return internalerrors.ErrInvitationNotValid
+			}
+			return err
+		}
+
+		err = l.membershipRepo.WithTx(tx).Create(ctx, user.ID, invitation.GroupID, RoleMember)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), invitation.GroupID, user.ID, ActivityMemberJoined, user.ID, user.Name, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.notificationLogic.NotifyAllInGroup(ctx, invitation.GroupID, fmt.Sprintf("%s er blevet medlem af gruppen!", user.Name))
+}
+
+func (l *InvitationLogic) getInfo(ctx context.Context, invitation database.Invitation) (InvitationInfo, error) {
+	group, err := l.groupRepo.Get(ctx, invitation.GroupID)
+	if err != nil {
+		return InvitationInfo{}, err
+	}
+
+	inviterName := ""
+	inviter, err := l.userRepo.Get(ctx, invitation.CreatedBy)
+	if err == nil {
+		inviterName = inviter.Name
+	}
+
+	return InvitationInfo{
+		Token:       invitation.Token,
+		GroupName:   group.Name,
+		InviterName: inviterName,
+		Valid:       invitationValid(invitation),
+	}, nil
+}
+
+// getManagedGroup returns the user and the group, if the user is allowed to manage the members of the group.
+func (l *InvitationLogic) getManagedGroup(ctx context.Context, userID string, groupID string) (*database.User, *database.Group, error) {
+	user, err := getMember(ctx, l.userRepo, userID, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, group, nil
+}
+
+func invitationValid(invitation database.Invitation) bool {
+	if invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return false
+	}
+	return invitation.MaxUses == 0 || invitation.Uses < invitation.MaxUses
+}
+
+func mapInvitation(invitation database.Invitation, group *database.Group) Invitation {
+	return Invitation{
+		ID:        invitation.ID,
+		Token:     invitation.Token,
+		Email:     invitation.Email,
+		MaxUses:   invitation.MaxUses,
+		Uses:      invitation.Uses,
+		ExpiresAt: dateFormat(invitation.ExpiresAt, groupLocale(group)),
+	}
+}
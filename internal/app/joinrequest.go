@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// JoinRequest is a request from a user to join a group, as seen by the members who can approve it.
+type JoinRequest struct {
+	ID        string
+	UserName  string
+	UserEmail string
+	CreatedAt string
+}
+
+// PendingJoinRequest is a request to join a group, as seen by the user who made it.
+type PendingJoinRequest struct {
+	GroupName string
+	CreatedAt string
+}
+
+// GetCode returns the code other users can use to request to join the group, generating it if the group doesn't
+// have one yet.
+func (l *GroupLogic) GetCode(ctx context.Context, userID string, groupID string) (string, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return "", err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return "", err
+	}
+	if group.Code != nil {
+		return *group.Code, nil
+	}
+
+	return l.newCode(ctx, groupID)
+}
+
+// RegenerateCode replaces the code of the group, so the old code can no longer be used to request to join.
+func (l *GroupLogic) RegenerateCode(ctx context.Context, userID string, groupID string) (string, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return "", err
+	}
+
+	return l.newCode(ctx, groupID)
+}
+
+func (l *GroupLogic) newCode(ctx context.Context, groupID string) (string, error) {
+	code, err := util.RandomCode()
+	if err != nil {
+		return "", err
+	}
+
+	err = l.groupRepo.SetCode(ctx, groupID, code)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// RequestToJoin creates a request to join the group with the given code, and notifies the members who can approve it.
+func (l *GroupLogic) RequestToJoin(ctx context.Context, userID string, code string) error {
+	code, ok := util.NormalizeCode(code)
+	if !ok {
+		return internalerrors.ErrInvalidGroupCode
+	}
+
+	group, err := l.groupRepo.GetByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internalerrors.ErrInvalidGroupCode
+		}
+		return err
+	}
+
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isManaged(user) {
+		return internalerrors.ErrManagedUser
+	}
+	if !emailVerified(user) {
+		return internalerrors.ErrEmailNotVerified
+	}
+
+	_, err = l.membershipRepo.Get(ctx, userID, group.ID)
+	if err == nil {
+		return internalerrors.ErrUserAlreadyInGroup
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	_, err = l.joinRequestRepo.GetPending(ctx, userID, group.ID)
+	if err == nil {
+		return internalerrors.ErrJoinRequestExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	err = l.joinRequestRepo.Create(ctx, database.JoinRequest{
+		ID:        uuid.NewString(),
+		GroupID:   group.ID,
+		UserID:    userID,
+		Status:    database.JoinRequestPending,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyWithPermission(ctx, l.userRepo, l.groupRepo, l.membershipRepo, l.notificationLogic, group.ID, PermissionManageMembers,
+		fmt.Sprintf("%s (%s) har anmodet om at blive medlem af gruppen '%s'. Godkend eller afvis anmodningen på din profil.", user.Name, user.Email, group.Name))
+
+	return nil
+}
+
+// GetJoinRequests returns the pending requests to join the group.
+func (l *GroupLogic) GetJoinRequests(ctx context.Context, userID string, groupID string) ([]JoinRequest, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	loc := groupLocale(group)
+
+	requests, err := l.joinRequestRepo.GetPendingForGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []JoinRequest
+	for _, request := range requests {
+		user, err := l.userRepo.Get(ctx, request.UserID)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, JoinRequest{
+			ID:        request.ID,
+			UserName:  user.Name,
+			UserEmail: user.Email,
+			CreatedAt: dateFormat(request.CreatedAt.In(loc.location), loc),
+		})
+	}
+
+	return output, nil
+}
+
+// GetPendingJoinRequests returns the requests the user has made which haven't been approved or rejected yet.
+func (l *GroupLogic) GetPendingJoinRequests(ctx context.Context, userID string) ([]PendingJoinRequest, error) {
+	requests, err := l.joinRequestRepo.GetPendingForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []PendingJoinRequest
+	for _, request := range requests {
+		group, err := l.groupRepo.Get(ctx, request.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		loc := groupLocale(group)
+		output = append(output, PendingJoinRequest{
+			GroupName: group.Name,
+			CreatedAt: dateFormat(request.CreatedAt.In(loc.location), loc),
+		})
+	}
+
+	return output, nil
+}
+
+// ApproveJoinRequest makes the user who made the request a member of the group.
+func (l *GroupLogic) ApproveJoinRequest(ctx context.Context, userID string, groupID string, requestID string) error {
+	request, group, err := l.getJoinRequest(ctx, userID, groupID, requestID)
+	if err != nil {
+		return err
+	}
+
+	user, err := l.userRepo.Get(ctx, request.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := l.joinRequestRepo.WithTx(tx).Decide(ctx, request.ID, database.JoinRequestApproved, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return internalerrors.ErrJoinRequestNotFound
+			}
+			return err
+		}
+
+		err = l.membershipRepo.WithTx(tx).Create(ctx, user.ID, groupID, RoleMember)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, l.activityRepo.WithTx(tx), groupID, userID, ActivityMemberApproved, user.ID, user.Name, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = l.notificationLogic.SendNotification(ctx, user.ID, fmt.Sprintf("Din anmodning om at blive medlem af gruppen '%s' er blevet godkendt!", group.Name))
+	if err != nil {
+		log.Printf("Failed to notify user=%s about approved join request=%s: %s\n", user.ID, request.ID, err)
+	}
+
+	return nil
+}
+
+// RejectJoinRequest rejects the request. The user can make a new request afterwards.
+func (l *GroupLogic) RejectJoinRequest(ctx context.Context, userID string, groupID string, requestID string) error {
+	request, group, err := l.getJoinRequest(ctx, userID, groupID, requestID)
+	if err != nil {
+		return err
+	}
+
+	err = l.joinRequestRepo.Decide(ctx, request.ID, database.JoinRequestRejected, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internalerrors.ErrJoinRequestNotFound
+		}
+		return err
+	}
+
+	err = l.notificationLogic.SendNotification(ctx, request.UserID, fmt.Sprintf("Din anmodning om at blive medlem af gruppen '%s' er blevet afvist.", group.Name))
+	if err != nil {
+		log.Printf("Failed to notify user=%s about rejected join request=%s: %s\n", request.UserID, request.ID, err)
+	}
+
+	return nil
+}
+
+func (l *GroupLogic) getJoinRequest(ctx context.Context, userID string, groupID string, requestID string) (*database.JoinRequest, *database.Group, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionManageMembers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request, err := l.joinRequestRepo.Get(ctx, requestID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, internalerrors.ErrJoinRequestNotFound
+		}
+		return nil, nil, err
+	}
+	if request.GroupID != groupID || request.Status != database.JoinRequestPending {
+		return nil, nil, internalerrors.ErrJoinRequestNotFound
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return request, group, nil
+}
@@ -0,0 +1,229 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// deviceTokenBytes is the number of random bytes in a device token and a setup code.
+const deviceTokenBytes = 32
+
+// deviceSetupLifetime is how long the setup link of a new device works.
+const deviceSetupLifetime = time.Hour
+
+// KioskLogic handles shared devices which show the tasks of a group in kiosk mode. A device can only see the tasks
+// and mark them as completed by a member. It can't edit or delete tasks, or change settings.
+type KioskLogic struct {
+	deviceRepo     *database.DeviceRepo
+	groupRepo      *database.GroupRepo
+	userRepo       *database.UserRepo
+	membershipRepo *database.MembershipRepo
+	taskLogic      *TaskLogic
+}
+
+type KioskDevice struct {
+	ID       string
+	GroupID  string
+	Name     string
+	LastSeen string
+}
+
+type KioskMember struct {
+	ID   string
+	Name string
+}
+
+// KioskBoard is what is shown on a device in kiosk mode.
+type KioskBoard struct {
+	GroupName string
+	Tasks     []Task
+	Members   []KioskMember
+}
+
+func NewKioskLogic(
+	deviceRepo *database.DeviceRepo,
+	groupRepo *database.GroupRepo,
+	userRepo *database.UserRepo,
+	membershipRepo *database.MembershipRepo,
+	taskLogic *TaskLogic,
+) *KioskLogic {
+	return &KioskLogic{
+		deviceRepo:     deviceRepo,
+		groupRepo:      groupRepo,
+		userRepo:       userRepo,
+		membershipRepo: membershipRepo,
+		taskLogic:      taskLogic,
+	}
+}
+
+// CreateDevice creates a device for the group and returns its setup code. The code is only returned here, as only
+// its hash is stored. It can be used once within deviceSetupLifetime, and is exchanged for the token the device keeps
+// by SetUpDevice, so the long-lived token is never shown in a link.
+func (l *KioskLogic) CreateDevice(ctx context.Context, userID string, groupID string, name string) (string, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionChangeSettings)
+	if err != nil {
+		return "", err
+	}
+
+	code, err := util.RandomToken(deviceTokenBytes)
+	if err != nil {
+		return "", err
+	}
+	hashedCode := util.HashToken(code)
+	now := time.Now()
+	expiresAt := now.Add(deviceSetupLifetime)
+
+	err = l.deviceRepo.Create(ctx, database.Device{
+		ID:              uuid.NewString(),
+		GroupID:         groupID,
+		Name:            name,
+		HashedSetupCode: &hashedCode,
+		SetupExpiresAt:  &expiresAt,
+		CreatedBy:       userID,
+		CreatedAt:       now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// SetUpDevice exchanges the setup code from CreateDevice for the token the device keeps. ErrInvalidSetupCode is
+// returned if the code is unknown, has expired or has already been used.
+func (l *KioskLogic) SetUpDevice(ctx context.Context, code string) (string, error) {
+	token, err := util.RandomToken(deviceTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	err = l.deviceRepo.SetUp(ctx, util.HashToken(code), util.HashToken(token), time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", internalerrors.ErrInvalidSetupCode
+		}
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (l *KioskLogic) GetDevices(ctx context.Context, userID string, groupID string) ([]KioskDevice, error) {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionChangeSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := l.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	loc := groupLocale(group)
+
+	devices, err := l.deviceRepo.GetActiveForGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []KioskDevice
+	for _, device := range devices {
+		lastSeen := ""
+		if device.LastSeenAt != nil {
+			lastSeenAt := device.LastSeenAt.In(loc.location)
+			lastSeen = fmt.Sprintf("%s %s", dateFormat(lastSeenAt, loc), lastSeenAt.Format("15:04"))
+		}
+		output = append(output, KioskDevice{
+			ID:       device.ID,
+			GroupID:  device.GroupID,
+			Name:     device.Name,
+			LastSeen: lastSeen,
+		})
+	}
+
+	return output, nil
+}
+
+// RevokeDevice stops the device from showing the tasks of the group.
+func (l *KioskLogic) RevokeDevice(ctx context.Context, userID string, groupID string, deviceID string) error {
+	err := authorize(ctx, l.groupRepo, l.membershipRepo, userID, groupID, PermissionChangeSettings)
+	if err != nil {
+		return err
+	}
+
+	device, err := l.deviceRepo.Get(ctx, deviceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internalerrors.ErrDeviceNotFound
+		}
+		return err
+	}
+	if device.GroupID != groupID || device.RevokedAt != nil {
+		return internalerrors.ErrDeviceNotFound
+	}
+
+	return l.deviceRepo.Revoke(ctx, deviceID)
+}
+
+// Authenticate returns the device with the token. Devices which are revoked, or whose group has been deleted, are
+// rejected with ErrInvalidDeviceToken.
+func (l *KioskLogic) Authenticate(ctx context.Context, token string) (KioskDevice, error) {
+	device, err := l.deviceRepo.GetActiveByHashedToken(ctx, util.HashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return KioskDevice{}, internalerrors.ErrInvalidDeviceToken
+		}
+		return KioskDevice{}, err
+	}
+
+	_, err = l.groupRepo.Get(ctx, device.GroupID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return KioskDevice{}, internalerrors.ErrInvalidDeviceToken
+		}
+		return KioskDevice{}, err
+	}
+
+	err = l.deviceRepo.SetLastSeenAt(ctx, device.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to update last seen of device=%s: %s\n", device.ID, err)
+	}
+
+	return KioskDevice{ID: device.ID, GroupID: device.GroupID, Name: device.Name}, nil
+}
+
+func (l *KioskLogic) GetBoard(ctx context.Context, device KioskDevice) (KioskBoard, error) {
+	group, err := l.groupRepo.Get(ctx, device.GroupID)
+	if err != nil {
+		return KioskBoard{}, err
+	}
+
+	tasks, err := l.taskLogic.GetAllForGroup(ctx, device.GroupID)
+	if err != nil {
+		return KioskBoard{}, err
+	}
+
+	users, err := l.userRepo.GetByGroup(ctx, device.GroupID)
+	if err != nil {
+		return KioskBoard{}, err
+	}
+
+	var members []KioskMember
+	for _, user := range users {
+		members = append(members, KioskMember{ID: user.ID, Name: user.Name})
+	}
+
+	return KioskBoard{GroupName: group.Name, Tasks: tasks, Members: members}, nil
+}
+
+// Complete marks the task as completed by the member picked on the device.
+func (l *KioskLogic) Complete(ctx context.Context, device KioskDevice, taskID string, memberID string) error {
+	return l.taskLogic.Complete(ctx, memberID, device.GroupID, taskID)
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// loginWindow is how long failed login attempts are counted.
+	loginWindow = 15 * time.Minute
+	// ipMaxFailures is the number of failed attempts from an IP address within loginWindow before it is blocked.
+	ipMaxFailures = 50
+	// accountFreeFailures is the number of failed attempts on an account before each attempt is delayed.
+	accountFreeFailures = 3
+	// accountLockoutFailures is the number of failed attempts on an account before it is locked for loginWindow.
+	accountLockoutFailures = 10
+)
+
+// AttemptStore stores failed login attempts by a key, like an IP address or an account. The in-memory store only works
+// with a single instance of the application, so a store shared between instances, e.g. in Postgres, must be used
+// when running more than one.
+type AttemptStore interface {
+	// Failures returns the times of the failed attempts for the key since the given time, oldest first.
+	Failures(ctx context.Context, key string, since time.Time) ([]time.Time, error)
+	// AddFailure records a failed attempt for the key, and returns the failed attempts since the given time including
+	// the new one, as a single step, so concurrent attempts always see each other.
+	AddFailure(ctx context.Context, key string, at time.Time, since time.Time) ([]time.Time, error)
+	// RemoveFailure forgets the failed attempt for the key at the given time.
+	RemoveFailure(ctx context.Context, key string, at time.Time) error
+	// Reset forgets the failed attempts for the key.
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryAttemptStore keeps failed login attempts in memory. Attempts older than the max age are forgotten, and at
+// most maxKeys keys are kept, so attempts with made up IP addresses or emails can't fill the memory. When it is full,
+// the key with the oldest last attempt is forgotten first.
+type MemoryAttemptStore struct {
+	maxAge    time.Duration
+	maxKeys   int
+	mutex     sync.Mutex
+	failures  map[string][]time.Time
+	lastPrune time.Time
+}
+
+func NewMemoryAttemptStore(maxAge time.Duration, maxKeys int) *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		maxAge:    maxAge,
+		maxKeys:   maxKeys,
+		failures:  map[string][]time.Time{},
+		lastPrune: time.Now(),
+	}
+}
+
+func (s *MemoryAttemptStore) Failures(ctx context.Context, key string, since time.Time) ([]time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var output []time.Time
+	for _, failure := range s.failures[key] {
+		if failure.After(since) {
+			output = append(output, failure)
+		}
+	}
+
+	return output, nil
+}
+
+func (s *MemoryAttemptStore) AddFailure(ctx context.Context, key string, at time.Time, since time.Time) ([]time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Forget old attempts now and then, so keys which are no longer used don't stay in memory.
+	if at.Sub(s.lastPrune) > s.maxAge {
+		s.prune(at)
+	}
+	if _, ok := s.failures[key]; !ok && len(s.failures) >= s.maxKeys {
+		s.prune(at)
+		if len(s.failures) >= s.maxKeys {
+			s.evictOldest()
+		}
+	}
+
+	s.failures[key] = append(s.failures[key], at)
+
+	return pruneFailures(s.failures[key], since), nil
+}
+
+func (s *MemoryAttemptStore) RemoveFailure(ctx context.Context, key string, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	failures := s.failures[key]
+	for i, failure := range failures {
+		if failure.Equal(at) {
+			s.failures[key] = append(failures[:i:i], failures[i+1:]...)
+			break
+		}
+	}
+	if len(s.failures[key]) == 0 {
+		delete(s.failures, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
+
+// prune forgets the attempts older than the max age. The mutex must be held.
+func (s *MemoryAttemptStore) prune(now time.Time) {
+	for key, failures := range s.failures {
+		s.failures[key] = pruneFailures(failures, now.Add(-s.maxAge))
+		if len(s.failures[key]) == 0 {
+			delete(s.failures, key)
+		}
+	}
+	s.lastPrune = now
+}
+
+// evictOldest forgets the key whose last attempt is the oldest. The mutex must be held.
+func (s *MemoryAttemptStore) evictOldest() {
+	oldestKey := ""
+	var oldest time.Time
+	for key, failures := range s.failures {
+		last := failures[len(failures)-1]
+		if oldestKey == "" || last.Before(oldest) {
+			oldestKey, oldest = key, last
+		}
+	}
+	delete(s.failures, oldestKey)
+}
+
+// pruneFailures returns the failures after the given time.
+func pruneFailures(failures []time.Time, after time.Time) []time.Time {
+	var output []time.Time
+	for _, failure := range failures {
+		if failure.After(after) {
+			output = append(output, failure)
+		}
+	}
+	return output
+}
+
+// LoginLimiter protects logins against brute force by counting failed attempts per IP address and per account.
+// After a few failed attempts on an account, each attempt must wait twice as long as the one before, and after more
+// attempts the account is locked for a while and its owner is notified. An IP address with too many failed attempts
+// on any account is blocked for a while.
+type LoginLimiter struct {
+	store             AttemptStore
+	userRepo          *database.UserRepo
+	notificationLogic *NotificationLogic
+}
+
+func NewLoginLimiter(store AttemptStore, userRepo *database.UserRepo, notificationLogic *NotificationLogic) *LoginLimiter {
+	return &LoginLimiter{
+		store:             store,
+		userRepo:          userRepo,
+		notificationLogic: notificationLogic,
+	}
+}
+
+// CheckIP returns a TooManyAttemptsError if the IP address has too many failed attempts.
+func (l *LoginLimiter) CheckIP(ctx context.Context, ipAddress string) error {
+	now := time.Now()
+	failures, err := l.store.Failures(ctx, ipKey(ipAddress), now.Add(-loginWindow))
+	if err != nil {
+		return err
+	}
+	if len(failures) < ipMaxFailures {
+		return nil
+	}
+
+	// The IP address is allowed again when enough of the failures are older than the window.
+	unblockedAt := failures[len(failures)-ipMaxFailures].Add(loginWindow)
+	return internalerrors.TooManyAttemptsError{RetryAfter: unblockedAt.Sub(now)}
+}
+
+// loginAttempt is an attempt to log in, which counts as failed until it succeeds.
+type loginAttempt struct {
+	ipAddress string
+	account   string
+	at        time.Time
+}
+
+// attempt records an attempt to log in on the account as failed, before the password is checked, so concurrent
+// attempts can't all be let through before any of them has failed. A TooManyAttemptsError is returned, and the attempt
+// isn't counted, if the IP address or the account already has too many failed attempts. Otherwise the attempt must
+// be passed to succeed if the password is right.
+func (l *LoginLimiter) attempt(ctx context.Context, ipAddress string, account string) (loginAttempt, error) {
+	now := time.Now()
+	attempt := loginAttempt{ipAddress: ipAddress, account: account, at: now}
+
+	failures, err := l.store.AddFailure(ctx, ipKey(ipAddress), now, now.Add(-loginWindow))
+	if err != nil {
+		return loginAttempt{}, err
+	}
+	// The IP address is allowed again when enough of the earlier failures are older than the window.
+	if previous := failures[:len(failures)-1]; len(previous) >= ipMaxFailures {
+		unblockedAt := previous[len(previous)-ipMaxFailures].Add(loginWindow)
+		return loginAttempt{}, l.reject(ctx, attempt, false, unblockedAt.Sub(now))
+	}
+
+	failures, err = l.store.AddFailure(ctx, account, now, now.Add(-loginWindow))
+	if err != nil {
+		return loginAttempt{}, err
+	}
+	previous := failures[:len(failures)-1]
+	if delay := accountDelay(len(previous)); delay > 0 {
+		retryAfter := previous[len(previous)-1].Add(delay).Sub(now)
+		if retryAfter > 0 {
+			return loginAttempt{}, l.reject(ctx, attempt, true, retryAfter)
+		}
+	}
+
+	return attempt, nil
+}
+
+// reject forgets the attempt, which was blocked before the password was checked, and returns a TooManyAttemptsError.
+func (l *LoginLimiter) reject(ctx context.Context, attempt loginAttempt, recordedOnAccount bool, retryAfter time.Duration) error {
+	err := l.store.RemoveFailure(ctx, ipKey(attempt.ipAddress), attempt.at)
+	if err != nil {
+		return err
+	}
+	if recordedOnAccount {
+		err = l.store.RemoveFailure(ctx, attempt.account, attempt.at)
+		if err != nil {
+			return err
+		}
+	}
+	return internalerrors.TooManyAttemptsError{RetryAfter: retryAfter}
+}
+
+// fail is called when the attempt has failed. It was already counted by attempt, so the user, if any, is only
+// notified when their account becomes locked.
+func (l *LoginLimiter) fail(ctx context.Context, attempt loginAttempt, userID string) error {
+	if userID == "" {
+		return nil
+	}
+	failures, err := l.store.Failures(ctx, attempt.account, attempt.at.Add(-loginWindow))
+	if err != nil {
+		return err
+	}
+	if len(failures) == accountLockoutFailures {
+		msg := fmt.Sprintf("Der er forsøgt at logge ind på din konto med forkert password %d gange. "+
+			"Din konto er låst i %d minutter. Hvis det ikke var dig, så overvej at skifte dit password.",
+			accountLockoutFailures, int(loginWindow.Minutes()))
+		err = l.notificationLogic.SendNotification(ctx, userID, msg)
+		if err != nil {
+			log.Printf("Failed to notify user=%s about locked account: %s\n", userID, err)
+		}
+	}
+
+	return nil
+}
+
+// succeed forgets the failed attempts on the account, and that the attempt was counted as failed for the IP address.
+func (l *LoginLimiter) succeed(ctx context.Context, attempt loginAttempt) error {
+	err := l.store.RemoveFailure(ctx, ipKey(attempt.ipAddress), attempt.at)
+	if err != nil {
+		return err
+	}
+	return l.store.Reset(ctx, attempt.account)
+}
+
+// accountDelay returns how long to wait after the last failed attempt, given the number of failed attempts.
+func accountDelay(failures int) time.Duration {
+	if failures >= accountLockoutFailures {
+		return loginWindow
+	}
+	if failures <= accountFreeFailures {
+		return 0
+	}
+	return time.Duration(1<<uint(failures-accountFreeFailures)) * time.Second
+}
+
+func ipKey(ipAddress string) string {
+	return "ip:" + ipAddress
+}
+
+// emailKey is the key of an account logging in with an email. Emails without an account are counted the same way, so
+// the delays don't reveal which emails have an account.
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// pinKey is the key of a managed member logging in with a PIN.
+func pinKey(code string, name string) string {
+	return "pin:" + code + ":" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// twoFactorKey is the key of a user entering the code from their authenticator app, after their password.
+func twoFactorKey(userID string) string {
+	return "2fa:" + userID
+}
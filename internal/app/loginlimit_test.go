@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+)
+
+func TestAccountDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		0:  0,
+		3:  0,
+		4:  2 * time.Second,
+		5:  4 * time.Second,
+		6:  8 * time.Second,
+		9:  64 * time.Second,
+		10: loginWindow,
+		20: loginWindow,
+	}
+	for failures, expected := range tests {
+		actual := accountDelay(failures)
+		if actual != expected {
+			t.Errorf("Expected a delay of %s after %d failures, got %s", expected, failures, actual)
+		}
+	}
+}
+
+func TestMemoryAttemptStoreFailuresSince(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAttemptStore(time.Hour, 10)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		_, err := store.AddFailure(ctx, "key", start.Add(time.Duration(i)*10*time.Minute), start)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+	}
+
+	failures, _ := store.Failures(ctx, "key", start.Add(5*time.Minute))
+	if len(failures) != 2 {
+		t.Errorf("Expected the 2 failures after the given time, got %v", failures)
+	}
+
+	failures, _ = store.AddFailure(ctx, "key", start.Add(30*time.Minute), start.Add(15*time.Minute))
+	if len(failures) != 2 || !failures[1].Equal(start.Add(30*time.Minute)) {
+		t.Errorf("Expected AddFailure to return the failures since the given time including the new one, got %v", failures)
+	}
+}
+
+func TestMemoryAttemptStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	store := NewMemoryAttemptStore(time.Hour, 10)
+
+	store.AddFailure(ctx, "old", start, start)
+	store.AddFailure(ctx, "new", start.Add(50*time.Minute), start)
+
+	// The store is pruned when an attempt is added more than the max age after the last time it was pruned.
+	now := start.Add(90 * time.Minute)
+	store.AddFailure(ctx, "new", now, start)
+
+	if _, ok := store.failures["old"]; ok {
+		t.Errorf("Expected the key with only old failures to be forgotten")
+	}
+	if len(store.failures["new"]) != 2 {
+		t.Errorf("Expected the failures within the max age to be kept, got %v", store.failures["new"])
+	}
+}
+
+func TestMemoryAttemptStoreMaxKeys(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	store := NewMemoryAttemptStore(time.Hour, 2)
+
+	store.AddFailure(ctx, "first", start, start)
+	store.AddFailure(ctx, "second", start.Add(time.Minute), start)
+	store.AddFailure(ctx, "first", start.Add(2*time.Minute), start)
+	store.AddFailure(ctx, "third", start.Add(3*time.Minute), start)
+
+	if len(store.failures) != 2 {
+		t.Errorf("Expected at most 2 keys, got %d", len(store.failures))
+	}
+	if _, ok := store.failures["second"]; ok {
+		t.Errorf("Expected the key with the oldest last attempt to be forgotten")
+	}
+	if _, ok := store.failures["third"]; !ok {
+		t.Errorf("Expected the new key to be kept")
+	}
+}
+
+func TestMemoryAttemptStoreRemoveFailure(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	store := NewMemoryAttemptStore(time.Hour, 10)
+
+	store.AddFailure(ctx, "key", start, start)
+	store.AddFailure(ctx, "key", start.Add(time.Second), start)
+	store.RemoveFailure(ctx, "key", start)
+
+	failures, _ := store.Failures(ctx, "key", start.Add(-time.Second))
+	if len(failures) != 1 || !failures[0].Equal(start.Add(time.Second)) {
+		t.Errorf("Expected only the other failure to be left, got %v", failures)
+	}
+
+	store.RemoveFailure(ctx, "key", start.Add(time.Second))
+	if _, ok := store.failures["key"]; ok {
+		t.Errorf("Expected the key to be forgotten when it has no failures left")
+	}
+}
+
+func TestLoginLimiterDelaysAccount(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(time.Hour, 10), nil, nil)
+
+	for i := 0; i <= accountFreeFailures; i++ {
+		_, err := limiter.attempt(ctx, "10.0.0.1", "email:user@example.com")
+		if err != nil {
+			t.Fatalf("Expected attempt %d to be allowed, got %s", i+1, err)
+		}
+	}
+
+	_, err := limiter.attempt(ctx, "10.0.0.1", "email:user@example.com")
+	var tooManyAttempts internalerrors.TooManyAttemptsError
+	if !errors.As(err, &tooManyAttempts) {
+		t.Fatalf("Expected a TooManyAttemptsError, got %v", err)
+	}
+	if tooManyAttempts.RetryAfter <= 0 || tooManyAttempts.RetryAfter > accountDelay(accountFreeFailures+1) {
+		t.Errorf("Expected to retry within %s, got %s", accountDelay(accountFreeFailures+1), tooManyAttempts.RetryAfter)
+	}
+
+	// Blocked attempts aren't counted, so they don't make the delay longer.
+	failures, _ := limiter.store.Failures(ctx, "email:user@example.com", time.Now().Add(-loginWindow))
+	if len(failures) != accountFreeFailures+1 {
+		t.Errorf("Expected %d failures, got %d", accountFreeFailures+1, len(failures))
+	}
+
+	// Other accounts are not delayed.
+	_, err = limiter.attempt(ctx, "10.0.0.1", "email:other@example.com")
+	if err != nil {
+		t.Errorf("Expected an attempt on another account to be allowed, got %s", err)
+	}
+}
+
+func TestLoginLimiterSucceed(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(time.Hour, 10), nil, nil)
+
+	var attempt loginAttempt
+	for i := 0; i <= accountFreeFailures; i++ {
+		attempt, _ = limiter.attempt(ctx, "10.0.0.1", "email:user@example.com")
+	}
+	err := limiter.succeed(ctx, attempt)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	failures, _ := limiter.store.Failures(ctx, "email:user@example.com", time.Now().Add(-loginWindow))
+	if len(failures) != 0 {
+		t.Errorf("Expected the failures on the account to be forgotten, got %d", len(failures))
+	}
+	failures, _ = limiter.store.Failures(ctx, ipKey("10.0.0.1"), time.Now().Add(-loginWindow))
+	if len(failures) != accountFreeFailures {
+		t.Errorf("Expected only the failed attempts to count for the IP address, got %d", len(failures))
+	}
+}
+
+func TestLoginLimiterBlocksIP(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(time.Hour, ipMaxFailures+10), nil, nil)
+
+	for i := 0; i < ipMaxFailures; i++ {
+		_, err := limiter.attempt(ctx, "10.0.0.1", pinKey("ABCD-EFGH", string(rune('a'+i%26))+string(rune('a'+i/26))))
+		if err != nil {
+			t.Fatalf("Expected attempt %d to be allowed, got %s", i+1, err)
+		}
+	}
+
+	_, err := limiter.attempt(ctx, "10.0.0.1", "email:user@example.com")
+	if !errors.Is(err, internalerrors.ErrTooManyAttempts) {
+		t.Errorf("Expected the IP address to be blocked, got %v", err)
+	}
+	err = limiter.CheckIP(ctx, "10.0.0.1")
+	if !errors.Is(err, internalerrors.ErrTooManyAttempts) {
+		t.Errorf("Expected CheckIP to block the IP address, got %v", err)
+	}
+
+	_, err = limiter.attempt(ctx, "10.0.0.2", "email:user@example.com")
+	if err != nil {
+		t.Errorf("Expected another IP address to be allowed, got %s", err)
+	}
+}
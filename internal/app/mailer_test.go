@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a stand-in SMTP server, which accepts one session and records what the client sent.
+type smtpServer struct {
+	listener net.Listener
+	// rejectRcpt makes the server refuse all recipients.
+	rejectRcpt bool
+	done       chan struct{}
+
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	return &smtpServer{listener: listener, done: make(chan struct{})}
+}
+
+func (s *smtpServer) port() string {
+	return strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	text := textproto.NewConn(conn)
+	defer text.Close()
+
+	text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			s.auth = string(credentials)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			s.from = strings.TrimPrefix(line, "MAIL FROM:")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			if s.rejectRcpt {
+				text.PrintfLine("550 No such user")
+				continue
+			}
+			s.to = append(s.to, strings.TrimPrefix(line, "RCPT TO:"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newSMTPServer(t)
+	go server.serve()
+
+	mailer := NewSMTPMailer("127.0.0.1", server.port(), "taskeroo", "secret", "taskeroo@example.com")
+	err := mailer.Send(context.Background(), "user@example.com", "Nulstil dit password", "Hej Søren")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	<-server.done
+
+	if server.auth != "\x00taskeroo\x00secret" {
+		t.Errorf("Expected the username and password to be sent, got %q", server.auth)
+	}
+	if server.from != "<taskeroo@example.com>" {
+		t.Errorf("Expected the mail to be from the sender, got %s", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "<user@example.com>" {
+		t.Errorf("Expected the mail to be sent to the user, got %v", server.to)
+	}
+	if !strings.Contains(server.data, "Subject: Nulstil dit password\n") || !strings.HasSuffix(server.data, "Hej S=C3=B8ren\n") {
+		t.Errorf("Expected the message to be sent, got %s", server.data)
+	}
+}
+
+func TestSMTPMailerSendWithoutUsername(t *testing.T) {
+	server := newSMTPServer(t)
+	go server.serve()
+
+	mailer := NewSMTPMailer("127.0.0.1", server.port(), "", "", "taskeroo@example.com")
+	err := mailer.Send(context.Background(), "user@example.com", "Emne", "Tekst")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	<-server.done
+
+	if server.auth != "" {
+		t.Errorf("Expected no authentication without a username, got %q", server.auth)
+	}
+}
+
+func TestSMTPMailerSendRejectedRecipient(t *testing.T) {
+	server := newSMTPServer(t)
+	server.rejectRcpt = true
+	go server.serve()
+
+	mailer := NewSMTPMailer("127.0.0.1", server.port(), "", "", "taskeroo@example.com")
+	err := mailer.Send(context.Background(), "unknown@example.com", "Emne", "Tekst")
+	if err == nil {
+		t.Errorf("Expected an error when the recipient is rejected")
+	}
+}
+
+func TestSMTPMailerSendNoServer(t *testing.T) {
+	server := newSMTPServer(t)
+	port := server.port()
+	server.listener.Close()
+
+	mailer := NewSMTPMailer("127.0.0.1", port, "", "", "taskeroo@example.com")
+	err := mailer.Send(context.Background(), "user@example.com", "Emne", "Tekst")
+	if err == nil {
+		t.Errorf("Expected an error when the server can't be reached")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"github.com/dentych/taskeroo/internal/database"
-	internalerrors "github.com/dentych/taskeroo/internal/errors"
 	"gorm.io/gorm"
 	"log"
 )
@@ -33,16 +32,13 @@ func NewNotificationLogic(
 	}
 }
 
-func (n *NotificationLogic) GetNotificationInfo(ctx context.Context, userID string) (*NotificationInfo, error) {
-	user, err := n.userRepo.Get(ctx, userID)
+func (n *NotificationLogic) GetNotificationInfo(ctx context.Context, userID string, groupID string) (*NotificationInfo, error) {
+	user, err := getMember(ctx, n.userRepo, userID, groupID)
 	if err != nil {
 		return nil, err
 	}
-	if user.GroupID == nil {
-		return nil, internalerrors.ErrUserNotInGroup
-	}
 
-	group, err := n.groupRepo.Get(ctx, *user.GroupID)
+	group, err := n.groupRepo.Get(ctx, groupID)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +62,9 @@ func (n *NotificationLogic) GetNotificationInfo(ctx context.Context, userID stri
 }
 
 func (n *NotificationLogic) SendNotification(ctx context.Context, userID string, msg string) error {
+	if !n.canNotify(ctx, userID) {
+		return nil
+	}
 	log.Printf("Sending notification to user=%s, msg:\n%s\n", userID, msg)
 	return n.telegramLogic.SendMessage(ctx, userID, msg)
 }
@@ -86,6 +85,53 @@ func (n *NotificationLogic) NotifyAllInGroup(ctx context.Context, groupID string
 	return nil
 }
 
+func (n *NotificationLogic) SendNotificationWithActions(ctx context.Context, userID string, msg string, actions []NotificationAction) error {
+	if !n.canNotify(ctx, userID) {
+		return nil
+	}
+	log.Printf("Sending notification with %d actions to user=%s, msg:\n%s\n", len(actions), userID, msg)
+	return n.telegramLogic.SendMessageWithActions(ctx, userID, msg, actions)
+}
+
+func (n *NotificationLogic) NotifyAllInGroupWithActions(ctx context.Context, groupID string, msg string, actions []NotificationAction) error {
+	users, err := n.userRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err = n.SendNotificationWithActions(ctx, user.ID, msg, actions)
+		if err != nil {
+			log.Printf("Failed to send message to a member of group=%s, user=%s: %s", groupID, user.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// canNotify tells whether the user may receive notifications. Users who haven't verified their email get none.
+func (n *NotificationLogic) canNotify(ctx context.Context, userID string) bool {
+	user, err := n.userRepo.Get(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get user=%s to send notification: %s\n", userID, err)
+		return false
+	}
+	if !emailVerified(user) {
+		log.Printf("Not sending notification to user=%s, who hasn't verified their email\n", userID)
+		return false
+	}
+
+	return true
+}
+
+// NotificationAction is a button attached to a notification. Pressing it calls the handler registered for Action
+// with Payload.
+type NotificationAction struct {
+	Text    string
+	Action  string
+	Payload string
+}
+
 type NotificationInfo struct {
 	GroupOwner     bool
 	TelegramActive bool
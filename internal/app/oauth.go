@@ -0,0 +1,357 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// oauthLoginLifetime is how long the user has to log in at the provider.
+const oauthLoginLifetime = 10 * time.Minute
+
+// OAuthLogic lets users log in with providers like Google or GitHub, alongside their password. A user logging in
+// with a provider for the first time is linked to the user with the same email, if both the provider and this site
+// have verified it. Users can also link and unlink accounts from their profile. No users are created here, so every
+// user still has a password to fall back on.
+type OAuthLogic struct {
+	oauthAccountRepo *database.OAuthAccountRepo
+	userRepo         *database.UserRepo
+	groupRepo        *database.GroupRepo
+	authLogic        *AuthLogic
+	providers        []*util.OAuthProvider
+	secretKey        []byte
+}
+
+type OAuthProvider struct {
+	ID   string
+	Name string
+}
+
+// OAuthAccount is a provider the user can log in with. Accounts which aren't linked only have the provider set.
+type OAuthAccount struct {
+	ID       string
+	Provider OAuthProvider
+	Email    string
+	LinkedAt string
+	// LastUsed is empty if the account hasn't been used to log in.
+	LastUsed string
+}
+
+// OAuthLogin is a login started at a provider. The user is sent to URL, and Token must be given back to FinishLogin
+// when the provider sends the user back.
+type OAuthLogin struct {
+	URL   string
+	Token string
+}
+
+// OAuthResult is the result of a finished login at a provider. Link is true when the login was started by BeginLink,
+// even if linking failed, so the result can be shown on the profile. No session is created when linking.
+type OAuthResult struct {
+	Session UserSession
+	Link    bool
+	Next    string
+}
+
+// oauthFlow is what is kept in the token of an OAuthLogin until the provider sends the user back.
+type oauthFlow struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	// UserID is the user linking an account, and empty when logging in.
+	UserID    string `json:"userId,omitempty"`
+	Next      string `json:"next,omitempty"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func NewOAuthLogic(
+	oauthAccountRepo *database.OAuthAccountRepo,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	authLogic *AuthLogic,
+	providers []*util.OAuthProvider,
+	secretKey []byte,
+) *OAuthLogic {
+	return &OAuthLogic{
+		oauthAccountRepo: oauthAccountRepo,
+		userRepo:         userRepo,
+		groupRepo:        groupRepo,
+		authLogic:        authLogic,
+		providers:        providers,
+		secretKey:        secretKey,
+	}
+}
+
+// Providers returns the providers users can log in with.
+func (l *OAuthLogic) Providers() []OAuthProvider {
+	var output []OAuthProvider
+	for _, provider := range l.providers {
+		output = append(output, OAuthProvider{ID: provider.ID, Name: provider.Name})
+	}
+	return output
+}
+
+// BeginLogin starts logging in with the provider. The provider sends the user back to the redirect URI, after which
+// they are sent on to next.
+func (l *OAuthLogic) BeginLogin(providerID string, redirectURI string, next string) (OAuthLogin, error) {
+	return l.begin(providerID, redirectURI, oauthFlow{Next: next})
+}
+
+// BeginLink starts linking the user to their account at the provider.
+func (l *OAuthLogic) BeginLink(ctx context.Context, userID string, providerID string, redirectURI string) (OAuthLogin, error) {
+	err := checkNotManaged(ctx, l.userRepo, userID)
+	if err != nil {
+		return OAuthLogin{}, err
+	}
+
+	return l.begin(providerID, redirectURI, oauthFlow{UserID: userID})
+}
+
+// FinishLogin finishes a login from BeginLogin or BeginLink, when the provider has sent the user back with the state
+// and code. If the user has two-factor authentication enabled, a TwoFactorRequiredError is returned instead of a
+// session, like when logging in with the password, along with a result which only has Next set.
+func (l *OAuthLogic) FinishLogin(ctx context.Context, providerID string, token string, redirectURI string, state string, code string, client ClientInfo) (OAuthResult, error) {
+	provider, err := l.provider(providerID)
+	if err != nil {
+		return OAuthResult{}, err
+	}
+
+	flow, ok := l.checkFlowToken(token, time.Now())
+	if !ok || flow.Provider != providerID || !hmac.Equal([]byte(flow.State), []byte(state)) {
+		return OAuthResult{}, internalerrors.ErrInvalidOAuthLogin
+	}
+
+	identity, err := provider.Exchange(ctx, redirectURI, code, flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		log.Printf("Failed to log in with provider=%s: %s\n", providerID, err)
+		return OAuthResult{Link: flow.UserID != ""}, internalerrors.ErrInvalidOAuthLogin
+	}
+
+	if flow.UserID != "" {
+		return OAuthResult{Link: true}, l.link(ctx, flow.UserID, providerID, identity)
+	}
+
+	account, err := l.oauthAccountRepo.GetBySubject(ctx, providerID, identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return OAuthResult{}, err
+	}
+	if account == nil {
+		account, err = l.linkByEmail(ctx, providerID, identity)
+		if err != nil {
+			return OAuthResult{}, err
+		}
+	}
+
+	user, err := l.userRepo.Get(ctx, account.UserID)
+	if err != nil {
+		return OAuthResult{}, err
+	}
+	if user.AnonymisedAt != nil {
+		return OAuthResult{}, internalerrors.ErrOAuthNoAccount
+	}
+
+	err = l.oauthAccountRepo.SetLastUsedAt(ctx, account.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to update last use of linked account=%s: %s\n", account.ID, err)
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return OAuthResult{Next: flow.Next}, internalerrors.TwoFactorRequiredError{Token: l.authLogic.twoFactorToken(user.ID, time.Now())}
+	}
+
+	groupID, err := l.authLogic.defaultGroup(ctx, user.ID)
+	if err != nil {
+		return OAuthResult{}, err
+	}
+
+	userSession, err := l.authLogic.createSession(ctx, user.ID, groupID, client)
+	if err != nil {
+		return OAuthResult{}, err
+	}
+
+	return OAuthResult{Session: userSession, Next: flow.Next}, nil
+}
+
+// GetAccounts returns an account for every provider, which is linked if the user has linked their account at the
+// provider. Times are shown in the timezone of the given group, if any.
+func (l *OAuthLogic) GetAccounts(ctx context.Context, userID string, groupID string) ([]OAuthAccount, error) {
+	format, err := timeFormatter(ctx, l.groupRepo, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := l.oauthAccountRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []OAuthAccount
+	for _, provider := range l.providers {
+		entry := OAuthAccount{Provider: OAuthProvider{ID: provider.ID, Name: provider.Name}}
+		for _, account := range accounts {
+			if account.Provider != provider.ID {
+				continue
+			}
+			entry.ID = account.ID
+			entry.Email = account.Email
+			entry.LinkedAt = format(account.CreatedAt)
+			if account.LastUsedAt != nil {
+				entry.LastUsed = format(*account.LastUsedAt)
+			}
+		}
+		output = append(output, entry)
+	}
+
+	return output, nil
+}
+
+// Unlink removes the link to the account at the provider, so it can't be used to log in anymore.
+func (l *OAuthLogic) Unlink(ctx context.Context, userID string, providerID string) error {
+	err := l.oauthAccountRepo.Delete(ctx, userID, providerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return internalerrors.ErrOAuthAccountNotFound
+	}
+	return err
+}
+
+func (l *OAuthLogic) begin(providerID string, redirectURI string, flow oauthFlow) (OAuthLogin, error) {
+	provider, err := l.provider(providerID)
+	if err != nil {
+		return OAuthLogin{}, err
+	}
+
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		*value, err = util.RandomToken(32)
+		if err != nil {
+			return OAuthLogin{}, err
+		}
+	}
+	flow.Provider = providerID
+	flow.ExpiresAt = time.Now().Add(oauthLoginLifetime).Unix()
+
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return OAuthLogin{}, err
+	}
+
+	return OAuthLogin{
+		URL:   provider.AuthCodeURL(redirectURI, flow.State, flow.Nonce, flow.CodeVerifier),
+		Token: util.SignToken(l.secretKey, string(payload)),
+	}, nil
+}
+
+// checkFlowToken returns the flow of a token from begin, and false if it is invalid or has expired.
+func (l *OAuthLogic) checkFlowToken(token string, now time.Time) (oauthFlow, bool) {
+	payload, ok := util.VerifySignedToken(l.secretKey, token)
+	if !ok {
+		return oauthFlow{}, false
+	}
+
+	var flow oauthFlow
+	err := json.Unmarshal([]byte(payload), &flow)
+	if err != nil || flow.State == "" || now.Unix() > flow.ExpiresAt {
+		return oauthFlow{}, false
+	}
+
+	return flow, true
+}
+
+func (l *OAuthLogic) provider(providerID string) (*util.OAuthProvider, error) {
+	for _, provider := range l.providers {
+		if provider.ID == providerID {
+			return provider, nil
+		}
+	}
+	return nil, internalerrors.ErrUnknownOAuthProvider
+}
+
+// link links the account at the provider to the user, unless it is linked to another user, or the user already has
+// another account of the provider linked.
+func (l *OAuthLogic) link(ctx context.Context, userID string, providerID string, identity util.OAuthIdentity) error {
+	existing, err := l.oauthAccountRepo.GetBySubject(ctx, providerID, identity.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if existing != nil && existing.UserID == userID {
+		return nil
+	}
+
+	accounts, err := l.oauthAccountRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = checkLink(userID, providerID, existing, accounts)
+	if err != nil {
+		return err
+	}
+
+	return l.oauthAccountRepo.Create(ctx, database.OAuthAccount{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Provider:  providerID,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: time.Now(),
+	})
+}
+
+// linkByEmail links the account at the provider to the user with the same email. Both the provider and this site must
+// have verified the email, so nobody can take over an account by signing up with someone else's email at either.
+func (l *OAuthLogic) linkByEmail(ctx context.Context, providerID string, identity util.OAuthIdentity) (*database.OAuthAccount, error) {
+	var user *database.User
+	if identity.Email != "" {
+		var err error
+		user, err = l.userRepo.GetByEmail(ctx, identity.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	err := checkLinkByEmail(identity, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.link(ctx, user.ID, providerID, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.oauthAccountRepo.GetBySubject(ctx, providerID, identity.Subject)
+}
+
+// checkLink returns why the account at the provider can't be linked to the user: existing is the link of the account,
+// if it is linked, and accounts are the accounts already linked to the user.
+func checkLink(userID string, providerID string, existing *database.OAuthAccount, accounts []database.OAuthAccount) error {
+	if existing != nil && existing.UserID != userID {
+		return internalerrors.ErrOAuthAccountLinked
+	}
+	for _, account := range accounts {
+		if account.Provider == providerID {
+			return internalerrors.ErrOAuthProviderLinked
+		}
+	}
+	return nil
+}
+
+// checkLinkByEmail returns why the account at the provider can't be linked to the user with the same email, which is
+// nil if there is no such user.
+func checkLinkByEmail(identity util.OAuthIdentity, user *database.User) error {
+	if identity.Email == "" || !identity.EmailVerified {
+		return internalerrors.ErrOAuthEmailNotVerified
+	}
+	if user == nil || isManaged(user) || user.AnonymisedAt != nil {
+		return internalerrors.ErrOAuthNoAccount
+	}
+	if user.EmailVerifiedAt == nil {
+		return internalerrors.ErrEmailNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+)
+
+func TestCheckLinkByEmail(t *testing.T) {
+	now := time.Now()
+	parentID := "parent"
+	verified := util.OAuthIdentity{Subject: "subject", Email: "user@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name     string
+		identity util.OAuthIdentity
+		user     *database.User
+		expected error
+	}{
+		{"verified on both sides", verified, &database.User{ID: "user", EmailVerifiedAt: &now}, nil},
+		{"not verified by the provider", util.OAuthIdentity{Subject: "subject", Email: "user@example.com"}, &database.User{ID: "user", EmailVerifiedAt: &now}, internalerrors.ErrOAuthEmailNotVerified},
+		{"no email from the provider", util.OAuthIdentity{Subject: "subject", EmailVerified: true}, nil, internalerrors.ErrOAuthEmailNotVerified},
+		{"no user with the email", verified, nil, internalerrors.ErrOAuthNoAccount},
+		{"not verified by the site", verified, &database.User{ID: "user"}, internalerrors.ErrEmailNotVerified},
+		{"managed user", verified, &database.User{ID: "user", EmailVerifiedAt: &now, ManagedBy: &parentID}, internalerrors.ErrOAuthNoAccount},
+		{"anonymised user", verified, &database.User{ID: "user", EmailVerifiedAt: &now, AnonymisedAt: &now}, internalerrors.ErrOAuthNoAccount},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkLinkByEmail(test.identity, test.user)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *database.OAuthAccount
+		accounts []database.OAuthAccount
+		expected error
+	}{
+		{"first account", nil, nil, nil},
+		{"account of another provider", nil, []database.OAuthAccount{{UserID: "user", Provider: "github"}}, nil},
+		{"account linked to another user", &database.OAuthAccount{UserID: "other", Provider: "google"}, nil, internalerrors.ErrOAuthAccountLinked},
+		{"another account of the provider", nil, []database.OAuthAccount{{UserID: "user", Provider: "google"}}, internalerrors.ErrOAuthProviderLinked},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkLink("user", "google", test.existing, test.accounts)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// passkeyChallengeLifetime is how long the browser has to create or use a passkey.
+	passkeyChallengeLifetime = 5 * time.Minute
+	// defaultPasskeyName is the name of passkeys the user didn't name.
+	defaultPasskeyName = "Passkey"
+)
+
+// PasskeyLogic lets users log in with passkeys, i.e. WebAuthn credentials on their phone or computer, instead of their
+// password. Logging in with a passkey also requires the user to be verified by the device, e.g. by a fingerprint, so
+// it doesn't ask for a code from the authenticator app, even if two-factor authentication is enabled.
+type PasskeyLogic struct {
+	passkeyRepo *database.PasskeyRepo
+	userRepo    *database.UserRepo
+	groupRepo   *database.GroupRepo
+	authLogic   *AuthLogic
+	secretKey   []byte
+}
+
+type Passkey struct {
+	ID        string
+	Name      string
+	CreatedAt string
+	// LastUsed is empty if the passkey hasn't been used to log in.
+	LastUsed string
+}
+
+// PasskeyCreationOptions are the options for navigator.credentials.create() in the browser. Binary values are base64url
+// encoded.
+type PasskeyCreationOptions struct {
+	Challenge       string `json:"challenge"`
+	RPID            string `json:"rpId"`
+	RPName          string `json:"rpName"`
+	UserID          string `json:"userId"`
+	UserName        string `json:"userName"`
+	UserDisplayName string `json:"userDisplayName"`
+	// ExcludeCredentials are the IDs of the user's passkeys, so a device isn't registered twice.
+	ExcludeCredentials []string `json:"excludeCredentials"`
+	// Algorithms are the COSE algorithms of the public keys which are supported, in order of preference.
+	Algorithms []int `json:"algorithms"`
+}
+
+// PasskeyRequestOptions are the options for navigator.credentials.get() in the browser.
+type PasskeyRequestOptions struct {
+	Challenge string `json:"challenge"`
+	RPID      string `json:"rpId"`
+}
+
+// passkeyLogin is what is kept in the token of a login from BeginLogin until the browser finishes it.
+type passkeyLogin struct {
+	Challenge string `json:"challenge"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// PasskeyRegistration is the response of the browser to navigator.credentials.create(), with base64url encoded values.
+type PasskeyRegistration struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// PasskeyAssertion is the response of the browser to navigator.credentials.get(), with base64url encoded values.
+type PasskeyAssertion struct {
+	CredentialID      string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+func NewPasskeyLogic(
+	passkeyRepo *database.PasskeyRepo,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	authLogic *AuthLogic,
+	secretKey []byte,
+) *PasskeyLogic {
+	return &PasskeyLogic{
+		passkeyRepo: passkeyRepo,
+		userRepo:    userRepo,
+		groupRepo:   groupRepo,
+		authLogic:   authLogic,
+		secretKey:   secretKey,
+	}
+}
+
+// BeginRegistration returns the options for creating a passkey for the user on the relying party.
+func (l *PasskeyLogic) BeginRegistration(ctx context.Context, userID string, rp util.WebAuthnRelyingParty) (PasskeyCreationOptions, error) {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return PasskeyCreationOptions{}, err
+	}
+	if isManaged(user) {
+		return PasskeyCreationOptions{}, internalerrors.ErrManagedUser
+	}
+
+	challenge, err := l.createChallenge(ctx, userID)
+	if err != nil {
+		return PasskeyCreationOptions{}, err
+	}
+
+	passkeys, err := l.passkeyRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return PasskeyCreationOptions{}, err
+	}
+	excludeCredentials := []string{}
+	for _, passkey := range passkeys {
+		excludeCredentials = append(excludeCredentials, passkey.CredentialID)
+	}
+
+	return PasskeyCreationOptions{
+		Challenge:          challenge,
+		RPID:               rp.ID,
+		RPName:             "Taskeroo",
+		UserID:             base64.RawURLEncoding.EncodeToString([]byte(userID)),
+		UserName:           user.Email,
+		UserDisplayName:    user.Name,
+		ExcludeCredentials: excludeCredentials,
+		Algorithms:         []int{util.CoseAlgES256, util.CoseAlgRS256},
+	}, nil
+}
+
+// FinishRegistration checks the response of the browser to the options from BeginRegistration, and stores the passkey.
+func (l *PasskeyLogic) FinishRegistration(ctx context.Context, userID string, rp util.WebAuthnRelyingParty, registration PasskeyRegistration) error {
+	clientDataJSON, err := base64.RawURLEncoding.DecodeString(registration.ClientDataJSON)
+	if err != nil {
+		return internalerrors.ErrInvalidPasskey
+	}
+	attestationObject, err := base64.RawURLEncoding.DecodeString(registration.AttestationObject)
+	if err != nil {
+		return internalerrors.ErrInvalidPasskey
+	}
+
+	challenge, err := l.useChallenge(ctx, clientDataJSON, userID)
+	if err != nil {
+		return err
+	}
+
+	credential, err := util.VerifyWebAuthnRegistration(rp, challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		log.Printf("Failed to verify passkey registration for user=%s: %s\n", userID, err)
+		return internalerrors.ErrInvalidPasskey
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	_, err = l.passkeyRepo.GetByCredentialID(ctx, credentialID)
+	if err == nil {
+		return internalerrors.ErrPasskeyExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	name := strings.TrimSpace(registration.Name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+
+	return l.passkeyRepo.Create(ctx, database.Passkey{
+		ID:           uuid.NewString(),
+		UserID:       userID,
+		CredentialID: credentialID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    int64(credential.SignCount),
+		Name:         name,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// BeginLogin returns the options for logging in with a passkey. Any passkey for the relying party can be used, so the
+// user doesn't have to enter their email first. Anyone can begin a login, so the challenge isn't stored. It is kept in
+// the returned token instead, which must be given back to FinishLogin.
+func (l *PasskeyLogic) BeginLogin(rp util.WebAuthnRelyingParty) (PasskeyRequestOptions, string, error) {
+	challenge, err := randomChallenge()
+	if err != nil {
+		return PasskeyRequestOptions{}, "", err
+	}
+
+	payload, err := json.Marshal(passkeyLogin{
+		Challenge: challenge,
+		ExpiresAt: time.Now().Add(passkeyChallengeLifetime).Unix(),
+	})
+	if err != nil {
+		return PasskeyRequestOptions{}, "", err
+	}
+
+	return PasskeyRequestOptions{Challenge: challenge, RPID: rp.ID}, util.SignToken(l.secretKey, string(payload)), nil
+}
+
+// FinishLogin checks the response of the browser to the options from BeginLogin with the token from it, and logs in
+// the owner of the passkey.
+func (l *PasskeyLogic) FinishLogin(ctx context.Context, rp util.WebAuthnRelyingParty, token string, assertion PasskeyAssertion, client ClientInfo) (UserSession, error) {
+	var decoded [3][]byte
+	for i, value := range []string{assertion.ClientDataJSON, assertion.AuthenticatorData, assertion.Signature} {
+		var err error
+		decoded[i], err = base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return UserSession{}, internalerrors.ErrInvalidPasskey
+		}
+	}
+	clientDataJSON, authenticatorData, signature := decoded[0], decoded[1], decoded[2]
+
+	login, ok := l.checkLoginToken(token, time.Now())
+	if !ok {
+		return UserSession{}, internalerrors.ErrInvalidPasskey
+	}
+	challenge, err := clientDataChallenge(clientDataJSON)
+	if err != nil {
+		return UserSession{}, err
+	}
+	if !hmac.Equal([]byte(challenge), []byte(login.Challenge)) {
+		return UserSession{}, internalerrors.ErrInvalidPasskey
+	}
+
+	passkey, err := l.passkeyRepo.GetByCredentialID(ctx, assertion.CredentialID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return UserSession{}, internalerrors.ErrInvalidPasskey
+		}
+		return UserSession{}, err
+	}
+	if assertion.UserHandle != "" && assertion.UserHandle != base64.RawURLEncoding.EncodeToString([]byte(passkey.UserID)) {
+		return UserSession{}, internalerrors.ErrInvalidPasskey
+	}
+
+	credential := util.WebAuthnCredential{PublicKey: passkey.PublicKey, SignCount: uint32(passkey.SignCount)}
+	signCount, err := util.VerifyWebAuthnAssertion(rp, challenge, credential, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		log.Printf("Failed to verify passkey=%s of user=%s: %s\n", passkey.ID, passkey.UserID, err)
+		return UserSession{}, internalerrors.ErrInvalidPasskey
+	}
+
+	err = l.passkeyRepo.UseLoginChallenge(ctx, challenge, time.Unix(login.ExpiresAt, 0))
+	if err != nil {
+		if errors.Is(err, database.ErrChallengeUsed) {
+			return UserSession{}, internalerrors.ErrInvalidPasskey
+		}
+		return UserSession{}, err
+	}
+
+	err = l.passkeyRepo.SetUsed(ctx, passkey.ID, int64(signCount), time.Now())
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	groupID, err := l.authLogic.defaultGroup(ctx, passkey.UserID)
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	return l.authLogic.createSession(ctx, passkey.UserID, groupID, client)
+}
+
+// GetPasskeys returns the passkeys of the user. Times are shown in the timezone of the given group, if any.
+func (l *PasskeyLogic) GetPasskeys(ctx context.Context, userID string, groupID string) ([]Passkey, error) {
+	format, err := timeFormatter(ctx, l.groupRepo, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := l.passkeyRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []Passkey
+	for _, passkey := range passkeys {
+		lastUsed := ""
+		if passkey.LastUsedAt != nil {
+			lastUsed = format(*passkey.LastUsedAt)
+		}
+		output = append(output, Passkey{
+			ID:        passkey.ID,
+			Name:      passkey.Name,
+			CreatedAt: format(passkey.CreatedAt),
+			LastUsed:  lastUsed,
+		})
+	}
+
+	return output, nil
+}
+
+// RevokePasskey deletes the passkey, so it can't be used to log in anymore.
+func (l *PasskeyLogic) RevokePasskey(ctx context.Context, userID string, passkeyID string) error {
+	err := l.passkeyRepo.Delete(ctx, userID, passkeyID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return internalerrors.ErrPasskeyNotFound
+	}
+	return err
+}
+
+// DeleteExpiredChallenges deletes the challenges of registrations and logins which were never finished.
+func (l *PasskeyLogic) DeleteExpiredChallenges(ctx context.Context) error {
+	return l.passkeyRepo.DeleteExpiredChallenges(ctx, time.Now())
+}
+
+// createChallenge stores a new random challenge for the user registering a passkey.
+func (l *PasskeyLogic) createChallenge(ctx context.Context, userID string) (string, error) {
+	challenge, err := randomChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	err = l.passkeyRepo.CreateChallenge(ctx, database.PasskeyChallenge{
+		Challenge: challenge,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(passkeyChallengeLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// useChallenge returns the challenge the browser signed, if it was given to the user and hasn't been used or expired.
+// The challenge can't be used again afterwards.
+func (l *PasskeyLogic) useChallenge(ctx context.Context, clientDataJSON []byte, userID string) (string, error) {
+	challenge, err := clientDataChallenge(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+
+	err = l.passkeyRepo.UseChallenge(ctx, challenge, userID, time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", internalerrors.ErrInvalidPasskey
+		}
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// checkLoginToken returns the login of a token from BeginLogin, and false if it is invalid or has expired.
+func (l *PasskeyLogic) checkLoginToken(token string, now time.Time) (passkeyLogin, bool) {
+	payload, ok := util.VerifySignedToken(l.secretKey, token)
+	if !ok {
+		return passkeyLogin{}, false
+	}
+
+	var login passkeyLogin
+	err := json.Unmarshal([]byte(payload), &login)
+	if err != nil || login.Challenge == "" || now.Unix() > login.ExpiresAt {
+		return passkeyLogin{}, false
+	}
+
+	return login, true
+}
+
+// clientDataChallenge returns the challenge the browser signed.
+func clientDataChallenge(clientDataJSON []byte) (string, error) {
+	var clientData struct {
+		Challenge string `json:"challenge"`
+	}
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil || clientData.Challenge == "" {
+		return "", internalerrors.ErrInvalidPasskey
+	}
+
+	return clientData.Challenge, nil
+}
+
+func randomChallenge() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
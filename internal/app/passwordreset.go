@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// passwordResetLifetime is how long the link in a password reset email can be used.
+const passwordResetLifetime = time.Hour
+
+// passwordResetTokenBytes is the number of random bytes in a password reset token.
+const passwordResetTokenBytes = 32
+
+// RequestPasswordReset sends an email with a link to reset the password to the user with the email. The email is sent
+// in the background and errors are only logged, so the response is the same, and takes as long, whether anyone has the
+// email or not. That way the form can't be used to find out who has an account.
+func (a *AuthLogic) RequestPasswordReset(email string) {
+	go func() {
+		err := a.sendPasswordReset(context.Background(), email)
+		if err != nil {
+			log.Printf("Failed to send password reset: %s\n", err)
+		}
+	}()
+}
+
+// sendPasswordReset emails the link to reset the password to the user with the email. Nothing happens if no user has
+// the email.
+func (a *AuthLogic) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := a.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if isManaged(user) {
+		return nil
+	}
+
+	token, err := util.RandomToken(passwordResetTokenBytes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = a.passwordResetRepo.Create(ctx, database.PasswordReset{
+		ID:          uuid.NewString(),
+		UserID:      user.ID,
+		HashedToken: util.HashToken(token),
+		ExpiresAt:   now.Add(passwordResetLifetime),
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hej %s\n\n"+
+		"Nogen har bedt om at nulstille dit password på Taskeroo. Følg linket for at vælge et nyt password:\n\n"+
+		"%s/password/reset/%s\n\n"+
+		"Linket virker i en time og kan kun bruges én gang. Hvis det ikke var dig, kan du se bort fra denne email.",
+		user.Name, a.baseURL, token)
+	return a.mailer.Send(ctx, user.Email, "Nulstil dit password", body)
+}
+
+// CheckPasswordReset returns ErrInvalidResetToken if the token can't be used to reset a password, so the form isn't
+// shown for a link which doesn't work.
+func (a *AuthLogic) CheckPasswordReset(ctx context.Context, token string) error {
+	_, err := a.getPasswordReset(ctx, token)
+	return err
+}
+
+// ResetPassword changes the password of the user the token was sent to. The token can't be used again, and all
+// sessions of the user are logged out, in case someone else knew the old password.
+func (a *AuthLogic) ResetPassword(ctx context.Context, token string, password string) error {
+	if password == "" {
+		return internalerrors.ErrEmptyPassword
+	}
+
+	reset, err := a.getPasswordReset(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return a.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		passwordResetRepo := a.passwordResetRepo.WithTx(tx)
+		err := passwordResetRepo.MarkUsed(ctx, reset.ID, now)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return internalerrors.ErrInvalidResetToken
+			}
+			return err
+		}
+
+		err = passwordResetRepo.MarkAllUsedForUser(ctx, reset.UserID, now)
+		if err != nil {
+			return err
+		}
+
+		err = a.userRepo.WithTx(tx).SetPassword(ctx, reset.UserID, string(hashedPassword))
+		if err != nil {
+			return err
+		}
+
+		return a.sessionRepo.WithTx(tx).DeleteAllForUser(ctx, reset.UserID)
+	})
+}
+
+// DeleteExpiredPasswordResets deletes the password resets which have expired.
+func (a *AuthLogic) DeleteExpiredPasswordResets(ctx context.Context) error {
+	return a.passwordResetRepo.DeleteExpired(ctx, time.Now())
+}
+
+func (a *AuthLogic) getPasswordReset(ctx context.Context, token string) (*database.PasswordReset, error) {
+	reset, err := a.passwordResetRepo.GetValidByHashedToken(ctx, util.HashToken(token), time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, internalerrors.ErrInvalidResetToken
+		}
+		return nil, err
+	}
+
+	return reset, nil
+}
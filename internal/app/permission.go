@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"gorm.io/gorm"
+	"log"
+)
+
+// Roles of group members. The owner of a group is given by database.Group.OwnerUserID, so the role of the owner is
+// never stored on the membership.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	// RoleChild is for children and guests, who can see and complete tasks, but not change them.
+	RoleChild = "child"
+)
+
+const (
+	PermissionCreateTasks        = "create-tasks"
+	PermissionEditTasks          = "edit-tasks"
+	PermissionDeleteTasks        = "delete-tasks"
+	PermissionManageMembers      = "manage-members"
+	PermissionApproveCompletions = "approve-completions"
+	PermissionChangeSettings     = "change-settings"
+)
+
+// Permissions lists all permissions, in the order they are shown to users.
+var Permissions = []string{
+	PermissionCreateTasks,
+	PermissionEditTasks,
+	PermissionDeleteTasks,
+	PermissionManageMembers,
+	PermissionApproveCompletions,
+	PermissionChangeSettings,
+}
+
+// AssignableRoles are the roles the owner can give to other members.
+var AssignableRoles = []string{RoleAdmin, RoleMember, RoleChild}
+
+var rolePermissions = map[string][]string{
+	RoleOwner: Permissions,
+	RoleAdmin: Permissions,
+	RoleMember: {
+		PermissionCreateTasks,
+		PermissionEditTasks,
+	},
+	RoleChild: {},
+}
+
+// HasPermission returns true if the role grants the permission.
+func HasPermission(role string, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsForRole returns whether the role grants each permission.
+func PermissionsForRole(role string) map[string]bool {
+	output := map[string]bool{}
+	for _, permission := range Permissions {
+		output[permission] = HasPermission(role, permission)
+	}
+	return output
+}
+
+func validRole(role string) bool {
+	for _, r := range AssignableRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getRole returns the role of the user in the group. It returns ErrUserNotMemberOfGroup when the user is not a
+// member.
+func getRole(
+	ctx context.Context,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	userID string,
+	groupID string,
+) (string, error) {
+	if groupID == "" {
+		return "", internalerrors.ErrUserNotInGroup
+	}
+
+	membership, err := membershipRepo.Get(ctx, userID, groupID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", internalerrors.ErrUserNotMemberOfGroup
+		}
+		return "", err
+	}
+
+	group, err := groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return "", err
+	}
+
+	if group.OwnerUserID == userID {
+		return RoleOwner, nil
+	}
+
+	return membership.Role, nil
+}
+
+// authorize returns ErrMissingPermission if the role of the user in the group does not grant the permission.
+func authorize(
+	ctx context.Context,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	userID string,
+	groupID string,
+	permission string,
+) error {
+	role, err := getRole(ctx, groupRepo, membershipRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	if !HasPermission(role, permission) {
+		return internalerrors.ErrMissingPermission
+	}
+
+	return nil
+}
+
+// notifyWithPermission notifies the members of the group whose role has the permission. Failures are only logged.
+func notifyWithPermission(
+	ctx context.Context,
+	userRepo *database.UserRepo,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	notificationLogic *NotificationLogic,
+	groupID string,
+	permission string,
+	msg string,
+) {
+	members, err := userRepo.GetByGroup(ctx, groupID)
+	if err != nil {
+		log.Printf("Failed to get members of group=%s to notify: %s\n", groupID, err)
+		return
+	}
+
+	for _, member := range members {
+		role, err := getRole(ctx, groupRepo, membershipRepo, member.ID, groupID)
+		if err != nil {
+			log.Printf("Failed to get role of user=%s in group=%s: %s\n", member.ID, groupID, err)
+			continue
+		}
+		if !HasPermission(role, permission) {
+			continue
+		}
+		err = notificationLogic.SendNotification(ctx, member.ID, msg)
+		if err != nil {
+			log.Printf("Failed to send message to a member of group=%s, user=%s: %s\n", groupID, member.ID, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestHasPermission(t *testing.T) {
+	expected := map[string]map[string]bool{
+		RoleOwner: {
+			PermissionCreateTasks:        true,
+			PermissionEditTasks:          true,
+			PermissionDeleteTasks:        true,
+			PermissionManageMembers:      true,
+			PermissionApproveCompletions: true,
+			PermissionChangeSettings:     true,
+		},
+		RoleAdmin: {
+			PermissionCreateTasks:        true,
+			PermissionEditTasks:          true,
+			PermissionDeleteTasks:        true,
+			PermissionManageMembers:      true,
+			PermissionApproveCompletions: true,
+			PermissionChangeSettings:     true,
+		},
+		RoleMember: {
+			PermissionCreateTasks: true,
+			PermissionEditTasks:   true,
+		},
+		RoleChild: {},
+		// Unknown roles grant nothing.
+		"":      {},
+		"guest": {},
+	}
+
+	for role, permissions := range expected {
+		for _, permission := range Permissions {
+			actual := HasPermission(role, permission)
+			if actual != permissions[permission] {
+				t.Errorf("Expected role %q to have permission %s: %t, got %t", role, permission, permissions[permission], actual)
+			}
+		}
+		if HasPermission(role, "unknown") {
+			t.Errorf("Expected role %q not to have an unknown permission", role)
+		}
+	}
+}
+
+func TestPermissionsForRole(t *testing.T) {
+	for _, role := range []string{RoleOwner, RoleAdmin, RoleMember, RoleChild, "guest"} {
+		permissions := PermissionsForRole(role)
+		if len(permissions) != len(Permissions) {
+			t.Errorf("Expected every permission for role %q, got %v", role, permissions)
+		}
+		for _, permission := range Permissions {
+			if permissions[permission] != HasPermission(role, permission) {
+				t.Errorf("Expected permission %s of role %q to match HasPermission", permission, role)
+			}
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	tests := map[string]bool{
+		RoleOwner:  false,
+		RoleAdmin:  true,
+		RoleMember: true,
+		RoleChild:  true,
+		"":         false,
+		"guest":    false,
+	}
+	for role, expected := range tests {
+		actual := validRole(role)
+		if actual != expected {
+			t.Errorf("Expected role %q to be assignable: %t, got %t", role, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"time"
+)
+
+// DeletedUserName is the name of users who have deleted their account, as shown in the activity log.
+const DeletedUserName = "Slettet bruger"
+
+// PrivacyLogic lets users download the personal data stored about them, and delete their account.
+type PrivacyLogic struct {
+	transactor        *database.Transactor
+	userRepo          *database.UserRepo
+	sessionRepo       *database.SessionRepo
+	groupRepo         *database.GroupRepo
+	membershipRepo    *database.MembershipRepo
+	taskRepo          *database.TaskRepo
+	activityRepo      *database.ActivityRepo
+	completionRepo    *database.CompletionRepo
+	joinRequestRepo   *database.JoinRequestRepo
+	telegramRepo      *database.TelegramRepo
+	notificationRepo  *database.NotificationRepo
+	passwordResetRepo *database.PasswordResetRepo
+	recoveryCodeRepo  *database.RecoveryCodeRepo
+	passkeyRepo       *database.PasskeyRepo
+	oauthAccountRepo  *database.OAuthAccountRepo
+	groupLogic        *GroupLogic
+}
+
+// DataExport is the personal data stored about a user.
+type DataExport struct {
+	ExportedAt    time.Time          `json:"exportedAt"`
+	Profile       ExportProfile      `json:"profile"`
+	Memberships   []ExportMembership `json:"memberships"`
+	AssignedTasks []ExportTask       `json:"assignedTasks"`
+	// Completions are the tasks the user has completed.
+	Completions []ExportActivity `json:"completions"`
+	// Activities are the other changes the user has made in their groups.
+	Activities []ExportActivity `json:"activities"`
+	// CompletionApprovals are the completions the user has made which had to be approved, and those the user has
+	// approved or rejected.
+	CompletionApprovals []ExportCompletionApproval `json:"completionApprovals"`
+	JoinRequests        []ExportJoinRequest        `json:"joinRequests"`
+	Sessions            []ExportSession            `json:"sessions"`
+	Passkeys            []ExportPasskey            `json:"passkeys"`
+	// LinkedAccounts are the accounts at providers like Google which the user can log in with.
+	LinkedAccounts []ExportLinkedAccount `json:"linkedAccounts"`
+	Telegram       *ExportTelegram       `json:"telegram"`
+	Discord        *string               `json:"discord"`
+}
+
+type ExportProfile struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// TwoFactorEnabledAt is when two-factor authentication was enabled. The secret itself is not exported.
+	TwoFactorEnabledAt *time.Time `json:"twoFactorEnabledAt"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	LastLogin          time.Time  `json:"lastLogin"`
+}
+
+type ExportMembership struct {
+	GroupID   string    `json:"groupId"`
+	GroupName string    `json:"groupName"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
+}
+
+type ExportTask struct {
+	ID           string    `json:"id"`
+	GroupID      string    `json:"groupId"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Category     string    `json:"category"`
+	IntervalSize int       `json:"intervalSize"`
+	IntervalUnit string    `json:"intervalUnit"`
+	NextDueDate  time.Time `json:"nextDueDate"`
+}
+
+type ExportActivity struct {
+	GroupID   string    `json:"groupId"`
+	Action    string    `json:"action"`
+	SubjectID *string   `json:"subjectId"`
+	Subject   string    `json:"subject"`
+	Changes   []Change  `json:"changes"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ExportCompletionApproval struct {
+	TaskID      string     `json:"taskId"`
+	GroupID     string     `json:"groupId"`
+	CompletedBy string     `json:"completedBy"`
+	Status      string     `json:"status"`
+	DecidedBy   *string    `json:"decidedBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DecidedAt   *time.Time `json:"decidedAt"`
+}
+
+type ExportJoinRequest struct {
+	GroupID   string     `json:"groupId"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DecidedAt *time.Time `json:"decidedAt"`
+}
+
+type ExportSession struct {
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+type ExportPasskey struct {
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+type ExportLinkedAccount struct {
+	Provider   string     `json:"provider"`
+	Subject    string     `json:"subject"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+type ExportTelegram struct {
+	TelegramUserID int       `json:"telegramUserId"`
+	LinkedAt       time.Time `json:"linkedAt"`
+}
+
+func NewPrivacyLogic(
+	transactor *database.Transactor,
+	userRepo *database.UserRepo,
+	sessionRepo *database.SessionRepo,
+	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	taskRepo *database.TaskRepo,
+	activityRepo *database.ActivityRepo,
+	completionRepo *database.CompletionRepo,
+	joinRequestRepo *database.JoinRequestRepo,
+	telegramRepo *database.TelegramRepo,
+	notificationRepo *database.NotificationRepo,
+	passwordResetRepo *database.PasswordResetRepo,
+	recoveryCodeRepo *database.RecoveryCodeRepo,
+	passkeyRepo *database.PasskeyRepo,
+	oauthAccountRepo *database.OAuthAccountRepo,
+	groupLogic *GroupLogic,
+) *PrivacyLogic {
+	return &PrivacyLogic{
+		transactor:        transactor,
+		userRepo:          userRepo,
+		sessionRepo:       sessionRepo,
+		groupRepo:         groupRepo,
+		membershipRepo:    membershipRepo,
+		taskRepo:          taskRepo,
+		activityRepo:      activityRepo,
+		completionRepo:    completionRepo,
+		joinRequestRepo:   joinRequestRepo,
+		telegramRepo:      telegramRepo,
+		notificationRepo:  notificationRepo,
+		passwordResetRepo: passwordResetRepo,
+		recoveryCodeRepo:  recoveryCodeRepo,
+		passkeyRepo:       passkeyRepo,
+		oauthAccountRepo:  oauthAccountRepo,
+		groupLogic:        groupLogic,
+	}
+}
+
+// Export returns the personal data stored about the user.
+func (l *PrivacyLogic) Export(ctx context.Context, userID string) (DataExport, error) {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if isManaged(user) {
+		return DataExport{}, internalerrors.ErrManagedUser
+	}
+
+	output := DataExport{
+		ExportedAt: time.Now(),
+		Profile: ExportProfile{
+			ID:                 user.ID,
+			Name:               user.Name,
+			Email:              user.Email,
+			EmailVerifiedAt:    user.EmailVerifiedAt,
+			TwoFactorEnabledAt: user.TOTPEnabledAt,
+			CreatedAt:          user.CreatedAt,
+			LastLogin:          user.LastLogin,
+		},
+	}
+
+	memberships, err := l.membershipRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, membership := range memberships {
+		role, err := getRole(ctx, l.groupRepo, l.membershipRepo, userID, membership.GroupID)
+		if err != nil {
+			return DataExport{}, err
+		}
+		group, err := l.groupRepo.Get(ctx, membership.GroupID)
+		if err != nil {
+			return DataExport{}, err
+		}
+		output.Memberships = append(output.Memberships, ExportMembership{
+			GroupID:   group.ID,
+			GroupName: group.Name,
+			Role:      role,
+			JoinedAt:  membership.CreatedAt,
+		})
+	}
+
+	tasks, err := l.taskRepo.GetAssignedToUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, task := range tasks {
+		output.AssignedTasks = append(output.AssignedTasks, ExportTask{
+			ID:           task.ID,
+			GroupID:      task.GroupID,
+			Title:        task.Title,
+			Description:  task.Description,
+			Category:     task.Category,
+			IntervalSize: task.IntervalSize,
+			IntervalUnit: task.IntervalUnit,
+			NextDueDate:  task.NextDueDate,
+		})
+	}
+
+	activities, err := l.activityRepo.GetByActor(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, activity := range activities {
+		var changes []Change
+		if activity.Changes != "" {
+			err = json.Unmarshal([]byte(activity.Changes), &changes)
+			if err != nil {
+				return DataExport{}, err
+			}
+		}
+		exported := ExportActivity{
+			GroupID:   activity.GroupID,
+			Action:    activity.Action,
+			SubjectID: activity.SubjectID,
+			Subject:   activity.Subject,
+			Changes:   changes,
+			CreatedAt: activity.CreatedAt,
+		}
+		if activity.Action == ActivityTaskCompleted {
+			output.Completions = append(output.Completions, exported)
+		} else {
+			output.Activities = append(output.Activities, exported)
+		}
+	}
+
+	completions, err := l.completionRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, completion := range completions {
+		output.CompletionApprovals = append(output.CompletionApprovals, ExportCompletionApproval{
+			TaskID:      completion.TaskID,
+			GroupID:     completion.GroupID,
+			CompletedBy: completion.UserID,
+			Status:      completion.Status,
+			DecidedBy:   completion.DecidedBy,
+			CreatedAt:   completion.CreatedAt,
+			DecidedAt:   completion.DecidedAt,
+		})
+	}
+
+	joinRequests, err := l.joinRequestRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, request := range joinRequests {
+		output.JoinRequests = append(output.JoinRequests, ExportJoinRequest{
+			GroupID:   request.GroupID,
+			Status:    request.Status,
+			CreatedAt: request.CreatedAt,
+			DecidedAt: request.DecidedAt,
+		})
+	}
+
+	sessions, err := l.sessionRepo.GetActiveForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, session := range sessions {
+		output.Sessions = append(output.Sessions, ExportSession{
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+
+	passkeys, err := l.passkeyRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, passkey := range passkeys {
+		output.Passkeys = append(output.Passkeys, ExportPasskey{
+			Name:       passkey.Name,
+			CreatedAt:  passkey.CreatedAt,
+			LastUsedAt: passkey.LastUsedAt,
+		})
+	}
+
+	accounts, err := l.oauthAccountRepo.GetForUser(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	for _, account := range accounts {
+		output.LinkedAccounts = append(output.LinkedAccounts, ExportLinkedAccount{
+			Provider:   account.Provider,
+			Subject:    account.Subject,
+			Email:      account.Email,
+			CreatedAt:  account.CreatedAt,
+			LastUsedAt: account.LastUsedAt,
+		})
+	}
+
+	telegram, err := l.telegramRepo.GetByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return DataExport{}, err
+	}
+	if telegram != nil {
+		output.Telegram = &ExportTelegram{TelegramUserID: telegram.TelegramUserID, LinkedAt: telegram.UpdatedAt}
+	}
+
+	discord, err := l.notificationRepo.GetDiscordUsername(ctx, userID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if discord != nil {
+		output.Discord = &discord.DiscordID
+	}
+
+	return output, nil
+}
+
+// WriteExportZIP writes the export as a ZIP archive with a JSON file for each part of it.
+func WriteExportZIP(w io.Writer, export DataExport) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"memberships.json", export.Memberships},
+		{"assigned-tasks.json", export.AssignedTasks},
+		{"completions.json", export.Completions},
+		{"activities.json", export.Activities},
+		{"completion-approvals.json", export.CompletionApprovals},
+		{"join-requests.json", export.JoinRequests},
+		{"sessions.json", export.Sessions},
+		{"passkeys.json", export.Passkeys},
+		{"linked-accounts.json", export.LinkedAccounts},
+		{"notifications.json", map[string]interface{}{"telegram": export.Telegram, "discord": export.Discord}},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		writer, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// DeleteAccount deletes the account of the user, after checking their password. The user leaves all their groups,
+// and their tasks become common tasks. Groups where the user is the only member are deleted, while the user must
+// transfer ownership of other groups first. The user is kept in the activity log of the groups, but without their
+// name, email or password, and their sessions, Telegram link and Discord username are deleted. Everything is done in a
+// single transaction, so the account is either deleted completely or not at all.
+func (l *PrivacyLogic) DeleteAccount(ctx context.Context, userID string, password string) error {
+	user, err := l.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isManaged(user) {
+		return internalerrors.ErrManagedUser
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	if err != nil {
+		return internalerrors.ErrWrongPassword
+	}
+
+	groups, err := l.membershipRepo.GetGroupsForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	groupMembers := map[string][]database.User{}
+	for _, group := range groups {
+		if group.OwnerUserID != userID {
+			continue
+		}
+		members, err := l.userRepo.GetByGroup(ctx, group.ID)
+		if err != nil {
+			return err
+		}
+		if len(members) > 1 {
+			return internalerrors.ErrOwnerCannotDelete
+		}
+		groupMembers[group.ID] = members
+	}
+
+	err = l.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		for i := range groups {
+			group := &groups[i]
+			if group.OwnerUserID == userID {
+				err := l.groupLogic.deleteTx(ctx, tx, userID, group, groupMembers[group.ID])
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := l.groupLogic.leaveTx(ctx, tx, user, group.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := l.userRepo.WithTx(tx).Anonymise(ctx, userID, DeletedUserName)
+		if err != nil {
+			return err
+		}
+
+		err = l.activityRepo.WithTx(tx).AnonymiseSubject(ctx, userID, DeletedUserName)
+		if err != nil {
+			return err
+		}
+
+		err = l.joinRequestRepo.WithTx(tx).RejectAllForUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.telegramRepo.WithTx(tx).DeleteAllByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.notificationRepo.WithTx(tx).DeleteDiscordUsername(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.passwordResetRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.recoveryCodeRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.passkeyRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = l.oauthAccountRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return l.sessionRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if group.OwnerUserID == userID {
+			continue
+		}
+		err = l.groupLogic.notificationLogic.NotifyAllInGroup(ctx, group.ID, fmt.Sprintf("%s har forladt gruppen", user.Name))
+		if err != nil {
+			log.Printf("Failed to notify group=%s that user=%s deleted their account: %s\n", group.ID, userID, err)
+		}
+	}
+
+	return nil
+}
@@ -11,32 +11,84 @@ import (
 type Scheduler struct {
 	notificationLogic *NotificationLogic
 	taskLogic         *TaskLogic
+	authLogic         *AuthLogic
+	passkeyLogic      *PasskeyLogic
 	groupRepo         *database.GroupRepo
+	// trashRetention is how long deleted tasks are kept in the trash before they are purged.
+	trashRetention time.Duration
 
 	context context.Context
 	cancel  context.CancelFunc
 }
 
-func NewScheduler(notificationLogic *NotificationLogic, taskLogic *TaskLogic, groupRepo *database.GroupRepo) *Scheduler {
-	return &Scheduler{notificationLogic: notificationLogic, taskLogic: taskLogic, groupRepo: groupRepo}
+func NewScheduler(
+	notificationLogic *NotificationLogic,
+	taskLogic *TaskLogic,
+	authLogic *AuthLogic,
+	passkeyLogic *PasskeyLogic,
+	groupRepo *database.GroupRepo,
+	trashRetention time.Duration,
+) *Scheduler {
+	return &Scheduler{
+		notificationLogic: notificationLogic,
+		taskLogic:         taskLogic,
+		authLogic:         authLogic,
+		passkeyLogic:      passkeyLogic,
+		groupRepo:         groupRepo,
+		trashRetention:    trashRetention,
+	}
 }
 
 func (s *Scheduler) Start() {
 	s.context, s.cancel = context.WithCancel(context.Background())
-	go s.noonTask()
+	go s.reminderTask()
+	go s.dailyTask()
+}
+
+// dailyTask runs housekeeping once at startup, and then once every day.
+func (s *Scheduler) dailyTask() {
+	for {
+		log.Printf("SCHEDULER: Running purge of trash")
+		err := s.taskLogic.PurgeTrash(s.context, s.trashRetention)
+		if err != nil {
+			log.Printf("ERROR: DailyTask: Error during purge of trash: %s", err)
+		}
+		log.Printf("SCHEDULER: Done running purge of trash")
+
+		log.Printf("SCHEDULER: Running cleanup of expired sessions")
+		err = s.authLogic.DeleteExpiredSessions(s.context)
+		if err != nil {
+			log.Printf("ERROR: DailyTask: Error during cleanup of expired sessions: %s", err)
+		}
+		log.Printf("SCHEDULER: Done running cleanup of expired sessions")
+
+		log.Printf("SCHEDULER: Running cleanup of expired password resets")
+		err = s.authLogic.DeleteExpiredPasswordResets(s.context)
+		if err != nil {
+			log.Printf("ERROR: DailyTask: Error during cleanup of expired password resets: %s", err)
+		}
+		log.Printf("SCHEDULER: Done running cleanup of expired password resets")
+
+		log.Printf("SCHEDULER: Running cleanup of expired passkey challenges")
+		err = s.passkeyLogic.DeleteExpiredChallenges(s.context)
+		if err != nil {
+			log.Printf("ERROR: DailyTask: Error during cleanup of expired passkey challenges: %s", err)
+		}
+		log.Printf("SCHEDULER: Done running cleanup of expired passkey challenges")
+
+		time.Sleep(24 * time.Hour)
+	}
 }
 
-func (s *Scheduler) noonTask() {
+// reminderTask checks every minute if it is time to remind any groups about the tasks due today, according to the
+// reminder time and timezone of each group.
+func (s *Scheduler) reminderTask() {
 	for {
-		durationUntilTomorrowNoon := util.DurationToNextNoon(time.Now())
-		log.Printf("SCHEDULER: Waiting till noon. Duration in hours: %f\n", durationUntilTomorrowNoon.Hours())
-		time.Sleep(durationUntilTomorrowNoon)
+		time.Sleep(util.DurationToNextMinute(time.Now()))
 
-		log.Printf("SCHEDULER: Running notify tasks due today")
-		err := s.taskLogic.NotifyTasksDueToday(s.context)
+		err := s.taskLogic.RemindGroups(s.context, time.Now())
 		if err != nil {
-			log.Printf("ERROR: NoonTask: Error during notification of tasks due today: %s", err)
+			log.Printf("ERROR: ReminderTask: Error during notification of tasks due today: %s", err)
 		}
-		log.Printf("SCHEDULER: Done running notify tasks due today")
 	}
 }
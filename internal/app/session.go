@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"time"
+)
+
+// SessionLifetime is how long a session lasts after it was last used.
+const SessionLifetime = 31 * 24 * time.Hour
+
+// lastSeenInterval is how often the last seen time and expiry of a session is updated.
+const lastSeenInterval = 5 * time.Minute
+
+// sessionTokenBytes is the number of random bytes in a session token.
+const sessionTokenBytes = 32
+
+// ClientInfo describes the device a user logs in from, so the user can recognise their sessions.
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// SessionInfo is an active session of a user. ID identifies the session without revealing the session token.
+type SessionInfo struct {
+	ID        string
+	UserAgent string
+	IPAddress string
+	CreatedAt string
+	LastSeen  string
+	// Current is true for the session the list was requested from.
+	Current bool
+}
+
+// Logout deletes the session, so it can't be used again.
+func (a *AuthLogic) Logout(ctx context.Context, hashedToken string) error {
+	return a.sessionRepo.Delete(ctx, hashedToken)
+}
+
+// LogoutEverywhere deletes all sessions of the user, including the current one.
+func (a *AuthLogic) LogoutEverywhere(ctx context.Context, userID string) error {
+	return a.sessionRepo.DeleteAllForUser(ctx, userID)
+}
+
+// GetSessions returns the active sessions of the user. Times are shown in the timezone of the given group, if any.
+func (a *AuthLogic) GetSessions(ctx context.Context, userID string, groupID string, currentHashedToken string) ([]SessionInfo, error) {
+	format, err := timeFormatter(ctx, a.groupRepo, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := a.sessionRepo.GetActiveForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var output []SessionInfo
+	for _, session := range sessions {
+		output = append(output, SessionInfo{
+			ID:        sessionID(session.HashedToken),
+			UserAgent: session.UserAgent,
+			IPAddress: session.IPAddress,
+			CreatedAt: format(session.CreatedAt),
+			LastSeen:  format(session.LastSeenAt),
+			Current:   session.HashedToken == currentHashedToken,
+		})
+	}
+
+	return output, nil
+}
+
+// RevokeSession logs out the session with the ID from GetSessions.
+func (a *AuthLogic) RevokeSession(ctx context.Context, userID string, id string) error {
+	sessions, err := a.sessionRepo.GetActiveForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if sessionID(session.HashedToken) == id {
+			return a.sessionRepo.Delete(ctx, session.HashedToken)
+		}
+	}
+
+	return internalerrors.ErrSessionNotFound
+}
+
+// DeleteExpiredSessions deletes the sessions which have expired.
+func (a *AuthLogic) DeleteExpiredSessions(ctx context.Context) error {
+	return a.sessionRepo.DeleteExpired(ctx, time.Now())
+}
+
+// timeFormatter returns a function which formats times with date and time in the timezone and language of the group,
+// or in local time and Danish if groupID is empty.
+func timeFormatter(ctx context.Context, groupRepo *database.GroupRepo, groupID string) (func(time.Time) string, error) {
+	loc := locale{location: time.Local, language: LanguageDanish}
+	if groupID != "" {
+		group, err := groupRepo.Get(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		loc = groupLocale(group)
+	}
+
+	return func(t time.Time) string {
+		t = t.In(loc.location)
+		return fmt.Sprintf("%s %s", dateFormat(t, loc), t.Format("15:04"))
+	}, nil
+}
+
+// sessionID returns a short identifier of the session which can be shown to the user.
+func sessionID(hashedToken string) string {
+	return hashedToken[:16]
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"github.com/dentych/taskeroo/internal/database"
+	"github.com/dentych/taskeroo/internal/util"
+	"log"
+	"time"
+)
+
+const (
+	LanguageDanish  = "da"
+	LanguageEnglish = "en"
+)
+
+// Languages are the languages reminders and dates can be shown in.
+var Languages = []string{LanguageDanish, LanguageEnglish}
+
+const reminderTimeLayout = "15:04"
+
+type GroupSettings struct {
+	// Timezone is the IANA name of the timezone, e.g. Europe/Copenhagen.
+	Timezone string
+	Language string
+	// ReminderTime is the time of day, formatted as HH:MM, when members are reminded about tasks due that day.
+	ReminderTime string
+}
+
+// locale is the timezone and language of a group, used when showing dates and deciding which day it is.
+type locale struct {
+	location *time.Location
+	language string
+}
+
+func groupLocale(group *database.Group) locale {
+	location, err := time.LoadLocation(group.Timezone)
+	if err != nil {
+		log.Printf("Invalid timezone=%s of group=%s, falling back to local time: %s\n", group.Timezone, group.ID, err)
+		location = time.Local
+	}
+	return locale{location: location, language: group.Language}
+}
+
+// reminderDue returns true if the reminder time of the group has passed since the group was last reminded.
+func reminderDue(group *database.Group, now time.Time) bool {
+	reminderTime, err := time.Parse(reminderTimeLayout, group.ReminderTime)
+	if err != nil {
+		log.Printf("Invalid reminder time=%s of group=%s: %s\n", group.ReminderTime, group.ID, err)
+		return false
+	}
+
+	latest := util.LatestOccurrence(now.In(groupLocale(group).location), reminderTime.Hour(), reminderTime.Minute())
+	return group.LastRemindedAt.Before(latest)
+}
+
+func validLanguage(language string) bool {
+	for _, l := range Languages {
+		if l == language {
+			return true
+		}
+	}
+	return false
+}
@@ -2,23 +2,35 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dentych/taskeroo/internal/database"
 	internalerrors "github.com/dentych/taskeroo/internal/errors"
 	"github.com/dentych/taskeroo/internal/util"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 	"log"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// ActionClaimTask is the notification action for claiming a common task.
+const ActionClaimTask = "claim"
+
 type TaskLogic struct {
+	transactor        *database.Transactor
 	taskRepo          *database.TaskRepo
 	userRepo          *database.UserRepo
 	groupRepo         *database.GroupRepo
+	membershipRepo    *database.MembershipRepo
+	activityRepo      *database.ActivityRepo
+	completionRepo    *database.CompletionRepo
 	notificationLogic *NotificationLogic
+	// encryptionKey encrypts the webhook secrets.
+	encryptionKey []byte
 }
 
 type Task struct {
@@ -30,23 +42,52 @@ type Task struct {
 	Assignee         *string
 	AssigneeName     *string
 	RotatingAssignee bool
+	// ClaimedBy is the userID for the person who has taken an unassigned task until it is completed
+	ClaimedBy     *string
+	ClaimedByName *string
+	Category      string
 	// IntervalSize specifies how many units has to pass before the task has to be completed again,
 	// i.e. 2 week = once every 2 weeks.
 	IntervalSize int
-	// IntervalUnit can be either onetime, day, week or month.
-	IntervalUnit   string
+	// IntervalUnit can be either onetime, day, week, month or usage. Usage tasks are due after IntervalSize uses.
+	IntervalUnit string
+	// UsageCount is the number of uses registered since a usage task was last completed.
+	UsageCount int
+	// DaysLeft is the number of days until the task is due. For usage tasks it is the number of uses left.
 	DaysLeft       int
 	PercentageLeft float64
 	DueDate        string
+	// WebhookEnabled is true when the task has a webhook which makes it due when called. The URL is only shown when it
+	// is created, as only a hash of its token is stored.
+	WebhookEnabled bool
+	// WebhookSigned is true when webhook requests must carry a valid signature.
+	WebhookSigned bool
+	// AwaitingApproval is true when the task has been completed by a member whose completions need approval.
+	AwaitingApproval bool
 }
 
 func NewTaskLogic(
+	transactor *database.Transactor,
 	taskRepo *database.TaskRepo,
 	userRepo *database.UserRepo,
 	groupRepo *database.GroupRepo,
+	membershipRepo *database.MembershipRepo,
+	activityRepo *database.ActivityRepo,
+	completionRepo *database.CompletionRepo,
 	notificationLogic *NotificationLogic,
+	encryptionKey []byte,
 ) *TaskLogic {
-	return &TaskLogic{taskRepo: taskRepo, userRepo: userRepo, groupRepo: groupRepo, notificationLogic: notificationLogic}
+	return &TaskLogic{
+		transactor:        transactor,
+		taskRepo:          taskRepo,
+		userRepo:          userRepo,
+		groupRepo:         groupRepo,
+		membershipRepo:    membershipRepo,
+		activityRepo:      activityRepo,
+		completionRepo:    completionRepo,
+		notificationLogic: notificationLogic,
+		encryptionKey:     encryptionKey,
+	}
 }
 
 type NewTask struct {
@@ -54,17 +95,25 @@ type NewTask struct {
 	Description      string
 	Assignee         *string
 	RotatingAssignee bool
+	Category         string
 	IntervalSize     int
 	IntervalUnit     string
 }
 
-func (t *TaskLogic) Create(ctx context.Context, userID string, newTask NewTask) (Task, error) {
-	user, err := t.userRepo.Get(ctx, userID)
+func (t *TaskLogic) Create(ctx context.Context, userID string, groupID string, newTask NewTask) (Task, error) {
+	user, err := getMember(ctx, t.userRepo, userID, groupID)
 	if err != nil {
 		return Task{}, err
 	}
-	if user.GroupID == nil {
-		return Task{}, internalerrors.ErrUserNotInGroup
+
+	err = t.authorize(ctx, userID, groupID, PermissionCreateTasks)
+	if err != nil {
+		return Task{}, err
+	}
+
+	group, err := t.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return Task{}, err
 	}
 
 	taskID := uuid.NewString()
@@ -72,16 +121,24 @@ func (t *TaskLogic) Create(ctx context.Context, userID string, newTask NewTask)
 		ID:               taskID,
 		Title:            newTask.Title,
 		Description:      newTask.Description,
-		GroupID:          *user.GroupID,
+		GroupID:          groupID,
 		Assignee:         newTask.Assignee,
 		RotatingAssignee: newTask.RotatingAssignee,
+		Category:         newTask.Category,
 		IntervalSize:     newTask.IntervalSize,
 		IntervalUnit:     newTask.IntervalUnit,
 		NextDueDate:      calculateNextDueDate(newTask.IntervalUnit, newTask.IntervalSize),
 		CreatedAt:        time.Now(),
 		UpdatedAt:        time.Now(),
 	}
-	err = t.taskRepo.Create(ctx, task)
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Create(ctx, task)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskCreated, task.ID, task.Title, nil)
+	})
 	if err != nil {
 		return Task{}, err
 	}
@@ -93,30 +150,27 @@ func (t *TaskLogic) Create(ctx context.Context, userID string, newTask NewTask)
 		interval := t.getLocalizedInterval(task.IntervalSize, task.IntervalUnit)
 		msg = fmt.Sprintf("%s har lige oprettet opgaven '%s', med interval '%s'!", user.Name, task.Title, interval)
 	}
-	err = t.notificationLogic.NotifyAllInGroup(ctx, *user.GroupID, msg)
+	err = t.notificationLogic.NotifyAllInGroup(ctx, groupID, msg)
 
 	return Task{
 		ID:             taskID,
-		GroupID:        *user.GroupID,
+		GroupID:        groupID,
 		Title:          newTask.Title,
 		Description:    newTask.Description,
+		Category:       newTask.Category,
 		IntervalSize:   newTask.IntervalSize,
 		IntervalUnit:   newTask.IntervalUnit,
-		DaysLeft:       calculateDaysLeft(task.NextDueDate),
-		PercentageLeft: calculatePercentageLeft(task.IntervalUnit, task.IntervalSize, task.NextDueDate),
+		DaysLeft:       calculateDaysLeft(task, groupLocale(group).location),
+		PercentageLeft: calculatePercentageLeft(task),
 	}, nil
 }
 
 func (t *TaskLogic) GetAllForUserIDAndGroupID(ctx context.Context, userID string, groupID string) ([]Task, error) {
-	user, err := t.userRepo.Get(ctx, userID)
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
 	if err != nil {
 		return nil, err
 	}
 
-	if user.GroupID == nil || *user.GroupID != groupID {
-		return nil, internalerrors.ErrUserNotMemberOfGroup
-	}
-
 	tasks, err := t.GetAllForGroup(ctx, groupID)
 	if err != nil {
 		return nil, err
@@ -126,26 +180,51 @@ func (t *TaskLogic) GetAllForUserIDAndGroupID(ctx context.Context, userID string
 }
 
 func (t *TaskLogic) GetAllForGroup(ctx context.Context, groupID string) ([]Task, error) {
+	group, err := t.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	l := groupLocale(group)
+
 	tasks, err := t.taskRepo.GetAllForGroup(ctx, groupID)
 	if err != nil {
 		return nil, err
 	}
 
+	completions, err := t.completionRepo.GetPendingForGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	awaitingApproval := map[string]bool{}
+	for _, completion := range completions {
+		awaitingApproval[completion.TaskID] = true
+	}
+
 	var mappedTasks []Task
 	userNames := map[string]string{}
-	for _, task := range tasks {
-		var assigneeName *string
-		if task.Assignee != nil {
-			userName := userNames[*task.Assignee]
-			if userName == "" {
-				u, err := t.userRepo.Get(ctx, *task.Assignee)
-				if err != nil {
-					return nil, err
-				}
-				userNames[*task.Assignee] = u.Name
-				userName = u.Name
+	getUserName := func(userID *string) (*string, error) {
+		if userID == nil {
+			return nil, nil
+		}
+		userName := userNames[*userID]
+		if userName == "" {
+			u, err := t.userRepo.Get(ctx, *userID)
+			if err != nil {
+				return nil, err
 			}
-			assigneeName = &userName
+			userNames[*userID] = u.Name
+			userName = u.Name
+		}
+		return &userName, nil
+	}
+	for _, task := range tasks {
+		assigneeName, err := getUserName(task.Assignee)
+		if err != nil {
+			return nil, err
+		}
+		claimedByName, err := getUserName(task.ClaimedBy)
+		if err != nil {
+			return nil, err
 		}
 		mappedTasks = append(mappedTasks, Task{
 			ID:               task.ID,
@@ -154,12 +233,17 @@ func (t *TaskLogic) GetAllForGroup(ctx context.Context, groupID string) ([]Task,
 			Assignee:         task.Assignee,
 			AssigneeName:     assigneeName,
 			RotatingAssignee: task.RotatingAssignee,
+			ClaimedBy:        task.ClaimedBy,
+			ClaimedByName:    claimedByName,
+			Category:         task.Category,
 			Description:      task.Description,
 			IntervalSize:     task.IntervalSize,
 			IntervalUnit:     task.IntervalUnit,
-			DaysLeft:         calculateDaysLeft(task.NextDueDate),
-			PercentageLeft:   calculatePercentageLeft(task.IntervalUnit, task.IntervalSize, task.NextDueDate),
-			DueDate:          dateFormat(task.NextDueDate),
+			UsageCount:       task.UsageCount,
+			DaysLeft:         calculateDaysLeft(task, l.location),
+			PercentageLeft:   calculatePercentageLeft(task),
+			DueDate:          dateFormat(task.NextDueDate, l),
+			AwaitingApproval: awaitingApproval[task.ID],
 		})
 	}
 	sort.SliceStable(mappedTasks, func(i, j int) bool {
@@ -169,41 +253,164 @@ func (t *TaskLogic) GetAllForGroup(ctx context.Context, groupID string) ([]Task,
 	return mappedTasks, nil
 }
 
-func (t *TaskLogic) Delete(ctx context.Context, userID string, taskID string) error {
-	user, err := t.userRepo.Get(ctx, userID)
+func (t *TaskLogic) Delete(ctx context.Context, userID string, groupID string, taskID string) error {
+	err := t.authorize(ctx, userID, groupID, PermissionDeleteTasks)
 	if err != nil {
 		return err
 	}
 
-	if user.GroupID == nil {
-		return internalerrors.ErrUserNotInGroup
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		return err
 	}
 
-	task, err := t.taskRepo.Get(ctx, taskID)
+	if task.GroupID != groupID {
+		return internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Delete(ctx, taskID, userID)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskDeleted, task.ID, task.Title, nil)
+	})
+}
+
+type DeletedTask struct {
+	ID            string
+	Title         string
+	Description   string
+	DeletedByName string
+	DeletedAt     string
+}
+
+// GetTrash returns the soft-deleted tasks of the user's group, most recently deleted first.
+func (t *TaskLogic) GetTrash(ctx context.Context, userID string, groupID string) ([]DeletedTask, error) {
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := t.groupRepo.Get(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := t.taskRepo.GetAllDeletedForGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var deletedTasks []DeletedTask
+	userNames := map[string]string{}
+	for _, task := range tasks {
+		deletedByName := "Ukendt"
+		if task.DeletedBy != nil {
+			userName, ok := userNames[*task.DeletedBy]
+			if !ok {
+				u, err := t.userRepo.Get(ctx, *task.DeletedBy)
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+				if u != nil {
+					userName = u.Name
+				}
+				userNames[*task.DeletedBy] = userName
+			}
+			if userName != "" {
+				deletedByName = userName
+			}
+		}
+		deletedTasks = append(deletedTasks, DeletedTask{
+			ID:            task.ID,
+			Title:         task.Title,
+			Description:   task.Description,
+			DeletedByName: deletedByName,
+			DeletedAt:     dateFormat(task.DeletedAt.Time, groupLocale(group)),
+		})
+	}
+
+	return deletedTasks, nil
+}
+
+func (t *TaskLogic) Restore(ctx context.Context, userID string, groupID string, taskID string) error {
+	user, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	err = t.authorize(ctx, userID, groupID, PermissionDeleteTasks)
+	if err != nil {
+		return err
+	}
+
+	task, err := t.taskRepo.GetDeleted(ctx, taskID)
 	if err != nil {
 		return err
 	}
 
-	if task.GroupID != *user.GroupID {
+	if task.GroupID != groupID {
 		return internalerrors.ErrUserNotMemberOfGroup
 	}
 
-	err = t.taskRepo.Delete(ctx, taskID)
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Restore(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskRestored, task.ID, task.Title, nil)
+	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return t.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s har gendannet opgaven '%s'", user.Name, task.Title))
 }
 
-func (t *TaskLogic) Get(ctx *gin.Context, userID string, taskID string) (*Task, error) {
-	user, err := t.userRepo.Get(ctx, userID)
+// Purge permanently deletes a task from the trash.
+func (t *TaskLogic) Purge(ctx context.Context, userID string, groupID string, taskID string) error {
+	err := t.authorize(ctx, userID, groupID, PermissionDeleteTasks)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if user.GroupID == nil {
-		return nil, internalerrors.ErrUserNotInGroup
+	task, err := t.taskRepo.GetDeleted(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	if task.GroupID != groupID {
+		return internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Purge(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskPurged, task.ID, task.Title, nil)
+	})
+}
+
+// PurgeTrash permanently deletes all tasks which have been in the trash for longer than the retention period.
+func (t *TaskLogic) PurgeTrash(ctx context.Context, retention time.Duration) error {
+	purged, err := t.taskRepo.PurgeDeletedBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Purged %d tasks from the trash\n", purged)
+	return nil
+}
+
+func (t *TaskLogic) Get(ctx *gin.Context, userID string, groupID string, taskID string) (*Task, error) {
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return nil, err
 	}
 
 	task, err := t.taskRepo.Get(ctx, taskID)
@@ -211,7 +418,7 @@ func (t *TaskLogic) Get(ctx *gin.Context, userID string, taskID string) (*Task,
 		return nil, err
 	}
 
-	if *user.GroupID != task.GroupID {
+	if task.GroupID != groupID {
 		return nil, internalerrors.ErrUserNotMemberOfGroup
 	}
 
@@ -222,60 +429,105 @@ func (t *TaskLogic) Get(ctx *gin.Context, userID string, taskID string) (*Task,
 		Description:      task.Description,
 		Assignee:         task.Assignee,
 		RotatingAssignee: task.RotatingAssignee,
+		ClaimedBy:        task.ClaimedBy,
+		Category:         task.Category,
 		IntervalSize:     task.IntervalSize,
 		IntervalUnit:     task.IntervalUnit,
 		DaysLeft:         0,
 		PercentageLeft:   0,
 		DueDate:          "",
+		WebhookEnabled:   task.WebhookHashedToken != nil,
+		WebhookSigned:    task.WebhookSigned,
 	}, nil
 }
 
-func (t *TaskLogic) Update(ctx *gin.Context, userID string, taskID string, editTask NewTask) error {
-	user, err := t.userRepo.Get(ctx, userID)
+func (t *TaskLogic) Update(ctx *gin.Context, userID string, groupID string, taskID string, editTask NewTask) error {
+	err := t.authorize(ctx, userID, groupID, PermissionEditTasks)
 	if err != nil {
 		return err
 	}
 
-	if user.GroupID == nil {
-		return internalerrors.ErrUserNotInGroup
-	}
-
 	task, err := t.taskRepo.Get(ctx, taskID)
 	if err != nil {
 		return err
 	}
 
-	if *user.GroupID != task.GroupID {
+	if task.GroupID != groupID {
 		return internalerrors.ErrUserNotMemberOfGroup
 	}
 
-	err = t.taskRepo.Update(ctx, database.Task{
+	updated := database.Task{
 		ID:               taskID,
 		Title:            editTask.Title,
 		Description:      editTask.Description,
-		GroupID:          *user.GroupID,
+		GroupID:          groupID,
 		Assignee:         editTask.Assignee,
 		RotatingAssignee: editTask.RotatingAssignee,
+		Category:         editTask.Category,
 		IntervalSize:     editTask.IntervalSize,
 		IntervalUnit:     editTask.IntervalUnit,
 		NextDueDate:      calculateNextDueDate(editTask.IntervalUnit, editTask.IntervalSize),
 		UpdatedAt:        time.Now(),
-	})
+	}
+
+	changes, err := t.taskChanges(ctx, *task, updated)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Update(ctx, updated)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskEdited, task.ID, updated.Title, changes)
+	})
 }
 
-func (t *TaskLogic) Complete(ctx context.Context, userID string, taskID string) error {
-	user, err := t.userRepo.Get(ctx, userID)
+// taskChanges returns the fields changed by an edit of the task. Assignees are given by name.
+func (t *TaskLogic) taskChanges(ctx context.Context, before database.Task, after database.Task) ([]Change, error) {
+	beforeAssignee, err := t.userName(ctx, before.Assignee)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	afterAssignee, err := t.userName(ctx, after.Assignee)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	changes = addChange(changes, "title", before.Title, after.Title)
+	changes = addChange(changes, "description", before.Description, after.Description)
+	changes = addChange(changes, "category", before.Category, after.Category)
+	changes = addChange(changes, "assignee", beforeAssignee, afterAssignee)
+	changes = addChange(changes, "rotatingAssignee", strconv.FormatBool(before.RotatingAssignee), strconv.FormatBool(after.RotatingAssignee))
+	changes = addChange(changes, "interval", formatInterval(before.IntervalSize, before.IntervalUnit), formatInterval(after.IntervalSize, after.IntervalUnit))
+	changes = addChange(changes, "nextDueDate", before.NextDueDate.Format("2006-01-02"), after.NextDueDate.Format("2006-01-02"))
+	return changes, nil
+}
+
+// userName returns the name of the user, or an empty string if no user is given.
+func (t *TaskLogic) userName(ctx context.Context, userID *string) (string, error) {
+	if userID == nil {
+		return "", nil
 	}
 
-	if user.GroupID == nil {
-		return internalerrors.ErrUserNotInGroup
+	user, err := t.userRepo.Get(ctx, *userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return user.Name, nil
+}
+
+func (t *TaskLogic) Complete(ctx context.Context, userID string, groupID string, taskID string) error {
+	user, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return err
 	}
 
 	task, err := t.taskRepo.Get(ctx, taskID)
@@ -283,52 +535,607 @@ func (t *TaskLogic) Complete(ctx context.Context, userID string, taskID string)
 		return err
 	}
 
-	if task.GroupID != *user.GroupID {
+	if task.GroupID != groupID {
 		return internalerrors.ErrUserNotMemberOfGroup
 	}
 
-	err = t.taskRepo.UpdateCompleted(ctx, taskID, time.Now(), calculateNextDueDate(task.IntervalUnit, task.IntervalSize), task.Assignee)
+	if needsApproval(user) {
+		return t.requestApproval(ctx, user, task)
+	}
+
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.complete(ctx, t.taskRepo.WithTx(tx), user, task)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskCompleted, task.ID, task.Title, nil)
+	})
 	if err != nil {
 		return err
 	}
 
+	err = t.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s har lige udført opgaven '%s'", user.Name, task.Title))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// complete marks the task as completed by the user, using a task repo which may be part of a transaction.
+func (t *TaskLogic) complete(ctx context.Context, taskRepo *database.TaskRepo, user *database.User, task *database.Task) error {
 	if task.RotatingAssignee {
-		if task.Assignee == nil {
-			task.Assignee = &userID
+		current := user.ID
+		if task.Assignee != nil {
+			current = *task.Assignee
 		}
-		users, err := t.userRepo.GetByGroup(ctx, *user.GroupID)
+		users, err := t.userRepo.GetByGroup(ctx, task.GroupID)
+		if err != nil {
+			return err
+		}
+		task.Assignee = nextInRotation(users, current)
+	}
+
+	err := taskRepo.UpdateCompleted(ctx, task.ID, time.Now(), calculateNextDueDate(task.IntervalUnit, task.IntervalSize), task.Assignee)
+	if err != nil {
+		return err
+	}
+
+	if task.IntervalUnit == "onetime" {
+		err = taskRepo.Delete(ctx, task.ID, user.ID)
 		if err != nil {
 			return err
 		}
-		i := 0
-		for range users {
-			if users[i].ID == *task.Assignee {
-				break
+	}
+
+	return nil
+}
+
+// nextInRotation returns the member after the current one, starting over when the end is reached. If the current
+// member is no longer in the group, the rotation starts over with the first member.
+func nextInRotation(users []database.User, current string) *string {
+	if len(users) == 0 {
+		return nil
+	}
+
+	i := 0
+	for range users {
+		if users[i].ID == current {
+			break
+		}
+		i++
+	}
+	if i >= len(users)-1 {
+		return &users[0].ID
+	}
+	return &users[i+1].ID
+}
+
+const (
+	BulkActionComplete     = "complete"
+	BulkActionPostpone     = "postpone"
+	BulkActionReassign     = "reassign"
+	BulkActionRecategorise = "recategorise"
+	BulkActionDelete       = "delete"
+)
+
+// bulkActionPermissions are the permissions needed for bulk actions. Completing tasks needs no permission.
+var bulkActionPermissions = map[string]string{
+	BulkActionPostpone:     PermissionEditTasks,
+	BulkActionReassign:     PermissionEditTasks,
+	BulkActionRecategorise: PermissionEditTasks,
+	BulkActionDelete:       PermissionDeleteTasks,
+}
+
+type BulkAction struct {
+	TaskIDs []string
+	// Action is one of the BulkAction constants.
+	Action string
+	// PostponeDays is the number of days to postpone the tasks with, used by BulkActionPostpone.
+	PostponeDays int
+	// Assignee is the userID the tasks are assigned to by BulkActionReassign. Nil makes them common tasks.
+	Assignee *string
+	// Category is the category the tasks are moved to by BulkActionRecategorise.
+	Category string
+}
+
+// Bulk performs the same action on several tasks in a single transaction. Either every task is updated or none
+// are, and the group is notified once about all of them.
+func (t *TaskLogic) Bulk(ctx context.Context, userID string, groupID string, bulkAction BulkAction) error {
+	user, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	if len(bulkAction.TaskIDs) == 0 {
+		return internalerrors.ErrNoTasksSelected
+	}
+
+	var assigneeName string
+	switch bulkAction.Action {
+	case BulkActionComplete, BulkActionDelete, BulkActionRecategorise:
+	case BulkActionPostpone:
+		if bulkAction.PostponeDays < 1 {
+			return internalerrors.ErrInvalidBulkAction
+		}
+	case BulkActionReassign:
+		assigneeName = "alle"
+		if bulkAction.Assignee != nil {
+			assignee, err := getMember(ctx, t.userRepo, *bulkAction.Assignee, groupID)
+			if err != nil {
+				return err
 			}
-			i++
+			assigneeName = assignee.Name
+		}
+	default:
+		return internalerrors.ErrInvalidBulkAction
+	}
+
+	if permission, ok := bulkActionPermissions[bulkAction.Action]; ok {
+		err = t.authorize(ctx, userID, groupID, permission)
+		if err != nil {
+			return err
+		}
+	}
+
+	var titles []string
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		taskRepo := t.taskRepo.WithTx(tx)
+		activityRepo := t.activityRepo.WithTx(tx)
+		completionRepo := t.completionRepo.WithTx(tx)
+		for _, taskID := range bulkAction.TaskIDs {
+			task, err := taskRepo.Get(ctx, taskID)
+			if err != nil {
+				return err
+			}
+
+			if task.GroupID != groupID {
+				return internalerrors.ErrUserNotMemberOfGroup
+			}
+
+			var action string
+			var changes []Change
+			switch bulkAction.Action {
+			case BulkActionComplete:
+				if needsApproval(user) {
+					action = ActivityCompletionRequested
+					err = t.createCompletion(ctx, completionRepo, user, task)
+					break
+				}
+				action = ActivityTaskCompleted
+				err = t.complete(ctx, taskRepo, user, task)
+			case BulkActionPostpone:
+				from := task.NextDueDate
+				if from.Before(time.Now()) {
+					from = time.Now()
+				}
+				nextDueDate := from.AddDate(0, 0, bulkAction.PostponeDays)
+				action = ActivityTaskPostponed
+				changes = addChange(changes, "nextDueDate", task.NextDueDate.Format("2006-01-02"), nextDueDate.Format("2006-01-02"))
+				err = taskRepo.SetNextDueDate(ctx, task.ID, nextDueDate)
+			case BulkActionReassign:
+				var before, after string
+				before, err = t.userName(ctx, task.Assignee)
+				if err != nil {
+					return err
+				}
+				after, err = t.userName(ctx, bulkAction.Assignee)
+				if err != nil {
+					return err
+				}
+				action = ActivityTaskEdited
+				changes = addChange(changes, "assignee", before, after)
+				err = taskRepo.SetAssignee(ctx, task.ID, bulkAction.Assignee)
+			case BulkActionRecategorise:
+				action = ActivityTaskEdited
+				changes = addChange(changes, "category", task.Category, bulkAction.Category)
+				err = taskRepo.SetCategory(ctx, task.ID, bulkAction.Category)
+			case BulkActionDelete:
+				action = ActivityTaskDeleted
+				err = taskRepo.Delete(ctx, task.ID, userID)
+			}
+			if err != nil {
+				return err
+			}
+
+			err = recordActivity(ctx, activityRepo, groupID, userID, action, task.ID, task.Title, changes)
+			if err != nil {
+				return err
+			}
+
+			titles = append(titles, task.Title)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var header string
+	switch bulkAction.Action {
+	case BulkActionComplete:
+		header = fmt.Sprintf("%s har lige udført %d opgaver:", user.Name, len(titles))
+		if needsApproval(user) {
+			header = fmt.Sprintf("%s har udført %d opgaver, som venter på godkendelse:", user.Name, len(titles))
 		}
-		if i >= len(users)-1 {
-			task.Assignee = &users[0].ID
+	case BulkActionPostpone:
+		header = fmt.Sprintf("%s har udskudt %d opgaver med %d dage:", user.Name, len(titles), bulkAction.PostponeDays)
+	case BulkActionReassign:
+		header = fmt.Sprintf("%s har tildelt %d opgaver til %s:", user.Name, len(titles), assigneeName)
+	case BulkActionRecategorise:
+		header = fmt.Sprintf("%s har flyttet %d opgaver til kategorien '%s':", user.Name, len(titles), bulkAction.Category)
+	case BulkActionDelete:
+		header = fmt.Sprintf("%s har slettet %d opgaver:", user.Name, len(titles))
+	}
+
+	return t.notificationLogic.NotifyAllInGroup(ctx, groupID, util.TaskListMessage(header, titles))
+}
+
+// RegisterUsage adds count uses to a usage task. When the task reaches its threshold, the assignee, or the whole
+// group for common tasks, is notified that it is due.
+func (t *TaskLogic) RegisterUsage(ctx context.Context, userID string, groupID string, taskID string, count int) (Task, error) {
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return Task{}, err
+	}
+
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+
+	if task.GroupID != groupID {
+		return Task{}, internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return t.registerUsage(ctx, userID, task, count)
+}
+
+// registerUsage adds count uses to the task for the user, or for its webhook if userID is empty.
+func (t *TaskLogic) registerUsage(ctx context.Context, userID string, task *database.Task, count int) (Task, error) {
+	if task.IntervalUnit != "usage" {
+		return Task{}, internalerrors.ErrTaskNotUsageBased
+	}
+
+	if count < 1 {
+		count = 1
+	}
+
+	group, err := t.groupRepo.Get(ctx, task.GroupID)
+	if err != nil {
+		return Task{}, err
+	}
+
+	var updated *database.Task
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		var err error
+		updated, err = t.taskRepo.WithTx(tx).IncrementUsage(ctx, task.ID, count)
+		if err != nil {
+			return err
+		}
+
+		changes := []Change{{
+			Field:  "usageCount",
+			Before: strconv.Itoa(updated.UsageCount - count),
+			After:  strconv.Itoa(updated.UsageCount),
+		}}
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), task.GroupID, userID, ActivityTaskUsed, task.ID, task.Title, changes)
+	})
+	if err != nil {
+		return Task{}, err
+	}
+
+	if task.UsageCount < task.IntervalSize && updated.UsageCount >= updated.IntervalSize {
+		msg := fmt.Sprintf("Opgaven '%s' skal udføres nu. Den er brugt %d gange siden sidst.", updated.Title, updated.UsageCount)
+		if updated.Assignee != nil {
+			err = t.notificationLogic.SendNotification(ctx, *updated.Assignee, msg)
+		} else if updated.ClaimedBy != nil {
+			err = t.notificationLogic.SendNotification(ctx, *updated.ClaimedBy, msg)
 		} else {
-			task.Assignee = &users[i+1].ID
+			err = t.notificationLogic.NotifyAllInGroup(ctx, updated.GroupID, msg)
+		}
+		if err != nil {
+			log.Printf("Failed to notify that usage task=%s is due: %s\n", updated.ID, err)
 		}
 	}
 
-	if task.IntervalUnit == "onetime" {
-		err = t.taskRepo.Delete(ctx, task.ID)
+	return Task{
+		ID:             updated.ID,
+		GroupID:        updated.GroupID,
+		Title:          updated.Title,
+		Description:    updated.Description,
+		Category:       updated.Category,
+		IntervalSize:   updated.IntervalSize,
+		IntervalUnit:   updated.IntervalUnit,
+		UsageCount:     updated.UsageCount,
+		DaysLeft:       calculateDaysLeft(*updated, groupLocale(group).location),
+		PercentageLeft: calculatePercentageLeft(*updated),
+	}, nil
+}
+
+// Webhook is a newly created webhook of a task. The token and secret are only returned when it is created, as only a
+// hash of the token and the encrypted secret are stored.
+type Webhook struct {
+	TaskID string
+	Token  string
+	// Secret is empty when the webhook doesn't require signed requests.
+	Secret string
+}
+
+// RotateWebhook enables the webhook of a task with a new token and secret, invalidating any previous ones.
+func (t *TaskLogic) RotateWebhook(ctx context.Context, userID string, groupID string, taskID string, signed bool) (Webhook, error) {
+	err := t.authorize(ctx, userID, groupID, PermissionEditTasks)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	task, err := t.getForUser(ctx, userID, groupID, taskID)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	token, err := util.RandomToken(24)
+	if err != nil {
+		return Webhook{}, err
+	}
+	hashedToken := util.HashToken(token)
+
+	output := Webhook{TaskID: task.ID, Token: token}
+	var encryptedSecret *string
+	webhook := "unsigned"
+	if signed {
+		webhook = "signed"
+		output.Secret, err = util.RandomToken(32)
+		if err != nil {
+			return Webhook{}, err
+		}
+		encrypted, err := util.Encrypt(t.encryptionKey, output.Secret)
+		if err != nil {
+			return Webhook{}, err
+		}
+		encryptedSecret = &encrypted
+	}
+
+	err = t.setWebhook(ctx, userID, task, &hashedToken, encryptedSecret, signed, webhook)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return output, nil
+}
+
+func (t *TaskLogic) DisableWebhook(ctx context.Context, userID string, groupID string, taskID string) error {
+	err := t.authorize(ctx, userID, groupID, PermissionEditTasks)
+	if err != nil {
+		return err
+	}
+
+	task, err := t.getForUser(ctx, userID, groupID, taskID)
+	if err != nil {
+		return err
+	}
+
+	return t.setWebhook(ctx, userID, task, nil, nil, false, "disabled")
+}
+
+// setWebhook changes the webhook of the task and records it in the activity log. A rotated token is always recorded
+// as a change, even when the kind of webhook stays the same.
+func (t *TaskLogic) setWebhook(
+	ctx context.Context,
+	userID string,
+	task *database.Task,
+	hashedToken *string,
+	encryptedSecret *string,
+	signed bool,
+	webhook string,
+) error {
+	before := "disabled"
+	if task.WebhookHashedToken != nil && task.WebhookSigned {
+		before = "signed"
+	} else if task.WebhookHashedToken != nil {
+		before = "unsigned"
+	}
+
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).SetWebhook(ctx, task.ID, hashedToken, encryptedSecret, signed)
+		if err != nil {
+			return err
+		}
+
+		changes := []Change{{Field: "webhook", Before: before, After: webhook}}
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), task.GroupID, userID, ActivityTaskEdited, task.ID, task.Title, changes)
+	})
+}
+
+// TriggerWebhook handles a call to the webhook of a task. Usage tasks get one use registered, while other tasks
+// become due now and the assignee, or the whole group for common tasks, is notified.
+func (t *TaskLogic) TriggerWebhook(ctx context.Context, token string, body []byte, signature string) error {
+	task, err := t.taskRepo.GetByWebhookHashedToken(ctx, util.HashToken(token))
+	if err != nil {
+		return err
+	}
+
+	if task.WebhookSigned {
+		if task.WebhookEncryptedSecret == nil {
+			return internalerrors.ErrInvalidSignature
+		}
+		secret, err := util.Decrypt(t.encryptionKey, *task.WebhookEncryptedSecret)
 		if err != nil {
 			return err
 		}
+		if !util.ValidSignature(secret, body, signature) {
+			return internalerrors.ErrInvalidSignature
+		}
+	}
+
+	if task.IntervalUnit == "usage" {
+		_, err = t.registerUsage(ctx, "", task, 1)
+		return err
 	}
 
-	err = t.notificationLogic.NotifyAllInGroup(ctx, *user.GroupID, fmt.Sprintf("%s har lige udført opgaven '%s'", user.Name, task.Title))
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).SetNextDueDate(ctx, task.ID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), task.GroupID, "", ActivityTaskTriggered, task.ID, task.Title, nil)
+	})
 	if err != nil {
 		return err
 	}
 
+	msg := fmt.Sprintf("Opgaven '%s' skal udføres nu.", task.Title)
+	if task.Assignee != nil {
+		return t.notificationLogic.SendNotification(ctx, *task.Assignee, msg)
+	}
+	if task.ClaimedBy != nil {
+		return t.notificationLogic.SendNotification(ctx, *task.ClaimedBy, msg)
+	}
+	return t.notificationLogic.NotifyAllInGroupWithActions(ctx, task.GroupID, msg, []NotificationAction{{
+		Text:    "Jeg tager den",
+		Action:  ActionClaimTask,
+		Payload: task.ID,
+	}})
+}
+
+// authorize returns ErrMissingPermission if the user's role in the group does not grant the permission.
+func (t *TaskLogic) authorize(ctx context.Context, userID string, groupID string, permission string) error {
+	return authorize(ctx, t.groupRepo, t.membershipRepo, userID, groupID, permission)
+}
+
+// getForUser gets a task, making sure the user is a member of the group and the task belongs to it.
+func (t *TaskLogic) getForUser(ctx context.Context, userID string, groupID string, taskID string) (*database.Task, error) {
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.GroupID != groupID {
+		return nil, internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	return task, nil
+}
+
+// Claim lets a member take an unassigned task for its current occurrence. The claim is released when the task is
+// completed.
+func (t *TaskLogic) Claim(ctx context.Context, userID string, groupID string, taskID string) error {
+	user, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	if task.GroupID != groupID {
+		return internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	if task.Assignee != nil {
+		return internalerrors.ErrTaskAlreadyAssigned
+	}
+
+	if task.ClaimedBy != nil {
+		if *task.ClaimedBy == userID {
+			return nil
+		}
+		return internalerrors.ErrTaskAlreadyClaimed
+	}
+
+	err = t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).Claim(ctx, taskID, userID)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskClaimed, task.ID, task.Title, nil)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Another member claimed the task, or it was assigned, since it was read.
+			return internalerrors.ErrTaskAlreadyClaimed
+		}
+		return err
+	}
+
+	// The claim is saved, so failing to tell the group about it doesn't make the claim fail.
+	err = t.notificationLogic.NotifyAllInGroup(ctx, groupID, fmt.Sprintf("%s har taget opgaven '%s'", user.Name, task.Title))
+	if err != nil {
+		log.Printf("Failed to notify group=%s that task=%s was claimed: %s\n", groupID, taskID, err)
+	}
+
 	return nil
 }
 
+// Unclaim releases a claim on a task, making it a common task again.
+func (t *TaskLogic) Unclaim(ctx context.Context, userID string, groupID string, taskID string) error {
+	_, err := getMember(ctx, t.userRepo, userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	if task.GroupID != groupID {
+		return internalerrors.ErrUserNotMemberOfGroup
+	}
+
+	if task.ClaimedBy == nil || *task.ClaimedBy != userID {
+		return internalerrors.ErrTaskNotClaimedByUser
+	}
+
+	return t.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := t.taskRepo.WithTx(tx).SetClaimedBy(ctx, taskID, nil)
+		if err != nil {
+			return err
+		}
+
+		return recordActivity(ctx, t.activityRepo.WithTx(tx), groupID, userID, ActivityTaskUnclaimed, task.ID, task.Title, nil)
+	})
+}
+
+// HandleClaimAction is called when a user presses the claim button on a common task reminder.
+func (t *TaskLogic) HandleClaimAction(ctx context.Context, userID string, taskID string) (string, error) {
+	task, err := t.taskRepo.Get(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "Opgaven findes ikke længere.", nil
+		}
+		return "", err
+	}
+
+	err = t.Claim(ctx, userID, task.GroupID, taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, internalerrors.ErrUserNotMemberOfGroup):
+			return "Du er ikke længere medlem af gruppen.", nil
+		case errors.Is(err, internalerrors.ErrTaskAlreadyClaimed):
+			return "Opgaven er allerede taget af en anden.", nil
+		case errors.Is(err, internalerrors.ErrTaskAlreadyAssigned):
+			return "Opgaven er allerede tildelt en anden.", nil
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return "Opgaven findes ikke længere.", nil
+		}
+		return "", err
+	}
+
+	return "Du har taget opgaven. Tak! 👏", nil
+}
+
+// NotifyTasksDueToday reminds every group about the tasks due today, regardless of the reminder time of the group.
 func (t *TaskLogic) NotifyTasksDueToday(ctx context.Context) error {
 	groups, err := t.groupRepo.GetAll(ctx)
 	if err != nil {
@@ -336,42 +1143,95 @@ func (t *TaskLogic) NotifyTasksDueToday(ctx context.Context) error {
 		return err
 	}
 	for _, group := range groups {
-		var tasksForAll []string
-		assignedTasks := map[string][]string{}
-		tasks, err := t.GetAllForGroup(ctx, group.ID)
+		err = t.notifyGroupTasksDueToday(ctx, group)
 		if err != nil {
-			log.Printf("ERROR: NotifyTasksDueToday: Failed to get all tasks for group=%s: %s", group.ID, err)
 			return err
 		}
+	}
+	return nil
+}
 
-		for _, task := range tasks {
-			if task.DaysLeft > 0 {
-				continue
-			}
+// RemindGroups reminds the groups whose reminder time has passed since they were last reminded about the tasks due
+// today.
+func (t *TaskLogic) RemindGroups(ctx context.Context, now time.Time) error {
+	groups, err := t.groupRepo.GetAll(ctx)
+	if err != nil {
+		log.Printf("ERROR: RemindGroups: Failed to get all groups: %s", err)
+		return err
+	}
+	for _, group := range groups {
+		if !reminderDue(&group, now) {
+			continue
+		}
 
-			if task.Assignee == nil {
-				tasksForAll = append(tasksForAll, task.Title)
-			} else {
-				assignedTasks[*task.Assignee] = append(assignedTasks[*task.Assignee], task.Title)
-			}
+		// Mark the group as reminded first, so a failing group isn't reminded again every minute.
+		err = t.groupRepo.SetLastRemindedAt(ctx, group.ID, now)
+		if err != nil {
+			log.Printf("ERROR: RemindGroups: Failed to mark group=%s as reminded: %s", group.ID, err)
+			continue
 		}
 
-		if len(tasksForAll) > 0 {
-			msg := util.CommonTaskMessage(tasksForAll)
-			err = t.notificationLogic.NotifyAllInGroup(ctx, group.ID, msg)
-			if err != nil {
-				log.Printf("ERROR: NotifyTasksDueToday: Failed to notify all in group=%s: %s", group.ID, err)
-				// Log but continue
-			}
+		err = t.notifyGroupTasksDueToday(ctx, group)
+		if err != nil {
+			log.Printf("ERROR: RemindGroups: Failed to remind group=%s: %s", group.ID, err)
 		}
+	}
+	return nil
+}
 
-		for assignee, titles := range assignedTasks {
-			msg := util.AssignedTasksMessage(titles)
-			err = t.notificationLogic.SendNotification(ctx, assignee, msg)
-			if err != nil {
-				log.Printf("ERROR: NotifyTasksDueToday: Failed to notify user=%s in group=%s: %s", assignee, group.ID, err)
-				continue
-			}
+func (t *TaskLogic) notifyGroupTasksDueToday(ctx context.Context, group database.Group) error {
+	var tasksForAll []Task
+	assignedTasks := map[string][]string{}
+	tasks, err := t.GetAllForGroup(ctx, group.ID)
+	if err != nil {
+		log.Printf("ERROR: NotifyTasksDueToday: Failed to get all tasks for group=%s: %s", group.ID, err)
+		return err
+	}
+
+	for _, task := range tasks {
+		if task.DaysLeft > 0 {
+			continue
+		}
+
+		if task.Assignee != nil {
+			assignedTasks[*task.Assignee] = append(assignedTasks[*task.Assignee], task.Title)
+		} else if task.ClaimedBy != nil {
+			assignedTasks[*task.ClaimedBy] = append(assignedTasks[*task.ClaimedBy], task.Title)
+		} else {
+			tasksForAll = append(tasksForAll, task)
+		}
+	}
+
+	claimText := "Jeg tager '%s'"
+	if group.Language == LanguageEnglish {
+		claimText = "I'll take '%s'"
+	}
+
+	if len(tasksForAll) > 0 {
+		var titles []string
+		var actions []NotificationAction
+		for _, task := range tasksForAll {
+			titles = append(titles, task.Title)
+			actions = append(actions, NotificationAction{
+				Text:    fmt.Sprintf(claimText, task.Title),
+				Action:  ActionClaimTask,
+				Payload: task.ID,
+			})
+		}
+		msg := util.CommonTaskMessage(group.Language, titles)
+		err = t.notificationLogic.NotifyAllInGroupWithActions(ctx, group.ID, msg, actions)
+		if err != nil {
+			log.Printf("ERROR: NotifyTasksDueToday: Failed to notify all in group=%s: %s", group.ID, err)
+			// Log but continue
+		}
+	}
+
+	for assignee, titles := range assignedTasks {
+		msg := util.AssignedTasksMessage(group.Language, titles)
+		err = t.notificationLogic.SendNotification(ctx, assignee, msg)
+		if err != nil {
+			log.Printf("ERROR: NotifyTasksDueToday: Failed to notify user=%s in group=%s: %s", assignee, group.ID, err)
+			continue
 		}
 	}
 	return nil
@@ -397,29 +1257,51 @@ func (t *TaskLogic) getLocalizedInterval(size int, unit string) string {
 		} else {
 			return fmt.Sprintf("hver %d. måned", size)
 		}
+	case "usage":
+		if size == 1 {
+			return "efter hver brug"
+		} else {
+			return fmt.Sprintf("efter %d brug", size)
+		}
 	}
 	return "ukendt interval"
 }
 
-func dateFormat(date time.Time) string {
+func dateFormat(date time.Time, l locale) string {
+	date = date.In(l.location)
+	if l.language == LanguageEnglish {
+		return date.Format("Monday, January 2, 2006")
+	}
 	weekday := strings.ToLower(dayMap[date.Weekday()])
 	month := strings.ToLower(monthMap[date.Month()])
 	return fmt.Sprintf("%s, %d. %s %d", weekday, date.Day(), month, date.Year())
 }
 
-func calculateDaysLeft(date time.Time) int {
-	now := time.Now()
-	fixedUntil := date.Truncate(24 * time.Hour).Sub(now.Truncate(24 * time.Hour))
-	if fixedUntil < 0 {
+// calculateDaysLeft returns the number of days until the task is due, where days start at midnight in the given
+// location. For usage tasks it returns the number of uses left.
+func calculateDaysLeft(task database.Task, location *time.Location) int {
+	if task.IntervalUnit == "usage" {
+		return calculateUsesLeft(task)
+	}
+
+	daysLeft := util.DaysBetween(time.Now(), task.NextDueDate, location)
+	if daysLeft < 0 {
 		return 0
 	}
-	return int(fixedUntil.Hours() / 24)
+	return daysLeft
 }
 
-func calculatePercentageLeft(unit string, size int, nextDueDate time.Time) float64 {
-	totalHours := calculateTotalHours(unit, size)
+func calculatePercentageLeft(task database.Task) float64 {
+	if task.IntervalUnit == "usage" {
+		if task.IntervalSize < 1 {
+			return 0
+		}
+		return float64(calculateUsesLeft(task)) / float64(task.IntervalSize)
+	}
 
-	hoursUntilDue := time.Until(nextDueDate).Hours()
+	totalHours := calculateTotalHours(task.IntervalUnit, task.IntervalSize)
+
+	hoursUntilDue := time.Until(task.NextDueDate).Hours()
 
 	result := hoursUntilDue / float64(totalHours)
 	if result < 0 {
@@ -429,6 +1311,14 @@ func calculatePercentageLeft(unit string, size int, nextDueDate time.Time) float
 	return result
 }
 
+func calculateUsesLeft(task database.Task) int {
+	usesLeft := task.IntervalSize - task.UsageCount
+	if usesLeft < 0 {
+		return 0
+	}
+	return usesLeft
+}
+
 func calculateTotalHours(unit string, size int) int {
 	switch unit {
 	case "day":
@@ -60,3 +60,30 @@ func (l *TelegramLogic) SendMessage(ctx context.Context, userID string, msg stri
 
 	return l.telegramClient.SendMessage(ctx, dbTelegram.TelegramUserID, msg)
 }
+
+func (l *TelegramLogic) SendMessageWithActions(ctx context.Context, userID string, msg string, actions []NotificationAction) error {
+	dbTelegram, err := l.telegramRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Skip users who have not setup Telegram connection
+			return nil
+		}
+		return err
+	}
+
+	var buttons []telegram.InlineKeyboardButton
+	for _, action := range actions {
+		buttons = append(buttons, telegram.InlineKeyboardButton{
+			Text:         action.Text,
+			CallbackData: fmt.Sprintf("%s:%s", action.Action, action.Payload),
+		})
+	}
+
+	return l.telegramClient.SendMessageWithButtons(ctx, dbTelegram.TelegramUserID, msg, buttons)
+}
+
+// HandleAction registers the handler called when a user presses a button created from a NotificationAction with
+// the given action.
+func (l *TelegramLogic) HandleAction(action string, handler func(ctx context.Context, userID string, payload string) (string, error)) {
+	l.telegramClient.HandleCallback(action, handler)
+}
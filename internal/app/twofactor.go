@@ -0,0 +1,318 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// totpIssuer is the name authenticator apps show next to the codes.
+	totpIssuer = "Taskeroo"
+	// twoFactorLoginLifetime is how long a user has to enter a code after entering their password.
+	twoFactorLoginLifetime = 10 * time.Minute
+	// recoveryCodeCount is the number of recovery codes a user gets when enabling two-factor authentication.
+	recoveryCodeCount = 10
+)
+
+// TwoFactorSetup is what the user adds to their authenticator app to enable two-factor authentication.
+type TwoFactorSetup struct {
+	// Secret is for typing into the app, if the QR code of URI can't be scanned.
+	Secret string
+	URI    string
+}
+
+// GetTwoFactorSetup returns the secret the user must add to their authenticator app before enabling two-factor
+// authentication. The same secret is returned until it is enabled, so the page and its QR code agree.
+func (a *AuthLogic) GetTwoFactorSetup(ctx context.Context, userID string) (TwoFactorSetup, error) {
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	if isManaged(user) {
+		return TwoFactorSetup{}, internalerrors.ErrManagedUser
+	}
+	if user.TOTPEnabledAt != nil {
+		return TwoFactorSetup{}, internalerrors.ErrTwoFactorEnabled
+	}
+
+	secret := ""
+	if user.TOTPSecret != "" {
+		secret, err = util.Decrypt(a.encryptionKey, user.TOTPSecret)
+		if err != nil {
+			// The encryption key has changed since the setup was started, so it is started over.
+			log.Printf("Failed to decrypt TOTP secret of user=%s: %s\n", userID, err)
+			secret = ""
+		}
+	}
+	if secret == "" {
+		secret, err = util.RandomTOTPSecret()
+		if err != nil {
+			return TwoFactorSetup{}, err
+		}
+		encryptedSecret, err := util.Encrypt(a.encryptionKey, secret)
+		if err != nil {
+			return TwoFactorSetup{}, err
+		}
+		err = a.userRepo.SetTOTPSecret(ctx, userID, encryptedSecret)
+		if err != nil {
+			return TwoFactorSetup{}, err
+		}
+	}
+
+	return TwoFactorSetup{Secret: secret, URI: util.TOTPURI(totpIssuer, user.Email, secret)}, nil
+}
+
+// EnableTwoFactor enables two-factor authentication, if the code matches the secret from GetTwoFactorSetup. It returns
+// the recovery codes, which are only shown this once.
+func (a *AuthLogic) EnableTwoFactor(ctx context.Context, userID string, code string) ([]string, error) {
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isManaged(user) {
+		return nil, internalerrors.ErrManagedUser
+	}
+	if user.TOTPEnabledAt != nil {
+		return nil, internalerrors.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, internalerrors.ErrInvalidTwoFactorCode
+	}
+
+	secret, err := util.Decrypt(a.encryptionKey, user.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	step, ok := util.ValidTOTP(secret, code, now)
+	if !ok {
+		return nil, internalerrors.ErrInvalidTwoFactorCode
+	}
+
+	codes, recoveryCodes, err := newRecoveryCodes(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := a.userRepo.WithTx(tx).EnableTOTP(ctx, userID, now, step)
+		if err != nil {
+			return err
+		}
+
+		return a.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with new ones, after checking their password.
+func (a *AuthLogic) RegenerateRecoveryCodes(ctx context.Context, userID string, password string) ([]string, error) {
+	_, err := a.checkTwoFactorPassword(ctx, userID, password)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, recoveryCodes, err := newRecoveryCodes(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		return a.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor disables two-factor authentication, after checking the password, and removes the recovery codes.
+func (a *AuthLogic) DisableTwoFactor(ctx context.Context, userID string, password string) error {
+	_, err := a.checkTwoFactorPassword(ctx, userID, password)
+	if err != nil {
+		return err
+	}
+
+	return a.transactor.Transaction(ctx, func(tx *gorm.DB) error {
+		err := a.userRepo.WithTx(tx).DisableTOTP(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return a.recoveryCodeRepo.WithTx(tx).DeleteAllForUser(ctx, userID)
+	})
+}
+
+// LoginWithTwoFactor completes a login which returned a TwoFactorRequiredError, with a code from the authenticator
+// app or a recovery code. Wrong codes count as failed login attempts on the account.
+func (a *AuthLogic) LoginWithTwoFactor(ctx context.Context, token string, code string, client ClientInfo) (UserSession, error) {
+	userID, ok := a.checkTwoFactorToken(token, time.Now())
+	if !ok {
+		return UserSession{}, internalerrors.ErrInvalidTwoFactorLogin
+	}
+
+	attempt, err := a.loginLimiter.attempt(ctx, client.IPAddress, twoFactorKey(userID))
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return UserSession{}, internalerrors.ErrInvalidTwoFactorLogin
+		}
+		return UserSession{}, err
+	}
+	if user.TOTPEnabledAt == nil {
+		return UserSession{}, internalerrors.ErrInvalidTwoFactorLogin
+	}
+
+	ok, err = a.checkSecondFactor(ctx, user, code)
+	if err != nil {
+		return UserSession{}, err
+	}
+	if !ok {
+		err = a.loginLimiter.fail(ctx, attempt, userID)
+		if err != nil {
+			return UserSession{}, err
+		}
+		return UserSession{}, internalerrors.ErrInvalidTwoFactorCode
+	}
+
+	err = a.loginLimiter.succeed(ctx, attempt)
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	groupID, err := a.defaultGroup(ctx, userID)
+	if err != nil {
+		return UserSession{}, err
+	}
+
+	return a.createSession(ctx, userID, groupID, client)
+}
+
+// checkSecondFactor checks a code from the authenticator app, or a recovery code, and marks it as used.
+func (a *AuthLogic) checkSecondFactor(ctx context.Context, user *database.User, code string) (bool, error) {
+	if recoveryCode, ok := util.NormalizeCode(code); ok {
+		err := a.recoveryCodeRepo.Use(ctx, user.ID, util.HashToken(recoveryCode), time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	secret, err := util.Decrypt(a.encryptionKey, user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := util.ValidTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	err = a.userRepo.UseTOTPStep(ctx, user.ID, step)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkTwoFactorPassword returns the user if they have two-factor authentication enabled and the password is right.
+func (a *AuthLogic) checkTwoFactorPassword(ctx context.Context, userID string, password string) (*database.User, error) {
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isManaged(user) {
+		return nil, internalerrors.ErrManagedUser
+	}
+	if user.TOTPEnabledAt == nil {
+		return nil, internalerrors.ErrTwoFactorNotEnabled
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password)) != nil {
+		return nil, internalerrors.ErrWrongPassword
+	}
+
+	return user, nil
+}
+
+func (a *AuthLogic) replaceRecoveryCodes(ctx context.Context, tx *gorm.DB, userID string, recoveryCodes []database.RecoveryCode) error {
+	recoveryCodeRepo := a.recoveryCodeRepo.WithTx(tx)
+	err := recoveryCodeRepo.DeleteAllForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return recoveryCodeRepo.CreateAll(ctx, recoveryCodes)
+}
+
+// twoFactorToken returns a token for the second step of logging in, which shows that the user entered the right
+// password recently.
+func (a *AuthLogic) twoFactorToken(userID string, now time.Time) string {
+	expiresAt := now.Add(twoFactorLoginLifetime).Unix()
+	return util.SignToken(a.secretKey, fmt.Sprintf("2fa|%s|%d", userID, expiresAt))
+}
+
+// checkTwoFactorToken returns the user of a token from twoFactorToken, and false if it is invalid or has expired.
+func (a *AuthLogic) checkTwoFactorToken(token string, now time.Time) (string, bool) {
+	payload, ok := util.VerifySignedToken(a.secretKey, token)
+	if !ok {
+		return "", false
+	}
+
+	parts := strings.Split(payload, "|")
+	if len(parts) != 3 || parts[0] != "2fa" {
+		return "", false
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// newRecoveryCodes returns new recovery codes for the user, and the rows storing their hashes.
+func newRecoveryCodes(userID string, now time.Time) ([]string, []database.RecoveryCode, error) {
+	var codes []string
+	var recoveryCodes []database.RecoveryCode
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := util.RandomCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, database.RecoveryCode{
+			ID:         uuid.NewString(),
+			UserID:     userID,
+			HashedCode: util.HashToken(code),
+			CreatedAt:  now,
+		})
+	}
+
+	return codes, recoveryCodes, nil
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailVerificationLifetime is how long the link in a verification email can be used.
+const emailVerificationLifetime = 48 * time.Hour
+
+// verificationResendInterval is how long a user must wait before another verification email can be sent.
+const verificationResendInterval = 2 * time.Minute
+
+// VerifyEmail marks the email in the token as verified, if it is still the email of the user. Afterwards the user is
+// added to the group they were invited to, if any.
+func (a *AuthLogic) VerifyEmail(ctx context.Context, token string) error {
+	payload, ok := util.VerifySignedToken(a.secretKey, token)
+	if !ok {
+		return internalerrors.ErrInvalidVerification
+	}
+
+	parts := strings.SplitN(payload, "|", 3)
+	if len(parts) != 3 {
+		return internalerrors.ErrInvalidVerification
+	}
+	userID, email := parts[0], parts[2]
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return internalerrors.ErrInvalidVerification
+	}
+
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return internalerrors.ErrInvalidVerification
+		}
+		return err
+	}
+	if user.Email != email {
+		return internalerrors.ErrInvalidVerification
+	}
+	if emailVerified(user) {
+		return nil
+	}
+
+	err = a.userRepo.SetEmailVerifiedAt(ctx, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = a.invitationLogic.AttachOnSignup(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to attach verified user=%s to the group they were invited to: %s\n", userID, err)
+	}
+
+	return nil
+}
+
+// ResendVerification sends a new verification email to the user. It can only be done every few minutes.
+func (a *AuthLogic) ResendVerification(ctx context.Context, userID string) error {
+	user, err := a.userRepo.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if emailVerified(user) {
+		return internalerrors.ErrEmailAlreadyVerified
+	}
+	if user.VerificationSentAt != nil && time.Since(*user.VerificationSentAt) < verificationResendInterval {
+		return internalerrors.ErrVerificationRateLimit
+	}
+
+	return a.sendVerification(ctx, user)
+}
+
+// sendVerification emails the user a link to verify their email. The link is the base URL followed by /verify-email/
+// and a token signed with the secret key, so it doesn't have to be stored.
+func (a *AuthLogic) sendVerification(ctx context.Context, user *database.User) error {
+	now := time.Now()
+	payload := fmt.Sprintf("%s|%d|%s", user.ID, now.Add(emailVerificationLifetime).Unix(), user.Email)
+	token := util.SignToken(a.secretKey, payload)
+
+	err := a.userRepo.SetVerificationSentAt(ctx, user.ID, now)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hej %s\n\n"+
+		"Bekræft din email på Taskeroo ved at følge linket:\n\n"+
+		"%s/verify-email/%s\n\n"+
+		"Linket virker i to døgn. Hvis du ikke har oprettet en bruger, kan du se bort fra denne email.",
+		user.Name, a.baseURL, token)
+	return a.mailer.Send(ctx, user.Email, "Bekræft din email", body)
+}
+
+// emailVerified tells whether the user has verified their email. Managed users have no email, and count as verified.
+func emailVerified(user *database.User) bool {
+	return isManaged(user) || user.EmailVerifiedAt != nil
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"errors"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+func (c *AuthController) GetAccount() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.renderAccount(ctx, http.StatusOK, "", "")
+	}
+}
+
+func (c *AuthController) PostChangeName() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.ChangeName(ctx.Request.Context(), userID, ctx.PostForm("name"))
+		if err != nil {
+			c.renderAccountError(ctx, userID, err)
+			return
+		}
+
+		c.renderAccount(ctx, http.StatusOK, "Dit navn er ændret.", "")
+	}
+}
+
+func (c *AuthController) PostChangeEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.ChangeEmail(ctx.Request.Context(), userID, ctx.PostForm("email"), ctx.PostForm("password"))
+		if err != nil {
+			c.renderAccountError(ctx, userID, err)
+			return
+		}
+
+		c.renderAccount(ctx, http.StatusOK, "Din email er ændret. Følg linket i den email, vi har sendt, for at bekræfte den.", "")
+	}
+}
+
+func (c *AuthController) PostChangePassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		password := ctx.PostForm("password")
+		if password != ctx.PostForm("repeated-password") {
+			c.renderAccount(ctx, http.StatusBadRequest, "", "De to passwords matcher ikke")
+			return
+		}
+
+		err := c.authService.ChangePassword(ctx.Request.Context(), userID, ctx.GetString(KeySession), ctx.PostForm("current-password"), password)
+		if err != nil {
+			c.renderAccountError(ctx, userID, err)
+			return
+		}
+
+		c.renderAccount(ctx, http.StatusOK, "Dit password er ændret, og du er logget ud på alle andre enheder.", "")
+	}
+}
+
+func (c *AuthController) renderAccountError(ctx *gin.Context, userID string, err error) {
+	var alert string
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, internalerrors.ErrEmptyName):
+		alert = "Navn felt skal udfyldes"
+	case errors.Is(err, internalerrors.ErrInvalidEmail):
+		alert = "Emailen er ikke gyldig"
+	case errors.Is(err, internalerrors.ErrEmailTaken):
+		alert = "Emailen bruges allerede af en anden bruger"
+	case errors.Is(err, internalerrors.ErrWrongPassword):
+		alert = "Det nuværende password er forkert"
+	case errors.Is(err, internalerrors.ErrEmptyPassword):
+		alert = "Password felt skal udfyldes"
+	case errors.Is(err, internalerrors.ErrManagedUser):
+		status = http.StatusForbidden
+		alert = "Din konto styres af et andet medlem af gruppen."
+	default:
+		log.Printf("Failed to change account of user=%s: %s\n", userID, err)
+		status = http.StatusInternalServerError
+		alert = "Der skete en fejl. Prøv igen om lidt."
+	}
+	c.renderAccount(ctx, status, "", alert)
+}
+
+func (c *AuthController) renderAccount(ctx *gin.Context, status int, success string, alert string) {
+	userID := ctx.GetString(KeyUserID)
+	account, err := c.authService.GetAccount(ctx.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, internalerrors.ErrManagedUser) {
+			ctx.Redirect(http.StatusFound, "/profile")
+			return
+		}
+		log.Printf("Failed to get account of user=%s: %s\n", userID, err)
+		HTML(ctx, http.StatusInternalServerError, "pages/index", gin.H{
+			"title": "Taskeroo",
+			"alert": "Der skete en fejl. Prøv igen om lidt.",
+		})
+		return
+	}
+
+	HTML(ctx, status, "pages/account", gin.H{
+		"title":   "Din konto",
+		"account": account,
+		"success": success,
+		"error":   alert,
+	})
+}
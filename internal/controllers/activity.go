@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"time"
+)
+
+var activityTexts = map[string]string{
+	app.ActivityTaskCreated:          "oprettede opgaven",
+	app.ActivityTaskEdited:           "redigerede opgaven",
+	app.ActivityTaskDeleted:          "slettede opgaven",
+	app.ActivityTaskRestored:         "gendannede opgaven",
+	app.ActivityTaskPurged:           "slettede permanent opgaven",
+	app.ActivityTaskCompleted:        "fuldførte opgaven",
+	app.ActivityTaskPostponed:        "udskød opgaven",
+	app.ActivityTaskTriggered:        "aktiverede opgaven",
+	app.ActivityTaskClaimed:          "tog opgaven",
+	app.ActivityTaskUnclaimed:        "frigav opgaven",
+	app.ActivityTaskUsed:             "registrerede brug af opgaven",
+	app.ActivityGroupCreated:         "oprettede gruppen",
+	app.ActivityGroupDeleted:         "slettede gruppen",
+	app.ActivitySettingsChanged:      "ændrede indstillingerne for gruppen",
+	app.ActivityOwnershipTransferred: "overdrog ejerskabet til",
+	app.ActivityMemberJoined:         "blev medlem af gruppen",
+	app.ActivityMemberApproved:       "godkendte anmodningen om medlemskab fra",
+	app.ActivityMemberLeft:           "forlod gruppen",
+	app.ActivityMemberRemoved:        "fjernede",
+	app.ActivityMemberRoleChanged:    "ændrede rollen for",
+	app.ActivityChildAdded:           "tilføjede barnet",
+	app.ActivityChildEdited:          "redigerede barnet",
+	app.ActivityCompletionRequested:  "udførte, med forbehold for godkendelse, opgaven",
+	app.ActivityCompletionRejected:   "afviste udførslen af opgaven",
+}
+
+// selfActivities are the actions where the subject is the actor, so the subject isn't shown.
+var selfActivities = map[string]bool{
+	app.ActivityMemberJoined: true,
+	app.ActivityMemberLeft:   true,
+}
+
+var changeFieldNames = map[string]string{
+	"title":            "Titel",
+	"description":      "Beskrivelse",
+	"category":         "Kategori",
+	"assignee":         "Ansvarlig",
+	"rotatingAssignee": "Skiftende ansvarlig",
+	"interval":         "Interval",
+	"nextDueDate":      "Næste forfaldsdato",
+	"webhook":          "Webhook",
+	"tasks":            "Opgaver",
+	"role":             "Rolle",
+	"timezone":         "Tidszone",
+	"language":         "Sprog",
+	"reminderTime":     "Påmindelsestidspunkt",
+	"name":             "Navn",
+	"pin":              "PIN",
+	"requiresApproval": "Kræver godkendelse",
+	"approvedBy":       "Godkendt af",
+	"completedBy":      "Udført af",
+	"usageCount":       "Antal gange brugt",
+}
+
+type ActivityController struct {
+	activityLogic *app.ActivityLogic
+}
+
+func NewActivityController(protectedRouter gin.IRouter, activityLogic *app.ActivityLogic) *ActivityController {
+	handler := &ActivityController{activityLogic: activityLogic}
+
+	protectedRouter.GET("/group/activity", handler.GetActivity())
+	protectedRouter.GET("/group/activity.json", handler.GetActivityJSON())
+
+	return handler
+}
+
+func (c *ActivityController) GetActivity() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		before, ok := activityBefore(ctx)
+		if !ok {
+			HTML(ctx, http.StatusBadRequest, "pages/activity", gin.H{
+				"title": "Aktivitet",
+				"error": "Ugyldigt tidspunkt.",
+			})
+			return
+		}
+
+		activities, err := c.activityLogic.GetForGroup(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), before, ctx.Query("beforeId"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			message := "Der skete en fejl. Prøv igen om lidt."
+			if errors.Is(err, internalerrors.ErrUserNotMemberOfGroup) || errors.Is(err, internalerrors.ErrUserNotInGroup) {
+				status = http.StatusForbidden
+				message = "Du er ikke medlem af gruppen."
+			} else {
+				log.Printf("Failed to get activities for user=%s: %s\n", userID, err)
+			}
+			HTML(ctx, status, "pages/activity", gin.H{
+				"title": "Aktivitet",
+				"error": message,
+			})
+			return
+		}
+
+		var next, nextID string
+		if len(activities) > 0 {
+			next = activities[len(activities)-1].CreatedAt.Format(time.RFC3339Nano)
+			nextID = activities[len(activities)-1].ID
+		}
+
+		HTML(ctx, http.StatusOK, "pages/activity", gin.H{
+			"title":       "Aktivitet",
+			"activities":  activities,
+			"actionTexts": activityTexts,
+			"fieldNames":  changeFieldNames,
+			"selfActions": selfActivities,
+			"next":        next,
+			"nextId":      nextID,
+		})
+	}
+}
+
+func (c *ActivityController) GetActivityJSON() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		before, ok := activityBefore(ctx)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "before must be an RFC 3339 timestamp"})
+			return
+		}
+
+		activities, err := c.activityLogic.GetForGroup(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), before, ctx.Query("beforeId"))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrUserNotMemberOfGroup) || errors.Is(err, internalerrors.ErrUserNotInGroup) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "not a member of the group"})
+				return
+			}
+			log.Printf("Failed to get activities for user=%s: %s\n", userID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get activities"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"activities": activities})
+	}
+}
+
+// activityBefore parses the optional "before" query parameter used for paging, which is given together with the
+// "beforeId" parameter. Without it, the newest activities are returned.
+func activityBefore(ctx *gin.Context) (time.Time, bool) {
+	value := ctx.Query("before")
+	if value == "" {
+		return time.Now(), true
+	}
+	before, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return before, true
+}
@@ -8,57 +8,79 @@ import (
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"net/url"
 )
 
 type AuthController struct {
 	authService   *app.AuthLogic
-	secureCookies bool
+	sessionCookie *SessionCookie
 }
 
 func NewAuthController(
 	router gin.IRouter,
 	protectedRouter gin.IRouter,
 	authService *app.AuthLogic,
-	secureCookies bool,
+	loginLimiter *app.LoginLimiter,
+	sessionCookie *SessionCookie,
 ) *AuthController {
-	handler := &AuthController{authService: authService, secureCookies: secureCookies}
+	handler := &AuthController{authService: authService, sessionCookie: sessionCookie}
 	router.GET("/login", handler.GetLogin())
-	router.POST("/login", handler.PostLogin())
+	router.POST("/login", LoginRateLimitMiddleware(loginLimiter, "pages/login"), handler.PostLogin())
+	router.GET("/login/pin", handler.GetLoginPIN())
+	router.POST("/login/pin", LoginRateLimitMiddleware(loginLimiter, "pages/login-pin"), handler.PostLoginPIN())
+	router.POST("/login/2fa", LoginRateLimitMiddleware(loginLimiter, "pages/login"), handler.PostLoginTwoFactor())
 
 	router.GET("/register", handler.GetRegister())
 	router.POST("/register", handler.PostRegister())
 
-	protectedRouter.GET("/logout", handler.GetLogout())
+	router.GET("/verify-email/:token", handler.GetVerifyEmail())
+	protectedRouter.POST("/profile/verify-email/resend", handler.PostResendVerification())
+
+	router.GET("/password/forgot", handler.GetForgotPassword())
+	router.POST("/password/forgot", handler.PostForgotPassword())
+	router.GET("/password/reset/:token", handler.GetResetPassword())
+	router.POST("/password/reset/:token", handler.PostResetPassword())
+
+	protectedRouter.POST("/logout", handler.PostLogout())
 
 	protectedRouter.GET("/profile", handler.GetProfile())
 
-	protectedRouter.POST("/profile/leave-group", handler.PostLeaveGroup())
+	protectedRouter.GET("/profile/account", handler.GetAccount())
+	protectedRouter.POST("/profile/account/name", handler.PostChangeName())
+	protectedRouter.POST("/profile/account/email", handler.PostChangeEmail())
+	protectedRouter.POST("/profile/account/password", handler.PostChangePassword())
+
+	protectedRouter.GET("/profile/2fa", handler.GetTwoFactor())
+	protectedRouter.GET("/profile/2fa/qr.png", handler.GetTwoFactorQRCode())
+	protectedRouter.POST("/profile/2fa/enable", handler.PostEnableTwoFactor())
+	protectedRouter.POST("/profile/2fa/recovery-codes", handler.PostRegenerateRecoveryCodes())
+	protectedRouter.POST("/profile/2fa/disable", handler.PostDisableTwoFactor())
+
+	protectedRouter.GET("/profile/sessions", handler.GetSessions())
+	protectedRouter.POST("/profile/sessions/:id/revoke", handler.PostRevokeSession())
+	protectedRouter.POST("/profile/sessions/revoke-all", handler.PostRevokeAllSessions())
 
 	return handler
 }
 
-func AuthMiddleware(authService *app.AuthLogic) gin.HandlerFunc {
+// AuthMiddleware authenticates the user by the session cookie. The user is looked up from the session, so the client
+// only holds the session token. The cookie is set again when the session is extended, or when it must be signed with
+// the current key.
+func AuthMiddleware(authService *app.AuthLogic, groupLogic *app.GroupLogic, sessionCookie *SessionCookie) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		userID, err := ctx.Cookie(CookieKeyUserID)
-		if err != nil || userID == "" {
-			clearCookies(ctx)
-			ctx.Redirect(http.StatusFound, "/login")
-			ctx.Abort()
-			return
-		}
-		session, err := ctx.Cookie(CookieKeySession)
-		if err != nil || session == "" {
+		token, stale, ok := sessionCookie.Get(ctx)
+		if !ok {
 			clearCookies(ctx)
-			ctx.Redirect(http.StatusFound, "/login")
+			redirectToLogin(ctx)
 			ctx.Abort()
 			return
 		}
 
-		authenticated, err := authService.IsAuthenticated(ctx.Request.Context(), userID, session)
+		userSession, err := authService.Authenticate(ctx.Request.Context(), token)
 		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				clearCookies(ctx)
-				ctx.Redirect(http.StatusFound, "/login")
+				redirectToLogin(ctx)
 				ctx.Abort()
 				return
 			}
@@ -68,23 +90,82 @@ func AuthMiddleware(authService *app.AuthLogic) gin.HandlerFunc {
 			return
 		}
 
-		if !authenticated {
-			clearCookies(ctx)
-			ctx.Redirect(http.StatusFound, "/login")
+		if stale {
+			clearLegacyCookies(ctx)
+		}
+		if stale || userSession.Renewed {
+			sessionCookie.Set(ctx, token)
+		}
+
+		userID := userSession.UserID
+		groups, err := groupLogic.GetGroupsForUser(ctx.Request.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get groups for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
 			ctx.Abort()
 			return
 		}
 
-		ctx.Set("userID", userID)
-		ctx.Set("session", session)
+		ctx.Set(KeyUserID, userID)
+		ctx.Set(KeySession, userSession.HashedToken)
+		if userSession.GroupID != nil {
+			ctx.Set(KeyGroupID, *userSession.GroupID)
+		}
+		ctx.Set(KeyGroups, groups)
+		ctx.Next()
+	}
+}
+
+// KioskMiddleware authenticates a shared device in kiosk mode by its token. Devices are bound to a group and not a
+// user, so the routes behind this middleware must only allow what a device may do.
+func KioskMiddleware(kioskLogic *app.KioskLogic) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, err := ctx.Cookie(CookieKeyDeviceToken)
+		if err != nil || token == "" {
+			HTML(ctx, http.StatusUnauthorized, "pages/kiosk", gin.H{
+				"title": "Taskeroo",
+				"error": "Enheden er ikke sat op til at vise opgaver.",
+			})
+			ctx.Abort()
+			return
+		}
+
+		device, err := kioskLogic.Authenticate(ctx.Request.Context(), token)
+		if err != nil {
+			status := http.StatusInternalServerError
+			alert := "Der skete en fejl. Prøv igen om lidt."
+			if errors.Is(err, internalerrors.ErrInvalidDeviceToken) {
+				ctx.SetCookie(CookieKeyDeviceToken, "", -1, "/kiosk", "", true, true)
+				status = http.StatusUnauthorized
+				alert = "Enheden er ikke længere sat op til at vise opgaver."
+			} else {
+				log.Printf("Failed to authenticate device: %s\n", err)
+			}
+			HTML(ctx, status, "pages/kiosk", gin.H{
+				"title": "Taskeroo",
+				"error": alert,
+			})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set(KeyDevice, device)
 		ctx.Next()
 	}
 }
 
 func (c *AuthController) GetLogin() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		success := ""
+		if ctx.Query("registered") == "true" {
+			success = "Din bruger er oprettet. Vi har sendt dig en email med et link, så du kan bekræfte din email."
+		} else if ctx.Query("deleted") == "true" {
+			success = "Din konto er slettet."
+		}
 		HTML(ctx, http.StatusOK, "pages/login", gin.H{
-			"title": "Login",
+			"title":   "Login",
+			"next":    safeRedirect(ctx.Query("next")),
+			"success": success,
 		})
 	}
 }
@@ -93,21 +174,76 @@ func (c *AuthController) PostLogin() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		email := ctx.PostForm("email")
 		password := ctx.PostForm("password")
+		next := safeRedirect(ctx.PostForm("next"))
 
-		userSession, err := c.authService.Login(ctx.Request.Context(), email, password)
+		userSession, err := c.authService.Login(ctx.Request.Context(), email, password, clientInfo(ctx))
 		if err != nil {
+			if errors.Is(err, internalerrors.ErrTooManyAttempts) {
+				renderTooManyAttempts(ctx, "pages/login", err, gin.H{"title": "Login", "next": next})
+				return
+			}
 			if errors.Is(err, internalerrors.ErrInvalidEmailOrPassword) {
 				HTML(ctx, http.StatusOK, "pages/login", gin.H{
 					"title": "Login",
 					"error": "Email eller password ugyldig",
+					"next":  next,
+				})
+				return
+			}
+			var twoFactorRequired internalerrors.TwoFactorRequiredError
+			if errors.As(err, &twoFactorRequired) {
+				HTML(ctx, http.StatusOK, "pages/login-2fa", gin.H{
+					"title": "Login",
+					"token": twoFactorRequired.Token,
+					"next":  next,
+				})
+				return
+			}
+			HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
+			return
+		}
+
+		c.sessionCookie.Set(ctx, userSession.Token)
+		if next != "" {
+			ctx.Redirect(http.StatusFound, next)
+			return
+		}
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *AuthController) GetLoginPIN() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/login-pin", gin.H{
+			"title": "Login",
+			"code":  ctx.Query("code"),
+		})
+	}
+}
+
+func (c *AuthController) PostLoginPIN() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		code := ctx.PostForm("code")
+		userSession, err := c.authService.LoginWithPIN(ctx.Request.Context(), code, ctx.PostForm("name"), ctx.PostForm("pin"), clientInfo(ctx))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrTooManyAttempts) {
+				renderTooManyAttempts(ctx, "pages/login-pin", err, gin.H{"title": "Login", "code": code})
+				return
+			}
+			if errors.Is(err, internalerrors.ErrInvalidCodeNameOrPIN) {
+				HTML(ctx, http.StatusOK, "pages/login-pin", gin.H{
+					"title": "Login",
+					"error": "Kode, navn eller PIN er forkert",
+					"code":  code,
 				})
 				return
 			}
+			log.Printf("Failed to log in with PIN: %s\n", err)
 			HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
+			return
 		}
 
-		ctx.SetCookie(CookieKeyUserID, userSession.UserID, int(Time31Days.Seconds()), "", "", c.secureCookies, true)
-		ctx.SetCookie(CookieKeySession, userSession.Session, int(Time31Days.Seconds()), "", "", c.secureCookies, true)
+		c.sessionCookie.Set(ctx, userSession.Token)
 		ctx.Redirect(http.StatusFound, "/")
 	}
 }
@@ -116,6 +252,7 @@ func (c *AuthController) GetRegister() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		HTML(ctx, http.StatusOK, "pages/register", gin.H{
 			"title": "Register",
+			"next":  safeRedirect(ctx.Query("next")),
 		})
 	}
 }
@@ -126,11 +263,13 @@ func (c *AuthController) PostRegister() gin.HandlerFunc {
 		name := ctx.PostForm("name")
 		password := ctx.PostForm("password")
 		repeatedPassword := ctx.PostForm("repeated-password")
+		next := safeRedirect(ctx.PostForm("next"))
 
 		if password != repeatedPassword {
 			HTML(ctx, http.StatusBadRequest, "pages/register", gin.H{
 				"title": "Login",
 				"error": "De to passwords matcher ikke",
+				"next":  next,
 			})
 			return
 		}
@@ -139,6 +278,7 @@ func (c *AuthController) PostRegister() gin.HandlerFunc {
 			HTML(ctx, http.StatusBadRequest, "pages/register", gin.H{
 				"title": "Login",
 				"error": "Email felt skal udfyldes",
+				"next":  next,
 			})
 			return
 		}
@@ -146,6 +286,7 @@ func (c *AuthController) PostRegister() gin.HandlerFunc {
 			HTML(ctx, http.StatusBadRequest, "pages/register", gin.H{
 				"title": "Login",
 				"error": "Password felt skal udfyldes",
+				"next":  next,
 			})
 			return
 		}
@@ -153,6 +294,7 @@ func (c *AuthController) PostRegister() gin.HandlerFunc {
 			HTML(ctx, http.StatusBadRequest, "pages/register", gin.H{
 				"title": "Login",
 				"error": "Navn felt skal udfyldes",
+				"next":  next,
 			})
 			return
 		}
@@ -163,21 +305,245 @@ func (c *AuthController) PostRegister() gin.HandlerFunc {
 			return
 		}
 
+		if next != "" {
+			ctx.Redirect(http.StatusFound, "/login?registered=true&next="+url.QueryEscape(next))
+			return
+		}
+		ctx.Redirect(http.StatusFound, "/login?registered=true")
+	}
+}
+
+func (c *AuthController) GetVerifyEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := c.authService.VerifyEmail(ctx.Request.Context(), ctx.Param("token"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			alert := "Der skete en fejl. Prøv igen om lidt."
+			if errors.Is(err, internalerrors.ErrInvalidVerification) {
+				status = http.StatusBadRequest
+				alert = "Linket er ugyldigt eller udløbet. Log ind og bed om et nyt link på din profil."
+			} else {
+				log.Printf("Failed to verify email: %s\n", err)
+			}
+			HTML(ctx, status, "pages/verify-email", gin.H{
+				"title": "Bekræft email",
+				"error": alert,
+			})
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/verify-email", gin.H{
+			"title":   "Bekræft email",
+			"success": "Tak! Din email er bekræftet.",
+		})
+	}
+}
+
+func (c *AuthController) PostResendVerification() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.ResendVerification(ctx.Request.Context(), userID)
+		if err != nil {
+			var alert string
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, internalerrors.ErrEmailAlreadyVerified):
+				alert = "Din email er allerede bekræftet."
+			case errors.Is(err, internalerrors.ErrVerificationRateLimit):
+				status = http.StatusTooManyRequests
+				alert = "Vi har lige sendt en email. Vent et par minutter, før du beder om en ny."
+			default:
+				log.Printf("Failed to resend verification email for user=%s: %s\n", userID, err)
+				status = http.StatusInternalServerError
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			HTML(ctx, status, "pages/verify-email", gin.H{
+				"title": "Bekræft email",
+				"error": alert,
+			})
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/verify-email", gin.H{
+			"title":   "Bekræft email",
+			"success": "Vi har sendt en ny email med et link, så du kan bekræfte din email.",
+		})
+	}
+}
+
+func (c *AuthController) GetForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/forgot-password", gin.H{
+			"title": "Glemt password",
+		})
+	}
+}
+
+func (c *AuthController) PostForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		email := ctx.PostForm("email")
+		if email == "" {
+			HTML(ctx, http.StatusBadRequest, "pages/forgot-password", gin.H{
+				"title": "Glemt password",
+				"error": "Email felt skal udfyldes",
+			})
+			return
+		}
+
+		c.authService.RequestPasswordReset(email)
+		HTML(ctx, http.StatusOK, "pages/forgot-password", gin.H{
+			"title": "Glemt password",
+			"sent":  true,
+		})
+	}
+}
+
+func (c *AuthController) GetResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.Param("token")
+		err := c.authService.CheckPasswordReset(ctx.Request.Context(), token)
+		if err != nil {
+			c.renderResetPasswordError(ctx, err)
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/reset-password", gin.H{
+			"title": "Nyt password",
+			"token": token,
+		})
+	}
+}
+
+func (c *AuthController) PostResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.Param("token")
+		password := ctx.PostForm("password")
+		if password != ctx.PostForm("repeated-password") {
+			HTML(ctx, http.StatusBadRequest, "pages/reset-password", gin.H{
+				"title": "Nyt password",
+				"token": token,
+				"error": "De to passwords matcher ikke",
+			})
+			return
+		}
+
+		err := c.authService.ResetPassword(ctx.Request.Context(), token, password)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrEmptyPassword) {
+				HTML(ctx, http.StatusBadRequest, "pages/reset-password", gin.H{
+					"title": "Nyt password",
+					"token": token,
+					"error": "Password felt skal udfyldes",
+				})
+				return
+			}
+			c.renderResetPasswordError(ctx, err)
+			return
+		}
+
+		clearCookies(ctx)
+		HTML(ctx, http.StatusOK, "pages/login", gin.H{
+			"title":   "Login",
+			"success": "Dit password er ændret. Du kan nu logge ind med det nye password.",
+		})
+	}
+}
+
+func (c *AuthController) renderResetPasswordError(ctx *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	alert := "Der skete en fejl. Prøv igen om lidt."
+	if errors.Is(err, internalerrors.ErrInvalidResetToken) {
+		status = http.StatusNotFound
+		alert = "Linket er ugyldigt, allerede brugt eller udløbet. Bed om et nyt link."
+	} else {
+		log.Printf("Failed to reset password: %s\n", err)
+	}
+	HTML(ctx, status, "pages/forgot-password", gin.H{
+		"title": "Glemt password",
+		"error": alert,
+	})
+}
+
+// PostLogout logs the user out. It only accepts POST with a CSRF token, so other sites can't log the user out with a
+// link or an image.
+func (c *AuthController) PostLogout() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.Logout(ctx.Request.Context(), ctx.GetString(KeySession))
+		if err != nil {
+			log.Printf("Failed to delete session for user=%s: %s\n", userID, err)
+		}
+
+		clearCookies(ctx)
 		ctx.Redirect(http.StatusFound, "/login")
 	}
 }
 
-func (c *AuthController) GetLogout() gin.HandlerFunc {
+func (c *AuthController) GetSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		sessions, err := c.authService.GetSessions(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), ctx.GetString(KeySession))
+		if err != nil {
+			log.Printf("Failed to get sessions for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/sessions", gin.H{
+				"title": "Aktive logins",
+				"error": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/sessions", gin.H{
+			"title":    "Aktive logins",
+			"sessions": sessions,
+		})
+	}
+}
+
+func (c *AuthController) PostRevokeSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.RevokeSession(ctx.Request.Context(), userID, ctx.Param("id"))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrSessionNotFound) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to revoke session for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile/sessions")
+	}
+}
+
+func (c *AuthController) PostRevokeAllSessions() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.LogoutEverywhere(ctx.Request.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to revoke all sessions for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
 		clearCookies(ctx)
 		ctx.Redirect(http.StatusFound, "/login")
 	}
 }
 
+// clientInfo describes the device making the request, to be stored with a new session.
+func clientInfo(ctx *gin.Context) app.ClientInfo {
+	return app.ClientInfo{
+		UserAgent: ctx.Request.UserAgent(),
+		IPAddress: ctx.ClientIP(),
+	}
+}
+
 func (c *AuthController) GetProfile() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userID := ctx.GetString(KeyUserID)
-		profile, err := c.authService.GetProfile(ctx, userID)
+		profile, err := c.authService.GetProfile(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
 		if err != nil {
 			log.Printf("Failed to get profile for user=%s: %s\n", userID, err)
 			HTML(ctx, http.StatusInternalServerError, "pages/index", gin.H{
@@ -187,24 +553,28 @@ func (c *AuthController) GetProfile() gin.HandlerFunc {
 			return
 		}
 		HTML(ctx, http.StatusOK, "pages/profile", gin.H{
-			"title":   "Profil",
-			"profile": profile,
+			"title":           "Profil",
+			"profile":         profile,
+			"permissions":     app.Permissions,
+			"permissionNames": permissionNames,
+			"roles":           app.AssignableRoles,
+			"roleNames":       roleNames,
 		})
 	}
 }
 
-func (c *AuthController) PostLeaveGroup() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		userID := ctx.GetString(KeyUserID)
-		if err := c.authService.LeaveGroup(ctx.Request.Context(), userID); err != nil {
-			log.Printf("Failed to leave group for user=%s: %s\n", userID, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/profile", gin.H{
-				"title": "Profil",
-				"alert": "Kunne ikke forlade gruppen. Prøv igen senere. Hvis problemet fortsætter, så kontakt support.",
-			})
-			return
-		}
+var roleNames = map[string]string{
+	app.RoleOwner:  "Ejer",
+	app.RoleAdmin:  "Administrator",
+	app.RoleMember: "Medlem",
+	app.RoleChild:  "Barn/gæst",
+}
 
-		ctx.Redirect(http.StatusFound, "/profile")
-	}
+var permissionNames = map[string]string{
+	app.PermissionCreateTasks:        "Oprette opgaver",
+	app.PermissionEditTasks:          "Redigere opgaver",
+	app.PermissionDeleteTasks:        "Slette opgaver",
+	app.PermissionManageMembers:      "Administrere medlemmer",
+	app.PermissionApproveCompletions: "Godkende udførte opgaver",
+	app.PermissionChangeSettings:     "Ændre indstillinger",
 }
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ChildController struct {
+	childLogic *app.ChildLogic
+}
+
+func NewChildController(protectedRouter gin.IRouter, childLogic *app.ChildLogic) *ChildController {
+	handler := &ChildController{childLogic: childLogic}
+
+	protectedRouter.GET("/group/children/new", handler.GetCreateChild())
+	protectedRouter.POST("/group/children/new", handler.PostCreateChild())
+	protectedRouter.GET("/group/children/:id", handler.GetEditChild())
+	protectedRouter.POST("/group/children/:id", handler.PostEditChild())
+
+	return handler
+}
+
+func (c *ChildController) GetCreateChild() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/child", gin.H{
+			"title": "Tilføj barn",
+			"child": app.Child{},
+		})
+	}
+}
+
+func (c *ChildController) PostCreateChild() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		newChild := childFromForm(ctx)
+		child := app.Child{Name: newChild.Name, RequiresApproval: newChild.RequiresApproval}
+		if newChild.Name == "" {
+			renderChild(ctx, http.StatusBadRequest, child, "Navn skal udfyldes")
+			return
+		}
+
+		_, err := c.childLogic.Create(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), newChild)
+		if err != nil {
+			status, alert := childError(userID, err)
+			renderChild(ctx, status, child, alert)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *ChildController) GetEditChild() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		child, err := c.childLogic.Get(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), ctx.Param("id"))
+		if err != nil {
+			status, alert := childError(userID, err)
+			renderChild(ctx, status, app.Child{}, alert)
+			return
+		}
+
+		renderChild(ctx, http.StatusOK, child, "")
+	}
+}
+
+func (c *ChildController) PostEditChild() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		childID := ctx.Param("id")
+		update := childFromForm(ctx)
+		child := app.Child{ID: childID, Name: update.Name, RequiresApproval: update.RequiresApproval}
+		if update.Name == "" {
+			renderChild(ctx, http.StatusBadRequest, child, "Navn skal udfyldes")
+			return
+		}
+
+		err := c.childLogic.Update(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), childID, update, ctx.PostForm("removePIN") == "on")
+		if err != nil {
+			status, alert := childError(userID, err)
+			renderChild(ctx, status, child, alert)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func childFromForm(ctx *gin.Context) app.NewChild {
+	return app.NewChild{
+		Name:             strings.TrimSpace(ctx.PostForm("name")),
+		PIN:              ctx.PostForm("pin"),
+		RequiresApproval: ctx.PostForm("requiresApproval") == "on",
+	}
+}
+
+func childError(userID string, err error) (int, string) {
+	switch {
+	case errors.Is(err, internalerrors.ErrInvalidPIN):
+		return http.StatusBadRequest, "PIN skal være 4 til 8 cifre."
+	case errors.Is(err, internalerrors.ErrMissingPermission):
+		return http.StatusForbidden, "Du har ikke rettigheder til at administrere medlemmer."
+	case errors.Is(err, internalerrors.ErrNotManagedUser), errors.Is(err, internalerrors.ErrUserNotMemberOfGroup):
+		return http.StatusNotFound, "Barnet findes ikke i gruppen."
+	default:
+		log.Printf("Failed to manage child for user=%s: %s\n", userID, err)
+		return http.StatusInternalServerError, "Der skete en fejl. Prøv igen om lidt."
+	}
+}
+
+func renderChild(ctx *gin.Context, status int, child app.Child, alert string) {
+	title := "Tilføj barn"
+	if child.ID != "" {
+		title = "Rediger barn"
+	}
+	obj := gin.H{
+		"title": title,
+		"child": child,
+	}
+	if alert != "" {
+		obj["error"] = alert
+	}
+	HTML(ctx, status, "pages/child", obj)
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// csrfFormField is the form field the CSRF token is sent in.
+	csrfFormField = "csrf_token"
+	// csrfHeader is the header the CSRF token is sent in by scripts.
+	csrfHeader = "X-CSRF-Token"
+)
+
+// CSRFMiddleware protects forms against cross-site request forgery. The token is derived from the session cookie, so
+// it is different for every session, or from a random cookie for visitors who are not logged in. Templates get the
+// token as csrfToken through HTML, and requests which change something must send it in the csrf_token form field or
+// the X-CSRF-Token header. Requests to the exempt paths, like webhooks which are not authenticated by cookies, are
+// not checked. All cookies are set with SameSite=Lax.
+func CSRFMiddleware(secretKey []byte, secureCookies bool, exemptPaths ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.SetSameSite(http.SameSiteLaxMode)
+
+		for _, path := range exemptPaths {
+			if strings.HasPrefix(ctx.Request.URL.Path, path) {
+				ctx.Next()
+				return
+			}
+		}
+
+		seed := sessionSeed(ctx)
+		var err error
+		if seed == "" {
+			seed, err = ctx.Cookie(CookieKeyCSRF)
+		}
+		if err != nil || seed == "" {
+			seed, err = util.RandomToken(32)
+			if err != nil {
+				log.Printf("Failed to generate CSRF cookie: %s\n", err)
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			ctx.SetCookie(CookieKeyCSRF, seed, 0, "/", "", secureCookies, true)
+		}
+
+		token := csrfToken(secretKey, seed)
+		ctx.Set(KeyCSRFToken, token)
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+
+		given := ctx.GetHeader(csrfHeader)
+		if given == "" {
+			given = ctx.PostForm(csrfFormField)
+		}
+		if !hmac.Equal([]byte(given), []byte(token)) {
+			HTML(ctx, http.StatusForbidden, "pages/index", gin.H{
+				"title": "Taskeroo",
+				"alert": "Siden er udløbet. Gå tilbage, genindlæs siden og prøv igen.",
+			})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// csrfToken returns the CSRF token for the seed, signed with the secret key, so it can't be guessed from the seed.
+func csrfToken(secretKey []byte, seed string) string {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte("csrf|" + seed))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,30 +1,54 @@
 package controllers
 
 import (
+	"context"
 	"errors"
+	"github.com/dentych/taskeroo/internal/app"
 	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 )
 
 type GroupController struct {
-	groupRepo *database.GroupRepo
-	userRepo  *database.UserRepo
+	groupLogic  *app.GroupLogic
+	authService *app.AuthLogic
+	userRepo    *database.UserRepo
 }
 
-func NewGroupController(protectedRouter gin.IRouter, groupRepo *database.GroupRepo, userRepo *database.UserRepo) *GroupController {
-	handler := &GroupController{groupRepo: groupRepo, userRepo: userRepo}
+func NewGroupController(
+	protectedRouter gin.IRouter,
+	groupLogic *app.GroupLogic,
+	authService *app.AuthLogic,
+	userRepo *database.UserRepo,
+) *GroupController {
+	handler := &GroupController{groupLogic: groupLogic, authService: authService, userRepo: userRepo}
 
 	protectedRouter.GET("/group/create", handler.GetCreateGroup())
 
 	protectedRouter.POST("/group/create", handler.PostCreateGroup())
 
-	protectedRouter.GET("/group/members/add", handler.GetAddGroupMember())
-	protectedRouter.POST("/group/members/add", handler.PostAddGroupMember())
+	protectedRouter.POST("/group/switch", handler.PostSwitchGroup())
+
+	protectedRouter.POST("/group/members/:id/role", handler.PostSetRole())
+
+	protectedRouter.GET("/group/members/:id/remove", handler.GetRemoveMember())
+	protectedRouter.POST("/group/members/:id/remove", handler.PostRemoveMember())
+	protectedRouter.POST("/profile/leave-group", handler.PostLeaveGroup())
+
+	protectedRouter.GET("/group/settings", handler.GetSettings())
+	protectedRouter.POST("/group/settings", handler.PostSettings())
+
+	protectedRouter.POST("/group/transfer", handler.PostTransferOwnership())
+	protectedRouter.POST("/group/delete", handler.PostDeleteGroup())
+
+	protectedRouter.GET("/group/join", handler.GetJoinGroup())
+	protectedRouter.POST("/group/join", handler.PostJoinGroup())
+	protectedRouter.POST("/group/join-requests/:id/approve", handler.PostApproveJoinRequest())
+	protectedRouter.POST("/group/join-requests/:id/reject", handler.PostRejectJoinRequest())
+	protectedRouter.POST("/group/code/regenerate", handler.PostRegenerateCode())
 
 	return handler
 }
@@ -54,17 +78,35 @@ func (c *GroupController) PostCreateGroup() gin.HandlerFunc {
 			return
 		}
 
-		groupID := uuid.NewString()
-		err := c.groupRepo.Create(ctx.Request.Context(), database.Group{ID: groupID, Name: name, OwnerUserID: userID, CreatedAt: time.Now()})
+		groupID, err := c.groupLogic.Create(ctx.Request.Context(), userID, name)
 		if err != nil {
 			log.Printf("Failed to create team: %s\n", err)
 			ctx.Status(http.StatusInternalServerError)
 			return
 		}
 
-		err = c.userRepo.SetGroup(ctx.Request.Context(), userID, &groupID)
+		err = c.authService.SwitchGroup(ctx.Request.Context(), userID, ctx.GetString(KeySession), groupID)
 		if err != nil {
-			log.Printf("Failed to create team: %s\n", err)
+			log.Printf("Failed to switch to new group=%s for user=%s: %s\n", groupID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *GroupController) PostSwitchGroup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		groupID := ctx.PostForm("groupID")
+		err := c.authService.SwitchGroup(ctx.Request.Context(), userID, ctx.GetString(KeySession), groupID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrUserNotMemberOfGroup) || errors.Is(err, internalerrors.ErrUserNotInGroup) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to switch to group=%s for user=%s: %s\n", groupID, userID, err)
 			ctx.Status(http.StatusInternalServerError)
 			return
 		}
@@ -73,71 +115,335 @@ func (c *GroupController) PostCreateGroup() gin.HandlerFunc {
 	}
 }
 
-func (c *GroupController) GetAddGroupMember() gin.HandlerFunc {
+func (c *GroupController) PostSetRole() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		memberID := ctx.Param("id")
+		role := ctx.PostForm("role")
+		err := c.groupLogic.SetRole(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), memberID, role)
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrUserNotOwner), errors.Is(err, internalerrors.ErrManagedUser):
+				ctx.Status(http.StatusForbidden)
+			case errors.Is(err, internalerrors.ErrInvalidRole), errors.Is(err, internalerrors.ErrUserNotMemberOfGroup):
+				ctx.Status(http.StatusBadRequest)
+			default:
+				log.Printf("Failed to set role of member=%s for user=%s: %s\n", memberID, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+// GetRemoveMember shows how the tasks of a member should be handled, before the member is removed. When the member
+// is the user, the user leaves the group.
+func (c *GroupController) GetRemoveMember() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		HTML(ctx, http.StatusOK, "pages/add-member", gin.H{
-			"title": "Tilføj medlem",
+		c.renderRemoveMember(ctx, http.StatusOK, "")
+	}
+}
+
+func (c *GroupController) PostRemoveMember() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		memberID := ctx.Param("id")
+		handover := app.TaskHandover{
+			Action:   ctx.PostForm("action"),
+			Assignee: ctx.PostForm("assignee"),
+		}
+
+		err := c.groupLogic.RemoveMember(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), memberID, handover)
+		if err != nil {
+			var alert string
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, internalerrors.ErrOwnerCannotLeave):
+				alert = "Du er ejer af gruppen. Overdrag ejerskabet til et andet medlem, før du forlader gruppen."
+			case errors.Is(err, internalerrors.ErrInvalidTaskHandover):
+				alert = "Vælg hvad der skal ske med opgaverne."
+			case errors.Is(err, internalerrors.ErrMissingPermission), errors.Is(err, internalerrors.ErrUserNotOwner):
+				status = http.StatusForbidden
+				alert = "Du har ikke rettigheder til at fjerne dette medlem."
+			default:
+				log.Printf("Failed to remove member=%s for user=%s: %s\n", memberID, userID, err)
+				status = http.StatusInternalServerError
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			c.renderRemoveMember(ctx, status, alert)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+// PostLeaveGroup makes the user leave the current group. Tasks assigned to the user are unassigned, unless another
+// handling is given.
+func (c *GroupController) PostLeaveGroup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		handover := app.TaskHandover{
+			Action:   ctx.DefaultPostForm("action", app.TaskHandoverUnassign),
+			Assignee: ctx.PostForm("assignee"),
+		}
+
+		err := c.groupLogic.Leave(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), handover)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrOwnerCannotLeave) || errors.Is(err, internalerrors.ErrInvalidTaskHandover) {
+				ctx.Status(http.StatusBadRequest)
+				return
+			}
+			log.Printf("Failed to leave group for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *GroupController) PostTransferOwnership() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		newOwnerID := ctx.PostForm("owner")
+		err := c.groupLogic.TransferOwnership(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), newOwnerID)
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrUserNotOwner), errors.Is(err, internalerrors.ErrManagedUser):
+				ctx.Status(http.StatusForbidden)
+			case errors.Is(err, internalerrors.ErrUserNotMemberOfGroup):
+				ctx.Status(http.StatusBadRequest)
+			default:
+				log.Printf("Failed to transfer ownership to user=%s for user=%s: %s\n", newOwnerID, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *GroupController) PostDeleteGroup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		groupID := ctx.GetString(KeyGroupID)
+		err := c.groupLogic.Delete(ctx.Request.Context(), userID, groupID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrUserNotOwner) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to delete group=%s for user=%s: %s\n", groupID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *GroupController) GetJoinGroup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/join-group", gin.H{
+			"title": "Bliv medlem af en gruppe",
+			"code":  ctx.Query("code"),
 		})
 	}
 }
 
-func (c *GroupController) PostAddGroupMember() gin.HandlerFunc {
+func (c *GroupController) PostJoinGroup() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		email := ctx.PostForm("email")
-		if email == "" {
-			HTML(ctx, http.StatusOK, "pages/add-member", gin.H{
-				"title": "Tilføj medlem",
-				"error": "Email adresse skal udfyldes",
+		userID := ctx.GetString(KeyUserID)
+		code := ctx.PostForm("code")
+		err := c.groupLogic.RequestToJoin(ctx.Request.Context(), userID, code)
+		if err != nil {
+			var alert string
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, internalerrors.ErrInvalidGroupCode):
+				alert = "Der findes ingen gruppe med den kode."
+			case errors.Is(err, internalerrors.ErrUserAlreadyInGroup):
+				alert = "Du er allerede medlem af gruppen."
+			case errors.Is(err, internalerrors.ErrJoinRequestExists):
+				alert = "Du har allerede anmodet om at blive medlem af gruppen."
+			case errors.Is(err, internalerrors.ErrEmailNotVerified):
+				alert = "Du skal bekræfte din email, før du kan blive medlem af en gruppe."
+			default:
+				log.Printf("Failed to request to join group for user=%s: %s\n", userID, err)
+				status = http.StatusInternalServerError
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			HTML(ctx, status, "pages/join-group", gin.H{
+				"title": "Bliv medlem af en gruppe",
+				"code":  code,
+				"error": alert,
 			})
 			return
 		}
 
+		HTML(ctx, http.StatusOK, "pages/join-group", gin.H{
+			"title":     "Bliv medlem af en gruppe",
+			"requested": true,
+		})
+	}
+}
+
+func (c *GroupController) PostApproveJoinRequest() gin.HandlerFunc {
+	return c.decideJoinRequest(c.groupLogic.ApproveJoinRequest)
+}
+
+func (c *GroupController) PostRejectJoinRequest() gin.HandlerFunc {
+	return c.decideJoinRequest(c.groupLogic.RejectJoinRequest)
+}
+
+func (c *GroupController) decideJoinRequest(decide func(ctx context.Context, userID string, groupID string, requestID string) error) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
 		userID := ctx.GetString(KeyUserID)
-		inviter, err := c.userRepo.Get(ctx, userID)
+		requestID := ctx.Param("id")
+		err := decide(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), requestID)
 		if err != nil {
-			log.Printf("Failed to get inviting user=%s: %s\n", userID, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/add-member", gin.H{
-				"title": "Tilføj medlem",
-				"error": "Der var en fejl da brugeren skulle tilføjes. Prøv igen senere, eller kontakt support hvis problemet bliver ved.",
-			})
+			switch {
+			case errors.Is(err, internalerrors.ErrMissingPermission):
+				ctx.Status(http.StatusForbidden)
+			case errors.Is(err, internalerrors.ErrJoinRequestNotFound):
+				ctx.Status(http.StatusNotFound)
+			default:
+				log.Printf("Failed to decide join request=%s for user=%s: %s\n", requestID, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
 			return
 		}
 
-		invitee, err := c.userRepo.GetByEmail(ctx, email)
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *GroupController) PostRegenerateCode() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		_, err := c.groupLogic.RegenerateCode(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				HTML(ctx, http.StatusOK, "pages/add-member", gin.H{
-					"title": "Tilføj medlem",
-					"error": "Brugeren findes ikke.",
-				})
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
 				return
 			}
-			log.Printf("Failed to get invitee by email=%s: %s\n", email, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/add-member", gin.H{
-				"title": "Tilføj medlem",
-				"error": "Der var en fejl da brugeren skulle tilføjes. Prøv igen senere, eller kontakt support hvis problemet bliver ved.",
-			})
+			log.Printf("Failed to regenerate group code for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
 			return
 		}
 
-		if invitee.GroupID != nil {
-			HTML(ctx, http.StatusOK, "pages/add-member", gin.H{
-				"title": "Tilføj medlem",
-				"error": "Brugeren er allerede i en gruppe.",
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *GroupController) renderRemoveMember(ctx *gin.Context, status int, alert string) {
+	userID := ctx.GetString(KeyUserID)
+	memberID := ctx.Param("id")
+	users, err := c.userRepo.GetByGroup(ctx.Request.Context(), ctx.GetString(KeyGroupID))
+	if err != nil {
+		log.Printf("Failed to get members of group for user=%s: %s\n", userID, err)
+		status = http.StatusInternalServerError
+		alert = "Der skete en fejl. Prøv igen om lidt."
+	}
+
+	var memberName string
+	var others []Member
+	for _, user := range users {
+		if user.ID == memberID {
+			memberName = user.Name
+			continue
+		}
+		others = append(others, Member{ID: user.ID, Name: user.Name})
+	}
+	if err == nil && memberName == "" {
+		status = http.StatusNotFound
+		alert = "Medlemmet findes ikke i gruppen."
+	}
+
+	obj := gin.H{
+		"title":      "Fjern medlem",
+		"memberID":   memberID,
+		"memberName": memberName,
+		"self":       memberID == userID,
+		"members":    others,
+	}
+	if alert != "" {
+		obj["error"] = alert
+	}
+	HTML(ctx, status, "pages/remove-member", obj)
+}
+
+func (c *GroupController) GetSettings() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		settings, err := c.groupLogic.GetSettings(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+		if err != nil {
+			log.Printf("Failed to get group settings for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/group-settings", gin.H{
+				"title": "Gruppeindstillinger",
+				"error": "Kunne ikke hente gruppens indstillinger. Prøv igen om lidt.",
 			})
 			return
 		}
 
-		err = c.userRepo.SetGroup(ctx, invitee.ID, inviter.GroupID)
+		c.renderSettings(ctx, http.StatusOK, settings, "")
+	}
+}
+
+func (c *GroupController) PostSettings() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		settings := app.GroupSettings{
+			Timezone:     strings.TrimSpace(ctx.PostForm("timezone")),
+			Language:     ctx.PostForm("language"),
+			ReminderTime: ctx.PostForm("reminderTime"),
+		}
+
+		err := c.groupLogic.UpdateSettings(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), settings)
 		if err != nil {
-			log.Printf("Failed to get invitee by email=%s: %s\n", email, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/add-member", gin.H{
-				"title": "Tilføj medlem",
-				"error": "Der var en fejl da brugeren skulle tilføjes. Prøv igen senere, eller kontakt support hvis problemet bliver ved.",
-			})
+			var alert string
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, internalerrors.ErrInvalidTimezone):
+				alert = "Ukendt tidszone. Brug et navn som Europe/Copenhagen."
+			case errors.Is(err, internalerrors.ErrInvalidLanguage):
+				alert = "Ukendt sprog."
+			case errors.Is(err, internalerrors.ErrInvalidReminderTime):
+				alert = "Tidspunktet for påmindelser skal skrives som TT:MM."
+			case errors.Is(err, internalerrors.ErrMissingPermission):
+				status = http.StatusForbidden
+				alert = "Du har ikke rettigheder til at ændre gruppens indstillinger."
+			default:
+				log.Printf("Failed to update group settings for user=%s: %s\n", userID, err)
+				status = http.StatusInternalServerError
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			c.renderSettings(ctx, status, settings, alert)
 			return
 		}
 
-		ctx.Redirect(http.StatusFound, "/profile")
+		ctx.Redirect(http.StatusFound, "/group/settings")
+	}
+}
+
+func (c *GroupController) renderSettings(ctx *gin.Context, status int, settings app.GroupSettings, alert string) {
+	obj := gin.H{
+		"title":         "Gruppeindstillinger",
+		"settings":      settings,
+		"languages":     app.Languages,
+		"languageNames": languageNames,
+	}
+	if alert != "" {
+		obj["error"] = alert
 	}
+	HTML(ctx, status, "pages/group-settings", obj)
+}
+
+var languageNames = map[string]string{
+	app.LanguageDanish:  "Dansk",
+	app.LanguageEnglish: "English",
 }
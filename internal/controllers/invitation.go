@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type InvitationController struct {
+	invitationLogic *app.InvitationLogic
+	authService     *app.AuthLogic
+	baseURL         string
+}
+
+func NewInvitationController(
+	protectedRouter gin.IRouter,
+	invitationLogic *app.InvitationLogic,
+	authService *app.AuthLogic,
+	baseURL string,
+) *InvitationController {
+	handler := &InvitationController{invitationLogic: invitationLogic, authService: authService, baseURL: baseURL}
+
+	protectedRouter.GET("/group/members/add", handler.GetInvitations())
+	protectedRouter.POST("/group/members/add", handler.PostCreateInvitation())
+	protectedRouter.POST("/group/invitations/:id/revoke", handler.PostRevokeInvitation())
+	protectedRouter.GET("/group/invitations/:id/qr.png", handler.GetInvitationQRCode())
+
+	protectedRouter.GET("/invite/:token", handler.GetInvite())
+	protectedRouter.POST("/invite/:token/accept", handler.PostAcceptInvite())
+	protectedRouter.POST("/invite/:token/decline", handler.PostDeclineInvite())
+
+	return handler
+}
+
+func (c *InvitationController) GetInvitations() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.renderInvitations(ctx, http.StatusOK, "")
+	}
+}
+
+func (c *InvitationController) PostCreateInvitation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		email := ctx.PostForm("email")
+		maxUses, _ := strconv.Atoi(ctx.PostForm("maxUses"))
+		validDays, err := strconv.Atoi(ctx.PostForm("validDays"))
+		if err != nil || validDays < 1 || validDays > 30 {
+			c.renderInvitations(ctx, http.StatusBadRequest, "Invitationen skal være gyldig mellem 1 og 30 dage.")
+			return
+		}
+		if maxUses < 0 {
+			c.renderInvitations(ctx, http.StatusBadRequest, "Antal brug kan ikke være negativt.")
+			return
+		}
+
+		_, err = c.invitationLogic.Create(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), app.NewInvitation{
+			Email:    &email,
+			MaxUses:  maxUses,
+			ValidFor: time.Duration(validDays) * 24 * time.Hour,
+		})
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				c.renderInvitations(ctx, http.StatusForbidden, "Du har ikke rettigheder til at invitere nye medlemmer.")
+				return
+			}
+			log.Printf("Failed to create invitation for user=%s: %s\n", userID, err)
+			c.renderInvitations(ctx, http.StatusInternalServerError, "Der var en fejl da invitationen skulle oprettes. Prøv igen senere, eller kontakt support hvis problemet bliver ved.")
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/group/members/add")
+	}
+}
+
+func (c *InvitationController) PostRevokeInvitation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		invitationID := ctx.Param("id")
+		err := c.invitationLogic.Revoke(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), invitationID)
+		if err != nil {
+			log.Printf("Failed to revoke invitation=%s for user=%s: %s\n", invitationID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/group/members/add")
+	}
+}
+
+func (c *InvitationController) GetInvitationQRCode() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		invitationID := ctx.Param("id")
+		invitation, err := c.invitationLogic.Get(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), invitationID)
+		if err != nil {
+			log.Printf("Failed to get invitation=%s for user=%s: %s\n", invitationID, userID, err)
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+
+		png, err := qrcode.Encode(c.invitationURL(invitation.Token), qrcode.Medium, 256)
+		if err != nil {
+			log.Printf("Failed to create QR code for invitation=%s: %s\n", invitationID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Data(http.StatusOK, "image/png", png)
+	}
+}
+
+func (c *InvitationController) GetInvite() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.Param("token")
+		info, err := c.invitationLogic.GetInfo(ctx.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				HTML(ctx, http.StatusNotFound, "pages/invite", gin.H{
+					"title": "Invitation",
+					"error": "Invitationen findes ikke.",
+				})
+				return
+			}
+			log.Printf("Failed to get invitation: %s\n", err)
+			HTML(ctx, http.StatusInternalServerError, "pages/invite", gin.H{
+				"title": "Invitation",
+				"error": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/invite", gin.H{
+			"title":      "Invitation",
+			"invitation": info,
+		})
+	}
+}
+
+func (c *InvitationController) PostAcceptInvite() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		token := ctx.Param("token")
+		groupID, err := c.invitationLogic.Accept(ctx.Request.Context(), userID, token)
+		if err != nil {
+			var alert string
+			switch {
+			case errors.Is(err, internalerrors.ErrUserAlreadyInGroup):
+				alert = "Du er allerede medlem af gruppen."
+			case errors.Is(err, internalerrors.ErrInvitationNotValid), errors.Is(err, gorm.ErrRecordNotFound):
+				alert = "Invitationen er ikke længere gyldig."
+			case errors.Is(err, internalerrors.ErrEmailNotVerified):
+				alert = "Du skal bekræfte din email, før du kan blive medlem af en gruppe."
+			default:
+				log.Printf("Failed to accept invitation for user=%s: %s\n", userID, err)
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			HTML(ctx, http.StatusBadRequest, "pages/invite", gin.H{
+				"title": "Invitation",
+				"error": alert,
+			})
+			return
+		}
+
+		err = c.authService.SwitchGroup(ctx.Request.Context(), userID, ctx.GetString(KeySession), groupID)
+		if err != nil {
+			log.Printf("Failed to switch to joined group=%s for user=%s: %s\n", groupID, userID, err)
+		}
+
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *InvitationController) PostDeclineInvite() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		token := ctx.Param("token")
+		err := c.invitationLogic.Decline(ctx.Request.Context(), userID, token)
+		if err != nil {
+			log.Printf("Failed to decline invitation for user=%s: %s\n", userID, err)
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile")
+	}
+}
+
+func (c *InvitationController) renderInvitations(ctx *gin.Context, status int, alert string) {
+	userID := ctx.GetString(KeyUserID)
+	invitations, err := c.invitationLogic.GetActive(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+	if err != nil {
+		if errors.Is(err, internalerrors.ErrMissingPermission) {
+			HTML(ctx, http.StatusForbidden, "pages/add-member", gin.H{
+				"title": "Tilføj medlem",
+				"error": "Du har ikke rettigheder til at invitere nye medlemmer.",
+			})
+			return
+		}
+		log.Printf("Failed to get invitations for user=%s: %s\n", userID, err)
+		status = http.StatusInternalServerError
+		alert = "Kunne ikke hente invitationer. Prøv igen om lidt."
+	}
+
+	links := map[string]string{}
+	for _, invitation := range invitations {
+		links[invitation.ID] = c.invitationURL(invitation.Token)
+	}
+
+	obj := gin.H{
+		"title":       "Tilføj medlem",
+		"invitations": invitations,
+		"links":       links,
+	}
+	if alert != "" {
+		obj["error"] = alert
+	}
+	HTML(ctx, status, "pages/add-member", obj)
+}
+
+func (c *InvitationController) invitationURL(token string) string {
+	return fmt.Sprintf("%s/invite/%s", c.baseURL, token)
+}
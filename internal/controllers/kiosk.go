@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type KioskController struct {
+	kioskLogic    *app.KioskLogic
+	baseURL       string
+	secureCookies bool
+}
+
+func NewKioskController(
+	router gin.IRouter,
+	protectedRouter gin.IRouter,
+	kioskLogic *app.KioskLogic,
+	baseURL string,
+	secureCookies bool,
+) *KioskController {
+	handler := &KioskController{kioskLogic: kioskLogic, baseURL: baseURL, secureCookies: secureCookies}
+
+	protectedRouter.GET("/group/devices", handler.GetDevices())
+	protectedRouter.POST("/group/devices", handler.PostCreateDevice())
+	protectedRouter.POST("/group/devices/:id/revoke", handler.PostRevokeDevice())
+
+	router.GET("/kiosk/setup/:code", handler.GetSetup())
+	router.POST("/kiosk/setup/:code", handler.PostSetup())
+
+	kioskRouter := router.Group("/kiosk")
+	kioskRouter.Use(KioskMiddleware(kioskLogic))
+	kioskRouter.GET("", handler.GetBoard())
+	kioskRouter.POST("/task/:id/complete", handler.PostTaskComplete())
+
+	return handler
+}
+
+func (c *KioskController) GetDevices() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.renderDevices(ctx, http.StatusOK, "", "")
+	}
+}
+
+func (c *KioskController) PostCreateDevice() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		name := strings.TrimSpace(ctx.PostForm("name"))
+		if name == "" {
+			c.renderDevices(ctx, http.StatusBadRequest, "", "Enhedens navn skal udfyldes")
+			return
+		}
+
+		code, err := c.kioskLogic.CreateDevice(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), name)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				c.renderDevices(ctx, http.StatusForbidden, "", "Du har ikke rettigheder til at ændre gruppens indstillinger.")
+				return
+			}
+			log.Printf("Failed to create device for user=%s: %s\n", userID, err)
+			c.renderDevices(ctx, http.StatusInternalServerError, "", "Der skete en fejl. Prøv igen om lidt.")
+			return
+		}
+
+		c.renderDevices(ctx, http.StatusOK, c.baseURL+"/kiosk/setup/"+code, "")
+	}
+}
+
+func (c *KioskController) PostRevokeDevice() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		deviceID := ctx.Param("id")
+		err := c.kioskLogic.RevokeDevice(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), deviceID)
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrMissingPermission):
+				ctx.Status(http.StatusForbidden)
+			case errors.Is(err, internalerrors.ErrDeviceNotFound):
+				ctx.Status(http.StatusNotFound)
+			default:
+				log.Printf("Failed to revoke device=%s for user=%s: %s\n", deviceID, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/group/devices")
+	}
+}
+
+func (c *KioskController) renderDevices(ctx *gin.Context, status int, setupURL string, alert string) {
+	userID := ctx.GetString(KeyUserID)
+	devices, err := c.kioskLogic.GetDevices(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+	if err != nil {
+		if errors.Is(err, internalerrors.ErrMissingPermission) {
+			status = http.StatusForbidden
+			alert = "Du har ikke rettigheder til at ændre gruppens indstillinger."
+		} else {
+			log.Printf("Failed to get devices for user=%s: %s\n", userID, err)
+			status = http.StatusInternalServerError
+			alert = "Der skete en fejl. Prøv igen om lidt."
+		}
+	}
+
+	obj := gin.H{
+		"title":    "Delte enheder",
+		"devices":  devices,
+		"setupURL": setupURL,
+	}
+	if alert != "" {
+		obj["error"] = alert
+	}
+	HTML(ctx, status, "pages/devices", obj)
+}
+
+// GetSetup asks before setting up the device, so the setup link isn't used up by apps which open links to show a
+// preview of them.
+func (c *KioskController) GetSetup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/kiosk", gin.H{
+			"title":     "Taskeroo",
+			"setupCode": ctx.Param("code"),
+		})
+	}
+}
+
+// PostSetup puts the device in kiosk mode, by exchanging the code from the setup link for a device token which is
+// stored in a cookie.
+func (c *KioskController) PostSetup() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, err := c.kioskLogic.SetUpDevice(ctx.Request.Context(), ctx.Param("code"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			alert := "Der skete en fejl. Prøv igen om lidt."
+			if errors.Is(err, internalerrors.ErrInvalidSetupCode) {
+				status = http.StatusNotFound
+				alert = "Linket er ikke længere gyldigt."
+			} else {
+				log.Printf("Failed to set up device: %s\n", err)
+			}
+			HTML(ctx, status, "pages/kiosk", gin.H{
+				"title": "Taskeroo",
+				"error": alert,
+			})
+			return
+		}
+
+		ctx.SetCookie(CookieKeyDeviceToken, token, int(TimeDeviceToken.Seconds()), "/kiosk", "", c.secureCookies, true)
+		ctx.Redirect(http.StatusFound, "/kiosk")
+	}
+}
+
+func (c *KioskController) GetBoard() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		device := ctx.MustGet(KeyDevice).(app.KioskDevice)
+		board, err := c.kioskLogic.GetBoard(ctx.Request.Context(), device)
+		if err != nil {
+			log.Printf("Failed to get board for device=%s: %s\n", device.ID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/kiosk", gin.H{
+				"title": "Taskeroo",
+				"error": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		alert := ""
+		if code, ok := ctx.GetQuery("error"); ok {
+			alert = kioskErrorMessage(code)
+		}
+
+		HTML(ctx, http.StatusOK, "pages/kiosk", gin.H{
+			"title": board.GroupName,
+			"board": board,
+			"alert": alert,
+			"whole": func(number float64) int {
+				return int(number * 100)
+			},
+		})
+	}
+}
+
+func (c *KioskController) PostTaskComplete() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		device := ctx.MustGet(KeyDevice).(app.KioskDevice)
+		taskID := ctx.Param("id")
+		err := c.kioskLogic.Complete(ctx.Request.Context(), device, taskID, ctx.PostForm("memberID"))
+		if err != nil {
+			code := kioskErrorCode(err)
+			if code == "" {
+				log.Printf("Failed to complete task=%s on device=%s: %s\n", taskID, device.ID, err)
+			}
+			// The board reloads itself, so the error is shown after a redirect rather than in the response to the POST.
+			ctx.Redirect(http.StatusFound, "/kiosk?error="+url.QueryEscape(code))
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/kiosk")
+	}
+}
+
+// kioskErrors are the errors of completing a task on a device which the user can do something about. The code is
+// used to show the message after a redirect, like oauthErrors.
+var kioskErrors = []struct {
+	err     error
+	code    string
+	message string
+}{
+	{internalerrors.ErrUserNotMemberOfGroup, "member", "Medlemmet er ikke længere i gruppen."},
+	{gorm.ErrRecordNotFound, "task", "Opgaven findes ikke længere."},
+	{internalerrors.ErrCompletionPending, "pending", "Opgaven venter allerede på godkendelse."},
+}
+
+// kioskErrorCode returns the code of the error, and an empty string for unexpected errors.
+func kioskErrorCode(err error) string {
+	for _, kioskErr := range kioskErrors {
+		if errors.Is(err, kioskErr.err) {
+			return kioskErr.code
+		}
+	}
+	return ""
+}
+
+// kioskErrorMessage returns the message for the code from kioskErrorCode.
+func kioskErrorMessage(code string) string {
+	for _, kioskErr := range kioskErrors {
+		if kioskErr.code == code {
+			return kioskErr.message
+		}
+	}
+	return "Opgaven kunne ikke markeres som udført. Prøv igen om lidt."
+}
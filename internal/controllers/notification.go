@@ -22,7 +22,7 @@ func NewNotificationController(protectedRouter gin.IRouter, notificationLogic *a
 func (c *NotificationController) GetNotifications() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userID := ctx.GetString(KeyUserID)
-		notificationInfo, err := c.notificationLogic.GetNotificationInfo(ctx, userID)
+		notificationInfo, err := c.notificationLogic.GetNotificationInfo(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
 		if err != nil {
 			log.Printf("Failed to get group discord settings, for user=%s: %s\n", userID, err)
 			HTML(ctx, http.StatusInternalServerError, "pages/notifications", gin.H{
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// cookiePathOAuthLogin limits the CookieKeyOAuthLogin cookie to the callbacks of the providers.
+const cookiePathOAuthLogin = "/login/oauth/"
+
+type OAuthController struct {
+	oauthLogic    *app.OAuthLogic
+	sessionCookie *SessionCookie
+	baseURL       string
+	secureCookies bool
+}
+
+// NewOAuthController registers the routes for logging in with providers like Google or GitHub, and for linking and
+// unlinking them on the profile.
+func NewOAuthController(
+	router gin.IRouter,
+	protectedRouter gin.IRouter,
+	oauthLogic *app.OAuthLogic,
+	sessionCookie *SessionCookie,
+	baseURL string,
+	secureCookies bool,
+) *OAuthController {
+	handler := &OAuthController{
+		oauthLogic:    oauthLogic,
+		sessionCookie: sessionCookie,
+		baseURL:       baseURL,
+		secureCookies: secureCookies,
+	}
+
+	router.POST("/login/oauth/:provider", handler.PostLogin())
+	router.GET("/login/oauth/:provider/callback", handler.GetCallback())
+
+	protectedRouter.GET("/profile/linked-accounts", handler.GetLinkedAccounts())
+	protectedRouter.POST("/profile/linked-accounts/:provider/link", handler.PostLink())
+	protectedRouter.POST("/profile/linked-accounts/:provider/unlink", handler.PostUnlink())
+
+	return handler
+}
+
+// OAuthProvidersMiddleware makes the providers users can log in with available to all templates as oauthProviders,
+// so the login page can show them wherever it is rendered from.
+func OAuthProvidersMiddleware(oauthLogic *app.OAuthLogic) gin.HandlerFunc {
+	providers := oauthLogic.Providers()
+	return func(ctx *gin.Context) {
+		ctx.Set(KeyOAuthProviders, providers)
+		ctx.Next()
+	}
+}
+
+func (c *OAuthController) PostLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		provider := ctx.Param("provider")
+		login, err := c.oauthLogic.BeginLogin(provider, c.redirectURI(provider), safeRedirect(ctx.PostForm("next")))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrUnknownOAuthProvider) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to begin login with provider=%s: %s\n", provider, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
+			return
+		}
+
+		c.redirectToProvider(ctx, login)
+	}
+}
+
+func (c *OAuthController) GetCallback() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		provider := ctx.Param("provider")
+		token, _ := ctx.Cookie(CookieKeyOAuthLogin)
+		ctx.SetCookie(CookieKeyOAuthLogin, "", -1, cookiePathOAuthLogin, "", c.secureCookies, true)
+
+		result := app.OAuthResult{}
+		err := internalerrors.ErrInvalidOAuthLogin
+		// The provider sends an error instead of a code if the user cancelled the login.
+		if ctx.Query("error") == "" {
+			result, err = c.oauthLogic.FinishLogin(ctx.Request.Context(), provider, token, c.redirectURI(provider), ctx.Query("state"), ctx.Query("code"), clientInfo(ctx))
+		}
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrUnknownOAuthProvider) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, internalerrors.ErrTwoFactorRequired) {
+				var twoFactorErr internalerrors.TwoFactorRequiredError
+				errors.As(err, &twoFactorErr)
+				HTML(ctx, http.StatusOK, "pages/login-2fa", gin.H{
+					"title": "Login",
+					"token": twoFactorErr.Token,
+					"next":  result.Next,
+				})
+				return
+			}
+
+			code := oauthErrorCode(err)
+			if code == "" {
+				log.Printf("Failed to finish login with provider=%s: %s\n", provider, err)
+			}
+			if result.Link {
+				ctx.Redirect(http.StatusFound, "/profile/linked-accounts?error="+url.QueryEscape(code))
+				return
+			}
+			HTML(ctx, http.StatusOK, "pages/login", gin.H{"title": "Login", "error": oauthErrorMessage(code)})
+			return
+		}
+
+		if result.Link {
+			ctx.Redirect(http.StatusFound, "/profile/linked-accounts?linked=true")
+			return
+		}
+
+		c.sessionCookie.Set(ctx, result.Session.Token)
+		if result.Next != "" {
+			ctx.Redirect(http.StatusFound, result.Next)
+			return
+		}
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *OAuthController) GetLinkedAccounts() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		accounts, err := c.oauthLogic.GetAccounts(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+		if err != nil {
+			log.Printf("Failed to get linked accounts for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/linked-accounts", gin.H{
+				"title": "Forbundne konti",
+				"error": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		success, errorMessage := "", ""
+		if ctx.Query("linked") == "true" {
+			success = "Din konto er forbundet."
+		}
+		if code, ok := ctx.GetQuery("error"); ok {
+			errorMessage = oauthErrorMessage(code)
+		}
+		HTML(ctx, http.StatusOK, "pages/linked-accounts", gin.H{
+			"title":    "Forbundne konti",
+			"accounts": accounts,
+			"success":  success,
+			"error":    errorMessage,
+		})
+	}
+}
+
+func (c *OAuthController) PostLink() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		provider := ctx.Param("provider")
+		login, err := c.oauthLogic.BeginLink(ctx.Request.Context(), userID, provider, c.redirectURI(provider))
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrUnknownOAuthProvider):
+				ctx.Status(http.StatusNotFound)
+			case errors.Is(err, internalerrors.ErrManagedUser):
+				ctx.Status(http.StatusForbidden)
+			default:
+				log.Printf("Failed to begin linking provider=%s for user=%s: %s\n", provider, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		c.redirectToProvider(ctx, login)
+	}
+}
+
+func (c *OAuthController) PostUnlink() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.oauthLogic.Unlink(ctx.Request.Context(), userID, ctx.Param("provider"))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrOAuthAccountNotFound) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to unlink account for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile/linked-accounts")
+	}
+}
+
+// redirectToProvider sends the user to the provider, and keeps the token of the login in a cookie until the provider
+// sends them back.
+func (c *OAuthController) redirectToProvider(ctx *gin.Context, login app.OAuthLogin) {
+	ctx.SetCookie(CookieKeyOAuthLogin, login.Token, int(TimeOAuthLogin.Seconds()), cookiePathOAuthLogin, "", c.secureCookies, true)
+	ctx.Redirect(http.StatusFound, login.URL)
+}
+
+// redirectURI returns the URL the provider sends the user back to, which must be registered at the provider.
+func (c *OAuthController) redirectURI(provider string) string {
+	return c.baseURL + "/login/oauth/" + url.PathEscape(provider) + "/callback"
+}
+
+// oauthErrors are the errors of logging in with a provider which the user can do something about. The code is used
+// to show the message after a redirect, so the message itself can't be given in the URL.
+var oauthErrors = []struct {
+	err     error
+	code    string
+	message string
+}{
+	{internalerrors.ErrInvalidOAuthLogin, "invalid", "Login blev afbrudt eller er udløbet. Prøv igen."},
+	{internalerrors.ErrOAuthEmailNotVerified, "provider-email", "Din email er ikke bekræftet hos udbyderen."},
+	{internalerrors.ErrOAuthNoAccount, "no-account", "Der er ingen konto med din email. Opret en konto, eller log ind med dit password og forbind kontoen under din profil."},
+	{internalerrors.ErrEmailNotVerified, "email", "Bekræft din email via linket i den mail vi sendte, før du logger ind på denne måde."},
+	{internalerrors.ErrOAuthAccountLinked, "account-linked", "Kontoen er allerede forbundet med en anden bruger."},
+	{internalerrors.ErrOAuthProviderLinked, "provider-linked", "Du har allerede forbundet en anden konto hos samme udbyder."},
+}
+
+// oauthErrorCode returns the code of the error, and an empty string for unexpected errors.
+func oauthErrorCode(err error) string {
+	for _, oauthErr := range oauthErrors {
+		if errors.Is(err, oauthErr.err) {
+			return oauthErr.code
+		}
+	}
+	return ""
+}
+
+// oauthErrorMessage returns the message for the code from oauthErrorCode.
+func oauthErrorMessage(code string) string {
+	for _, oauthErr := range oauthErrors {
+		if oauthErr.code == code {
+			return oauthErr.message
+		}
+	}
+	return "Der skete en fejl. Prøv igen om lidt."
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// cookiePathPasskeyLogin limits the CookieKeyPasskeyLogin cookie to the routes of logging in with a passkey.
+const cookiePathPasskeyLogin = "/login/passkey/"
+
+type PasskeyController struct {
+	passkeyLogic  *app.PasskeyLogic
+	sessionCookie *SessionCookie
+	relyingParty  util.WebAuthnRelyingParty
+	secureCookies bool
+}
+
+// NewPasskeyController registers the routes for managing passkeys and logging in with them. The WebAuthn ceremonies
+// are run by scripts on the pages, which exchange JSON with the begin and finish routes. Passkeys are bound to the
+// host of baseURL.
+func NewPasskeyController(
+	router gin.IRouter,
+	protectedRouter gin.IRouter,
+	passkeyLogic *app.PasskeyLogic,
+	sessionCookie *SessionCookie,
+	baseURL string,
+	secureCookies bool,
+) *PasskeyController {
+	handler := &PasskeyController{
+		passkeyLogic:  passkeyLogic,
+		sessionCookie: sessionCookie,
+		relyingParty:  relyingParty(baseURL),
+		secureCookies: secureCookies,
+	}
+
+	router.POST("/login/passkey/begin", handler.PostBeginLogin())
+	router.POST("/login/passkey/finish", handler.PostFinishLogin())
+
+	protectedRouter.GET("/profile/passkeys", handler.GetPasskeys())
+	protectedRouter.POST("/profile/passkeys/register/begin", handler.PostBeginRegistration())
+	protectedRouter.POST("/profile/passkeys/register/finish", handler.PostFinishRegistration())
+	protectedRouter.POST("/profile/passkeys/:id/revoke", handler.PostRevokePasskey())
+
+	return handler
+}
+
+func (c *PasskeyController) GetPasskeys() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		passkeys, err := c.passkeyLogic.GetPasskeys(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+		if err != nil {
+			log.Printf("Failed to get passkeys for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/passkeys", gin.H{
+				"title": "Passkeys",
+				"error": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		success := ""
+		if ctx.Query("registered") == "true" {
+			success = "Din passkey er tilføjet."
+		}
+		HTML(ctx, http.StatusOK, "pages/passkeys", gin.H{
+			"title":    "Passkeys",
+			"passkeys": passkeys,
+			"success":  success,
+		})
+	}
+}
+
+func (c *PasskeyController) PostBeginRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		options, err := c.passkeyLogic.BeginRegistration(ctx.Request.Context(), userID, c.relyingParty)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrManagedUser) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "Din konto styres af et andet medlem af gruppen."})
+				return
+			}
+			log.Printf("Failed to begin passkey registration for user=%s: %s\n", userID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Der skete en fejl. Prøv igen om lidt."})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, options)
+	}
+}
+
+func (c *PasskeyController) PostFinishRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		var registration app.PasskeyRegistration
+		err := ctx.ShouldBindJSON(&registration)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Passkey kunne ikke tilføjes. Prøv igen."})
+			return
+		}
+
+		err = c.passkeyLogic.FinishRegistration(ctx.Request.Context(), userID, c.relyingParty, registration)
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrInvalidPasskey):
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Passkey kunne ikke tilføjes. Prøv igen."})
+			case errors.Is(err, internalerrors.ErrPasskeyExists):
+				ctx.JSON(http.StatusConflict, gin.H{"error": "Den passkey er allerede tilføjet."})
+			default:
+				log.Printf("Failed to finish passkey registration for user=%s: %s\n", userID, err)
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Der skete en fejl. Prøv igen om lidt."})
+			}
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"redirect": "/profile/passkeys?registered=true"})
+	}
+}
+
+func (c *PasskeyController) PostRevokePasskey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.passkeyLogic.RevokePasskey(ctx.Request.Context(), userID, ctx.Param("id"))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrPasskeyNotFound) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			log.Printf("Failed to revoke passkey for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/profile/passkeys")
+	}
+}
+
+func (c *PasskeyController) PostBeginLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		options, token, err := c.passkeyLogic.BeginLogin(c.relyingParty)
+		if err != nil {
+			log.Printf("Failed to begin passkey login: %s\n", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Der skete en fejl. Prøv igen om lidt."})
+			return
+		}
+
+		ctx.SetCookie(CookieKeyPasskeyLogin, token, int(TimePasskeyLogin.Seconds()), cookiePathPasskeyLogin, "", c.secureCookies, true)
+		ctx.JSON(http.StatusOK, options)
+	}
+}
+
+func (c *PasskeyController) PostFinishLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var request struct {
+			app.PasskeyAssertion
+			Next string `json:"next"`
+		}
+		err := ctx.ShouldBindJSON(&request)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Passkey blev ikke godkendt. Prøv igen."})
+			return
+		}
+
+		token, _ := ctx.Cookie(CookieKeyPasskeyLogin)
+		ctx.SetCookie(CookieKeyPasskeyLogin, "", -1, cookiePathPasskeyLogin, "", c.secureCookies, true)
+
+		userSession, err := c.passkeyLogic.FinishLogin(ctx.Request.Context(), c.relyingParty, token, request.PasskeyAssertion, clientInfo(ctx))
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrInvalidPasskey) {
+				ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey blev ikke godkendt. Prøv igen."})
+				return
+			}
+			log.Printf("Failed to log in with passkey: %s\n", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Der skete en fejl. Prøv igen om lidt."})
+			return
+		}
+
+		c.sessionCookie.Set(ctx, userSession.Token)
+		next := safeRedirect(request.Next)
+		if next == "" {
+			next = "/"
+		}
+		ctx.JSON(http.StatusOK, gin.H{"redirect": next})
+	}
+}
+
+// relyingParty returns the site passkeys are registered with, which is the host of the base URL.
+func relyingParty(baseURL string) util.WebAuthnRelyingParty {
+	parsed, _ := url.Parse(baseURL)
+	return util.WebAuthnRelyingParty{ID: parsed.Hostname(), Origin: baseURL}
+}
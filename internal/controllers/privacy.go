@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+type PrivacyController struct {
+	privacyLogic *app.PrivacyLogic
+}
+
+func NewPrivacyController(protectedRouter gin.IRouter, privacyLogic *app.PrivacyLogic) *PrivacyController {
+	handler := &PrivacyController{privacyLogic: privacyLogic}
+	protectedRouter.GET("/profile/export.json", handler.GetExportJSON())
+	protectedRouter.GET("/profile/export.zip", handler.GetExportZIP())
+	protectedRouter.GET("/profile/delete", handler.GetDeleteAccount())
+	protectedRouter.POST("/profile/delete", handler.PostDeleteAccount())
+
+	return handler
+}
+
+func (c *PrivacyController) GetExportJSON() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		export, ok := c.export(ctx)
+		if !ok {
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, exportFilename(export)))
+		ctx.IndentedJSON(http.StatusOK, export)
+	}
+}
+
+func (c *PrivacyController) GetExportZIP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		export, ok := c.export(ctx)
+		if !ok {
+			return
+		}
+
+		ctx.Header("Content-Type", "application/zip")
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, exportFilename(export)))
+		ctx.Status(http.StatusOK)
+		err := app.WriteExportZIP(ctx.Writer, export)
+		if err != nil {
+			log.Printf("Failed to write data export for user=%s: %s\n", ctx.GetString(KeyUserID), err)
+		}
+	}
+}
+
+func (c *PrivacyController) export(ctx *gin.Context) (app.DataExport, bool) {
+	userID := ctx.GetString(KeyUserID)
+	export, err := c.privacyLogic.Export(ctx.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, internalerrors.ErrManagedUser) {
+			ctx.Status(http.StatusForbidden)
+			return app.DataExport{}, false
+		}
+		log.Printf("Failed to export data for user=%s: %s\n", userID, err)
+		ctx.Status(http.StatusInternalServerError)
+		return app.DataExport{}, false
+	}
+
+	return export, true
+}
+
+func exportFilename(export app.DataExport) string {
+	return "taskeroo-" + export.ExportedAt.Format("2006-01-02")
+}
+
+func (c *PrivacyController) GetDeleteAccount() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		HTML(ctx, http.StatusOK, "pages/delete-account", gin.H{
+			"title": "Slet konto",
+		})
+	}
+}
+
+func (c *PrivacyController) PostDeleteAccount() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.privacyLogic.DeleteAccount(ctx.Request.Context(), userID, ctx.PostForm("password"))
+		if err != nil {
+			var alert string
+			status := http.StatusBadRequest
+			switch {
+			case errors.Is(err, internalerrors.ErrWrongPassword):
+				alert = "Passwordet er forkert"
+			case errors.Is(err, internalerrors.ErrOwnerCannotDelete):
+				alert = "Du ejer en gruppe med andre medlemmer. Overdrag ejerskabet, før du sletter din konto."
+			case errors.Is(err, internalerrors.ErrManagedUser):
+				status = http.StatusForbidden
+				alert = "Din konto styres af et andet medlem af gruppen."
+			default:
+				log.Printf("Failed to delete account of user=%s: %s\n", userID, err)
+				status = http.StatusInternalServerError
+				alert = "Der skete en fejl. Prøv igen om lidt."
+			}
+			HTML(ctx, status, "pages/delete-account", gin.H{
+				"title": "Slet konto",
+				"error": alert,
+			})
+			return
+		}
+
+		clearCookies(ctx)
+		ctx.Redirect(http.StatusFound, "/login?deleted=true")
+	}
+}
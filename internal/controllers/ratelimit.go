@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dentych/taskeroo/internal/app"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// LoginRateLimitMiddleware rejects login attempts from IP addresses with too many failed attempts, before the
+// password is checked. The given page is rendered with an error.
+func LoginRateLimitMiddleware(loginLimiter *app.LoginLimiter, templateName string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		err := loginLimiter.CheckIP(ctx.Request.Context(), ctx.ClientIP())
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrTooManyAttempts) {
+				renderTooManyAttempts(ctx, templateName, err, gin.H{"title": "Login"})
+				ctx.Abort()
+				return
+			}
+			log.Printf("Failed to check failed login attempts: %s\n", err)
+			HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// renderTooManyAttempts renders the page with status 429, a Retry-After header and an error telling when to try again.
+func renderTooManyAttempts(ctx *gin.Context, templateName string, err error, obj gin.H) {
+	var tooManyAttempts internalerrors.TooManyAttemptsError
+	seconds := 60
+	if errors.As(err, &tooManyAttempts) {
+		seconds = int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))
+	}
+
+	wait := fmt.Sprintf("%d sekunder", seconds)
+	if seconds > 60 {
+		wait = fmt.Sprintf("%d minutter", int(math.Ceil(float64(seconds)/60)))
+	}
+
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	obj["error"] = fmt.Sprintf("For mange forsøg på at logge ind. Prøv igen om %s.", wait)
+	HTML(ctx, http.StatusTooManyRequests, templateName, obj)
+}
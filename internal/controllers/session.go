@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"github.com/dentych/taskeroo/internal/util"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+const (
+	// legacyCookieKeyUserID and legacyCookieKeySession are the cookies sessions were kept in before the session
+	// cookie. A legacy session is moved to the session cookie the first time it is used.
+	legacyCookieKeyUserID  = "auth_userid"
+	legacyCookieKeySession = "auth_session"
+)
+
+// SessionCookie reads and writes the session cookie, which holds the token of the session. The token is signed with
+// the first of the keys, and tokens signed with any of the keys are accepted, so keys can be rotated by adding a new
+// key first and removing the old one after a while. Nothing else is kept on the client, as the session is looked up
+// by the hash of its token.
+type SessionCookie struct {
+	keys   [][]byte
+	secure bool
+}
+
+func NewSessionCookie(keys [][]byte, secure bool) *SessionCookie {
+	return &SessionCookie{keys: keys, secure: secure}
+}
+
+// Set sets the session cookie to the token. The cookie lasts as long as the session would, if it isn't used again.
+func (s *SessionCookie) Set(ctx *gin.Context, token string) {
+	ctx.SetCookie(CookieKeySession, util.SignToken(s.keys[0], token), int(Time31Days.Seconds()), "/", "", s.secure, true)
+}
+
+// Get returns the token in the session cookie, and false if there is none or it isn't signed with any of the keys.
+// stale is true if the cookie should be set again, because it is signed with an old key or is a legacy cookie.
+func (s *SessionCookie) Get(ctx *gin.Context) (token string, stale bool, ok bool) {
+	value, err := ctx.Cookie(CookieKeySession)
+	if err != nil || value == "" {
+		token, err = ctx.Cookie(legacyCookieKeySession)
+		if err != nil || token == "" {
+			return "", false, false
+		}
+		return token, true, true
+	}
+
+	for i, key := range s.keys {
+		token, ok = util.VerifySignedToken(key, value)
+		if ok {
+			return token, i > 0, true
+		}
+	}
+
+	return "", false, false
+}
+
+// sessionSeed returns the token in the session cookie without checking the signature, as a value which is unique to
+// the session. Unlike the cookie, it doesn't change when the cookie is signed with a new key. It is empty if there is
+// no session cookie.
+func sessionSeed(ctx *gin.Context) string {
+	value, err := ctx.Cookie(CookieKeySession)
+	if err == nil && value != "" {
+		token, err := base64.RawURLEncoding.DecodeString(strings.SplitN(value, ".", 2)[0])
+		if err != nil {
+			return value
+		}
+		return string(token)
+	}
+
+	value, err = ctx.Cookie(legacyCookieKeySession)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// clearLegacyCookies removes the cookies sessions were kept in before the session cookie.
+func clearLegacyCookies(ctx *gin.Context) {
+	ctx.SetCookie(legacyCookieKeyUserID, "", -1, "/", "", true, true)
+	ctx.SetCookie(legacyCookieKeySession, "", -1, "/", "", true, true)
+}
@@ -2,17 +2,24 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/dentych/taskeroo/internal/app"
 	"github.com/dentych/taskeroo/internal/database"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type TaskController struct {
 	userRepo  *database.UserRepo
 	taskLogic *app.TaskLogic
+	baseURL   string
 }
 
 func NewTaskController(
@@ -20,8 +27,9 @@ func NewTaskController(
 	protectedRouter gin.IRouter,
 	userRepo *database.UserRepo,
 	taskLogic *app.TaskLogic,
+	baseURL string,
 ) *TaskController {
-	handler := &TaskController{userRepo: userRepo, taskLogic: taskLogic}
+	handler := &TaskController{userRepo: userRepo, taskLogic: taskLogic, baseURL: baseURL}
 
 	protectedRouter.GET("/", handler.GetIndex())
 
@@ -30,10 +38,27 @@ func NewTaskController(
 
 	protectedRouter.POST("/task/:id/delete", handler.PostDelete())
 
+	protectedRouter.POST("/task/bulk", handler.PostBulk())
+
 	protectedRouter.GET("/task/:id/edit", handler.GetEditTask())
 	protectedRouter.POST("/task/:id/edit", handler.PostEditTask())
 
 	protectedRouter.POST("/task/:id/complete", handler.PostTaskComplete())
+	protectedRouter.POST("/task/completions/:id/approve", handler.PostApproveCompletion())
+	protectedRouter.POST("/task/completions/:id/reject", handler.PostRejectCompletion())
+
+	protectedRouter.POST("/task/:id/usage", handler.PostTaskUsage())
+
+	protectedRouter.GET("/group/trash", handler.GetTrash())
+	protectedRouter.POST("/task/:id/restore", handler.PostTaskRestore())
+	protectedRouter.POST("/task/:id/purge", handler.PostTaskPurge())
+
+	protectedRouter.POST("/task/:id/claim", handler.PostTaskClaim())
+	protectedRouter.POST("/task/:id/unclaim", handler.PostTaskUnclaim())
+
+	protectedRouter.POST("/task/:id/webhook/rotate", handler.PostRotateWebhook())
+	protectedRouter.POST("/task/:id/webhook/disable", handler.PostDisableWebhook())
+	router.POST("/webhook/task/:token", handler.PostWebhook())
 
 	router.POST("/task/debug/notify-due-today", handler.PostDebugNotifyDueToday())
 
@@ -43,16 +68,8 @@ func NewTaskController(
 func (c *TaskController) GetIndex() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userID := ctx.GetString(KeyUserID)
-		user, err := c.userRepo.Get(ctx.Request.Context(), userID)
-		if err != nil {
-			log.Printf("Failed to get user with ID '%s': %s\n", userID, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/index", gin.H{
-				"title": "Taskeroo",
-				"alert": "",
-			})
-			return
-		}
-		if user.GroupID == nil {
+		groupID := ctx.GetString(KeyGroupID)
+		if groupID == "" {
 			log.Printf("User=%s is not in a group, so can't retrieve tasks\n", userID)
 			HTML(ctx, http.StatusBadRequest, "pages/index", gin.H{
 				"title": "Taskeroo",
@@ -60,15 +77,41 @@ func (c *TaskController) GetIndex() gin.HandlerFunc {
 			return
 		}
 
-		tasks, err := c.taskLogic.GetAllForUserIDAndGroupID(ctx.Request.Context(), userID, *user.GroupID)
+		tasks, err := c.taskLogic.GetAllForUserIDAndGroupID(ctx.Request.Context(), userID, groupID)
+
+		users, err := c.userRepo.GetByGroup(ctx, groupID)
+		if err != nil {
+			log.Printf("Failed to get members of group=%s: %s\n", groupID, err)
+		}
+
+		var members []Member
+		for _, member := range users {
+			members = append(members, Member{
+				ID:   member.ID,
+				Name: member.Name,
+			})
+		}
+
+		completions, err := c.taskLogic.GetPendingCompletions(ctx.Request.Context(), userID, groupID)
+		if err != nil && !errors.Is(err, internalerrors.ErrMissingPermission) {
+			log.Printf("Failed to get pending completions of group=%s: %s\n", groupID, err)
+		}
 
 		HTML(ctx, http.StatusOK, "pages/index", gin.H{
-			"title":   "Taskeroo",
-			"groupID": user.GroupID,
-			"tasks":   tasks,
+			"title":       "Taskeroo",
+			"groupID":     groupID,
+			"tasks":       tasks,
+			"members":     members,
+			"completions": completions,
 			"whole": func(number float64) int {
 				return int(number * 100)
 			},
+			"compare": func(a *string, b string) bool {
+				if a == nil {
+					return false
+				}
+				return *a == b
+			},
 		})
 	}
 }
@@ -81,23 +124,15 @@ type Member struct {
 func (c *TaskController) GetCreateTask() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		userID := ctx.GetString(KeyUserID)
-		user, err := c.userRepo.Get(ctx, userID)
-		if err != nil {
-			log.Printf("Failed to get information on user=%s: %s\n", userID, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
-				"title": "Opret opgave",
-				"error": "Kunne ikke hente brugerinformation. Prøv igen om lidt.",
-			})
-			return
-		}
-		if user.GroupID == nil {
+		groupID := ctx.GetString(KeyGroupID)
+		if groupID == "" {
 			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
 				"title": "Opret opgave",
 				"error": "Bruger er ikke i en gruppe",
 			})
 			return
 		}
-		users, err := c.userRepo.GetByGroup(ctx, *user.GroupID)
+		users, err := c.userRepo.GetByGroup(ctx, groupID)
 		if err != nil {
 			log.Printf("Failed to get information on user=%s: %s\n", userID, err)
 			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
@@ -130,6 +165,7 @@ func (c *TaskController) PostCreateTask() gin.HandlerFunc {
 		intervalUnit := ctx.PostForm("intervalUnit")
 		assignee := ctx.PostForm("assignee")
 		rotatingAssignee := ctx.PostForm("rotatingAssignee")
+		category := strings.TrimSpace(ctx.PostForm("category"))
 
 		if title == "" {
 			HTML(ctx, http.StatusBadRequest, "pages/create-task", gin.H{
@@ -165,6 +201,13 @@ func (c *TaskController) PostCreateTask() gin.HandlerFunc {
 				return
 			}
 		}
+		if intervalUnit == "usage" && formattedIntervalSize < 1 {
+			HTML(ctx, http.StatusBadRequest, "pages/create-task", gin.H{
+				"title": "Opret opgave",
+				"error": "Antal brug før opgaven skal udføres skal være mindst 1",
+			})
+			return
+		}
 
 		var assignedPerson *string
 		if assignee != "" {
@@ -176,15 +219,20 @@ func (c *TaskController) PostCreateTask() gin.HandlerFunc {
 		userID := ctx.GetString(KeyUserID)
 
 		var err error
-		_, err = c.taskLogic.Create(ctx.Request.Context(), userID, app.NewTask{
+		_, err = c.taskLogic.Create(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), app.NewTask{
 			Title:            title,
 			Description:      description,
 			Assignee:         assignedPerson,
 			RotatingAssignee: formattedRotatingAssignee,
+			Category:         category,
 			IntervalSize:     formattedIntervalSize,
 			IntervalUnit:     intervalUnit,
 		})
 		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
 			log.Printf("Failed to create task: %s\n", err)
 			ctx.Status(http.StatusInternalServerError)
 			return
@@ -206,8 +254,12 @@ func (c *TaskController) PostDelete() gin.HandlerFunc {
 		}
 
 		userID := ctx.GetString(KeyUserID)
-		err := c.taskLogic.Delete(ctx.Request.Context(), userID, taskID)
+		err := c.taskLogic.Delete(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
 		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
 			log.Printf("Failed to delete task for user=%s: %s\n", userID, err)
 		}
 
@@ -215,34 +267,57 @@ func (c *TaskController) PostDelete() gin.HandlerFunc {
 	}
 }
 
-func (c *TaskController) GetEditTask() gin.HandlerFunc {
+func (c *TaskController) PostBulk() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		taskID := ctx.Param("id")
 		userID := ctx.GetString(KeyUserID)
-		task, err := c.taskLogic.Get(ctx, userID, taskID)
+		taskIDs := ctx.PostFormArray("taskIDs")
+		action := ctx.PostForm("action")
+		postponeDays, _ := strconv.Atoi(ctx.PostForm("postponeDays"))
+		category := strings.TrimSpace(ctx.PostForm("category"))
+
+		var assignee *string
+		if value := ctx.PostForm("assignee"); value != "" {
+			assignee = &value
+		}
+
+		err := c.taskLogic.Bulk(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), app.BulkAction{
+			TaskIDs:      taskIDs,
+			Action:       action,
+			PostponeDays: postponeDays,
+			Assignee:     assignee,
+			Category:     category,
+		})
 		if err != nil {
-			log.Printf("Failed to get task=%s for user=%s: %s\n", taskID, userID, err)
+			if errors.Is(err, internalerrors.ErrNoTasksSelected) || errors.Is(err, internalerrors.ErrInvalidBulkAction) {
+				ctx.Status(http.StatusBadRequest)
+				return
+			}
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to perform bulk action=%s on %d tasks for user=%s: %s\n", action, len(taskIDs), userID, err)
 			ctx.Status(http.StatusInternalServerError)
 			return
 		}
 
-		user, err := c.userRepo.Get(ctx, userID)
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *TaskController) GetEditTask() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		groupID := ctx.GetString(KeyGroupID)
+		task, err := c.taskLogic.Get(ctx, userID, groupID, taskID)
 		if err != nil {
-			log.Printf("Failed to get information on user=%s: %s\n", userID, err)
-			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
-				"title": "Opret opgave",
-				"error": "Kunne ikke hente brugerinformation. Prøv igen om lidt.",
-			})
-			return
-		}
-		if user.GroupID == nil {
-			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
-				"title": "Opret opgave",
-				"error": "Bruger er ikke i en gruppe",
-			})
+			log.Printf("Failed to get task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
 			return
 		}
-		users, err := c.userRepo.GetByGroup(ctx, *user.GroupID)
+
+		users, err := c.userRepo.GetByGroup(ctx, groupID)
 		if err != nil {
 			log.Printf("Failed to get information on user=%s: %s\n", userID, err)
 			HTML(ctx, http.StatusInternalServerError, "pages/create-task", gin.H{
@@ -287,6 +362,7 @@ func (c *TaskController) PostEditTask() gin.HandlerFunc {
 		intervalUnit := ctx.PostForm("intervalUnit")
 		assignee := ctx.PostForm("assignee")
 		rotatingAssignee := ctx.PostForm("rotatingAssignee")
+		category := strings.TrimSpace(ctx.PostForm("category"))
 
 		formattedIntervalSize, err := strconv.Atoi(intervalSize)
 		if err != nil {
@@ -297,6 +373,7 @@ func (c *TaskController) PostEditTask() gin.HandlerFunc {
 			ID:           taskID,
 			Title:        title,
 			Description:  description,
+			Category:     category,
 			IntervalSize: formattedIntervalSize,
 			IntervalUnit: intervalUnit,
 		}
@@ -325,6 +402,14 @@ func (c *TaskController) PostEditTask() gin.HandlerFunc {
 			})
 			return
 		}
+		if intervalUnit == "usage" && formattedIntervalSize < 1 {
+			HTML(ctx, http.StatusBadRequest, "pages/edit-task", gin.H{
+				"title": "Opdatere opgave",
+				"error": "Antal brug før opgaven skal udføres skal være mindst 1",
+				"task":  task,
+			})
+			return
+		}
 
 		var assignedPerson *string
 		if assignee != "" {
@@ -333,16 +418,21 @@ func (c *TaskController) PostEditTask() gin.HandlerFunc {
 
 		formattedRotatingAssignee, _ := strconv.ParseBool(rotatingAssignee)
 
-		err = c.taskLogic.Update(ctx, userID, taskID, app.NewTask{
+		err = c.taskLogic.Update(ctx, userID, ctx.GetString(KeyGroupID), taskID, app.NewTask{
 			Title:            title,
 			Description:      description,
 			Assignee:         assignedPerson,
 			RotatingAssignee: formattedRotatingAssignee,
+			Category:         category,
 			IntervalSize:     formattedIntervalSize,
 			IntervalUnit:     intervalUnit,
 		})
 		if err != nil {
-			log.Printf("Failed to get task=%s for user=%s: %s\n", taskID, userID, err)
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to update task=%s for user=%s: %s\n", taskID, userID, err)
 			ctx.Status(http.StatusInternalServerError)
 			return
 		}
@@ -363,7 +453,7 @@ func (c *TaskController) PostTaskComplete() gin.HandlerFunc {
 		}
 
 		userID := ctx.GetString(KeyUserID)
-		err := c.taskLogic.Complete(ctx.Request.Context(), userID, taskID)
+		err := c.taskLogic.Complete(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
 		if err != nil {
 			log.Printf("Failed to complete task for user=%s: %s\n", userID, err)
 		}
@@ -372,6 +462,238 @@ func (c *TaskController) PostTaskComplete() gin.HandlerFunc {
 	}
 }
 
+func (c *TaskController) PostApproveCompletion() gin.HandlerFunc {
+	return c.decideCompletion(c.taskLogic.ApproveCompletion)
+}
+
+func (c *TaskController) PostRejectCompletion() gin.HandlerFunc {
+	return c.decideCompletion(c.taskLogic.RejectCompletion)
+}
+
+func (c *TaskController) decideCompletion(decide func(ctx context.Context, userID string, groupID string, completionID string) error) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		completionID := ctx.Param("id")
+		err := decide(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), completionID)
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrMissingPermission):
+				ctx.Status(http.StatusForbidden)
+			case errors.Is(err, internalerrors.ErrCompletionNotFound):
+				ctx.Status(http.StatusNotFound)
+			default:
+				log.Printf("Failed to decide completion=%s for user=%s: %s\n", completionID, userID, err)
+				ctx.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *TaskController) GetTrash() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		tasks, err := c.taskLogic.GetTrash(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID))
+		if err != nil {
+			log.Printf("Failed to get trash for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/trash", gin.H{
+				"title": "Papirkurv",
+				"error": "Kunne ikke hente slettede opgaver. Prøv igen om lidt.",
+			})
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/trash", gin.H{
+			"title": "Papirkurv",
+			"tasks": tasks,
+		})
+	}
+}
+
+func (c *TaskController) PostTaskRestore() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		err := c.taskLogic.Restore(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to restore task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/group/trash")
+	}
+}
+
+func (c *TaskController) PostTaskPurge() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		err := c.taskLogic.Purge(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to purge task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/group/trash")
+	}
+}
+
+// PostTaskUsage registers uses of a usage task. The number of uses is given by the optional count parameter, which
+// defaults to 1.
+func (c *TaskController) PostTaskUsage() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+
+		count := 1
+		if value := ctx.PostForm("count"); value != "" {
+			var err error
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "count must be a positive number"})
+				return
+			}
+		}
+
+		task, err := c.taskLogic.RegisterUsage(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID, count)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrTaskNotUsageBased) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "task is not usage based"})
+				return
+			}
+			log.Printf("Failed to register usage of task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register usage"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"usageCount": task.UsageCount,
+			"threshold":  task.IntervalSize,
+			"usesLeft":   task.DaysLeft,
+		})
+	}
+}
+
+func (c *TaskController) PostRotateWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		signed, _ := strconv.ParseBool(ctx.PostForm("signed"))
+
+		webhook, err := c.taskLogic.RotateWebhook(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID, signed)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to rotate webhook of task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/webhook", gin.H{
+			"title":      "Webhook",
+			"webhook":    webhook,
+			"webhookURL": fmt.Sprintf("%s/webhook/task/%s", c.baseURL, webhook.Token),
+		})
+	}
+}
+
+func (c *TaskController) PostDisableWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+
+		err := c.taskLogic.DisableWebhook(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrMissingPermission) {
+				ctx.Status(http.StatusForbidden)
+				return
+			}
+			log.Printf("Failed to disable webhook of task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, fmt.Sprintf("/task/%s/edit", taskID))
+	}
+}
+
+// PostWebhook makes a task due when an external system, like a home automation system, calls its webhook. If the
+// webhook requires signed requests, the X-Taskeroo-Signature header must contain "sha256=" followed by the hex
+// encoded HMAC-SHA256 of the request body, using the webhook secret as key.
+func (c *TaskController) PostWebhook() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.Param("token")
+		body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, 64*1024))
+		if err != nil {
+			ctx.Status(http.StatusBadRequest)
+			return
+		}
+
+		err = c.taskLogic.TriggerWebhook(ctx.Request.Context(), token, body, ctx.GetHeader("X-Taskeroo-Signature"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.Status(http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, internalerrors.ErrInvalidSignature) {
+				ctx.Status(http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Failed to trigger webhook: %s\n", err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+func (c *TaskController) PostTaskClaim() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		err := c.taskLogic.Claim(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
+		if err != nil {
+			if errors.Is(err, internalerrors.ErrTaskAlreadyClaimed) || errors.Is(err, internalerrors.ErrTaskAlreadyAssigned) {
+				ctx.Status(http.StatusConflict)
+				return
+			}
+			log.Printf("Failed to claim task=%s for user=%s: %s\n", taskID, userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *TaskController) PostTaskUnclaim() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		taskID := ctx.Param("id")
+		userID := ctx.GetString(KeyUserID)
+		err := c.taskLogic.Unclaim(ctx.Request.Context(), userID, ctx.GetString(KeyGroupID), taskID)
+		if err != nil {
+			log.Printf("Failed to unclaim task=%s for user=%s: %s\n", taskID, userID, err)
+		}
+
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
 func (c *TaskController) PostDebugNotifyDueToday() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		secret := ctx.GetHeader("Authorization")
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"errors"
+	internalerrors "github.com/dentych/taskeroo/internal/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"log"
+	"net/http"
+)
+
+func (c *AuthController) PostLoginTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token := ctx.PostForm("token")
+		next := safeRedirect(ctx.PostForm("next"))
+
+		userSession, err := c.authService.LoginWithTwoFactor(ctx.Request.Context(), token, ctx.PostForm("code"), clientInfo(ctx))
+		if err != nil {
+			switch {
+			case errors.Is(err, internalerrors.ErrTooManyAttempts):
+				renderTooManyAttempts(ctx, "pages/login-2fa", err, gin.H{"title": "Login", "token": token, "next": next})
+			case errors.Is(err, internalerrors.ErrInvalidTwoFactorCode):
+				HTML(ctx, http.StatusOK, "pages/login-2fa", gin.H{
+					"title": "Login",
+					"token": token,
+					"next":  next,
+					"error": "Koden er forkert eller allerede brugt",
+				})
+			case errors.Is(err, internalerrors.ErrInvalidTwoFactorLogin):
+				HTML(ctx, http.StatusOK, "pages/login", gin.H{
+					"title": "Login",
+					"next":  next,
+					"error": "Login er udløbet. Log ind igen.",
+				})
+			default:
+				log.Printf("Failed to log in with two-factor code: %s\n", err)
+				HTML(ctx, http.StatusInternalServerError, "pages/index", nil)
+			}
+			return
+		}
+
+		c.sessionCookie.Set(ctx, userSession.Token)
+		if next != "" {
+			ctx.Redirect(http.StatusFound, next)
+			return
+		}
+		ctx.Redirect(http.StatusFound, "/")
+	}
+}
+
+func (c *AuthController) GetTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c.renderTwoFactor(ctx, http.StatusOK, "")
+	}
+}
+
+func (c *AuthController) GetTwoFactorQRCode() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		setup, err := c.authService.GetTwoFactorSetup(ctx.Request.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get two-factor setup for user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusNotFound)
+			return
+		}
+
+		png, err := qrcode.Encode(setup.URI, qrcode.Medium, 256)
+		if err != nil {
+			log.Printf("Failed to create QR code for two-factor setup of user=%s: %s\n", userID, err)
+			ctx.Status(http.StatusInternalServerError)
+			return
+		}
+
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(http.StatusOK, "image/png", png)
+	}
+}
+
+func (c *AuthController) PostEnableTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		codes, err := c.authService.EnableTwoFactor(ctx.Request.Context(), userID, ctx.PostForm("code"))
+		if err != nil {
+			c.renderTwoFactorError(ctx, userID, err)
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/recovery-codes", gin.H{
+			"title":   "Gendannelseskoder",
+			"codes":   codes,
+			"success": "Totrinsbekræftelse er slået til.",
+		})
+	}
+}
+
+func (c *AuthController) PostRegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		codes, err := c.authService.RegenerateRecoveryCodes(ctx.Request.Context(), userID, ctx.PostForm("password"))
+		if err != nil {
+			c.renderTwoFactorError(ctx, userID, err)
+			return
+		}
+
+		HTML(ctx, http.StatusOK, "pages/recovery-codes", gin.H{
+			"title":   "Gendannelseskoder",
+			"codes":   codes,
+			"success": "Du har fået nye gendannelseskoder. De gamle virker ikke længere.",
+		})
+	}
+}
+
+func (c *AuthController) PostDisableTwoFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID := ctx.GetString(KeyUserID)
+		err := c.authService.DisableTwoFactor(ctx.Request.Context(), userID, ctx.PostForm("password"))
+		if err != nil {
+			c.renderTwoFactorError(ctx, userID, err)
+			return
+		}
+
+		c.renderAccount(ctx, http.StatusOK, "Totrinsbekræftelse er slået fra.", "")
+	}
+}
+
+func (c *AuthController) renderTwoFactorError(ctx *gin.Context, userID string, err error) {
+	var alert string
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, internalerrors.ErrInvalidTwoFactorCode):
+		alert = "Koden er forkert. Tjek at uret på din telefon går rigtigt, og prøv igen."
+	case errors.Is(err, internalerrors.ErrWrongPassword):
+		alert = "Password er forkert"
+	case errors.Is(err, internalerrors.ErrTwoFactorEnabled):
+		alert = "Totrinsbekræftelse er allerede slået til."
+	case errors.Is(err, internalerrors.ErrTwoFactorNotEnabled):
+		alert = "Totrinsbekræftelse er ikke slået til."
+	case errors.Is(err, internalerrors.ErrManagedUser):
+		status = http.StatusForbidden
+		alert = "Din konto styres af et andet medlem af gruppen."
+	default:
+		log.Printf("Failed to change two-factor authentication of user=%s: %s\n", userID, err)
+		status = http.StatusInternalServerError
+		alert = "Der skete en fejl. Prøv igen om lidt."
+	}
+	c.renderTwoFactor(ctx, status, alert)
+}
+
+// renderTwoFactor renders the page for enabling two-factor authentication, or for managing it if it is enabled.
+func (c *AuthController) renderTwoFactor(ctx *gin.Context, status int, alert string) {
+	userID := ctx.GetString(KeyUserID)
+	account, err := c.authService.GetAccount(ctx.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, internalerrors.ErrManagedUser) {
+			ctx.Redirect(http.StatusFound, "/profile")
+			return
+		}
+		log.Printf("Failed to get account of user=%s: %s\n", userID, err)
+		HTML(ctx, http.StatusInternalServerError, "pages/index", gin.H{
+			"title": "Taskeroo",
+			"alert": "Der skete en fejl. Prøv igen om lidt.",
+		})
+		return
+	}
+
+	obj := gin.H{
+		"title":   "Totrinsbekræftelse",
+		"account": account,
+		"error":   alert,
+	}
+	if !account.TwoFactorEnabled {
+		setup, err := c.authService.GetTwoFactorSetup(ctx.Request.Context(), userID)
+		if err != nil {
+			log.Printf("Failed to get two-factor setup for user=%s: %s\n", userID, err)
+			HTML(ctx, http.StatusInternalServerError, "pages/index", gin.H{
+				"title": "Taskeroo",
+				"alert": "Der skete en fejl. Prøv igen om lidt.",
+			})
+			return
+		}
+		obj["setup"] = setup
+	}
+	HTML(ctx, status, "pages/two-factor", obj)
+}
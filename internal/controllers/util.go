@@ -2,29 +2,91 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 const (
-	CookieKeyUserID  = "auth_userid"
-	CookieKeySession = "auth_session"
+	// CookieKeySession holds the signed token of the session. See SessionCookie.
+	CookieKeySession = "session"
+	// CookieKeyDeviceToken holds the token of a shared device in kiosk mode.
+	CookieKeyDeviceToken = "kiosk_token"
+	// CookieKeyCSRF holds a random value the CSRF token is derived from, for visitors who are not logged in.
+	CookieKeyCSRF = "csrf"
+	// CookieKeyOAuthLogin holds the token of a login at a provider like Google, until the provider sends the user
+	// back.
+	CookieKeyOAuthLogin = "oauth_login"
+	// CookieKeyPasskeyLogin holds the token of a login with a passkey, until the browser finishes it.
+	CookieKeyPasskeyLogin = "passkey_login"
 
-	KeyUserID  = "userID"
+	KeyUserID = "userID"
+	// KeySession is the hash of the session token, which identifies the session.
 	KeySession = "session"
+	// KeyGroupID is the current group of the session. It is empty if the user is not a member of any groups.
+	KeyGroupID = "groupID"
+	KeyGroups  = "groups"
+	// KeyDevice is the shared device making a request in kiosk mode.
+	KeyDevice = "device"
+	// KeyCSRFToken is the CSRF token which must be sent with forms, and is available to templates as csrfToken.
+	KeyCSRFToken = "csrfToken"
+	// KeyOAuthProviders are the providers users can log in with, and is available to templates as oauthProviders.
+	KeyOAuthProviders = "oauthProviders"
 )
 
 var (
 	Time31Days = 31 * 24 * time.Hour
+	// TimeDeviceToken is how long a device stays in kiosk mode, unless it is revoked.
+	TimeDeviceToken = 5 * 365 * 24 * time.Hour
+	// TimeOAuthLogin is how long the user has to log in at a provider like Google.
+	TimeOAuthLogin = 10 * time.Minute
+	// TimePasskeyLogin is how long the browser has to log in with a passkey.
+	TimePasskeyLogin = 5 * time.Minute
 )
 
 func HTML(ctx *gin.Context, status int, templateName string, obj gin.H) {
+	if obj == nil {
+		obj = gin.H{}
+	}
 	if value := ctx.GetString("userID"); value != "" {
 		obj["userID"] = value
 	}
+	if value := ctx.GetString(KeyCSRFToken); value != "" {
+		obj["csrfToken"] = value
+	}
+	if value := ctx.GetString(KeyGroupID); value != "" {
+		obj["currentGroupID"] = value
+	}
+	if value, ok := ctx.Get(KeyGroups); ok {
+		obj["groups"] = value
+	}
+	if value, ok := ctx.Get(KeyOAuthProviders); ok {
+		obj["oauthProviders"] = value
+	}
 	ctx.HTML(status, templateName, obj)
 }
 
+// redirectToLogin redirects to the login page. For page requests, the login page sends the user back to the
+// requested page afterwards.
+func redirectToLogin(ctx *gin.Context) {
+	if ctx.Request.Method != http.MethodGet {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(ctx.Request.URL.RequestURI()))
+}
+
+// safeRedirect returns the path if it is safe to redirect to after login, i.e. a path on this site. Otherwise it
+// returns an empty string.
+func safeRedirect(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return ""
+	}
+	return path
+}
+
 func clearCookies(ctx *gin.Context) {
-	ctx.SetCookie(CookieKeyUserID, "", -1, "", "", true, true)
-	ctx.SetCookie(CookieKeySession, "", -1, "", "", true, true)
+	ctx.SetCookie(CookieKeySession, "", -1, "/", "", true, true)
+	clearLegacyCookies(ctx)
 }
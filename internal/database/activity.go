@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ActivityRepo struct {
+	db *gorm.DB
+}
+
+// Activity is an entry in the append-only activity log of a group.
+type Activity struct {
+	ID      string `gorm:"primaryKey;"`
+	GroupID string `gorm:"not null;index:idx_activities_group_created,priority:1;"`
+	// ActorID is the user who did it, or nil when it was done by the system, e.g. a webhook.
+	ActorID *string
+	Action  string `gorm:"not null;"`
+	// SubjectID is the ID of the task or member the activity is about.
+	SubjectID *string
+	// Subject is the title of the task or name of the member at the time of the activity.
+	Subject string
+	// Changes is a JSON encoded list of the fields that were changed, with their values before and after.
+	Changes   string
+	CreatedAt time.Time `gorm:"not null;index:idx_activities_group_created,priority:2;"`
+}
+
+func NewActivityRepo(db *gorm.DB) *ActivityRepo {
+	return &ActivityRepo{db: db}
+}
+
+func (r *ActivityRepo) WithTx(tx *gorm.DB) *ActivityRepo {
+	return &ActivityRepo{db: tx}
+}
+
+func (r *ActivityRepo) Create(ctx context.Context, activity Activity) error {
+	return r.db.WithContext(ctx).Create(&activity).Error
+}
+
+// GetByActor returns the activities done by the user in all groups, oldest first.
+func (r *ActivityRepo) GetByActor(ctx context.Context, userID string) ([]Activity, error) {
+	var activities []Activity
+	err := r.db.WithContext(ctx).Where("actor_id = ?", userID).Order("created_at").Find(&activities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return activities, nil
+}
+
+// AnonymiseSubject replaces the name of the member in the activities about them.
+func (r *ActivityRepo) AnonymiseSubject(ctx context.Context, subjectID string, subject string) error {
+	return r.db.WithContext(ctx).Model(&Activity{}).
+		Where("subject_id = ?", subjectID).
+		Update("subject", subject).Error
+}
+
+// GetForGroup returns the newest activities of the group before the given time and ID, newest first. Activities
+// created at the same time are ordered by ID, so none are skipped when paging from one of them. With an empty ID, all
+// activities created at the given time are excluded.
+func (r *ActivityRepo) GetForGroup(ctx context.Context, groupID string, before time.Time, beforeID string, limit int) ([]Activity, error) {
+	var activities []Activity
+	err := r.db.WithContext(ctx).
+		Where("group_id = ? AND (created_at, id) < (?, ?)", groupID, before, beforeID).
+		Order("created_at desc, id desc").
+		Limit(limit).
+		Find(&activities).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return activities, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	CompletionPending  = "pending"
+	CompletionApproved = "approved"
+	CompletionRejected = "rejected"
+)
+
+type CompletionRepo struct {
+	db *gorm.DB
+}
+
+// Completion is a completion of a task which must be approved before it counts.
+type Completion struct {
+	ID        string `gorm:"primaryKey;"`
+	TaskID    string `gorm:"not null;index;"`
+	GroupID   string `gorm:"not null;index;"`
+	UserID    string `gorm:"not null;"`
+	Status    string `gorm:"not null;default:pending;"`
+	DecidedBy *string
+	DecidedAt *time.Time
+	CreatedAt time.Time
+}
+
+func NewCompletionRepo(db *gorm.DB) *CompletionRepo {
+	return &CompletionRepo{db: db}
+}
+
+func (r *CompletionRepo) WithTx(tx *gorm.DB) *CompletionRepo {
+	return &CompletionRepo{db: tx}
+}
+
+func (r *CompletionRepo) Create(ctx context.Context, completion Completion) error {
+	return r.db.WithContext(ctx).Create(&completion).Error
+}
+
+func (r *CompletionRepo) Get(ctx context.Context, completionID string) (*Completion, error) {
+	var completion Completion
+	err := r.db.WithContext(ctx).First(&completion, "id = ?", completionID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &completion, nil
+}
+
+// GetPendingForTask returns the completion of the task which awaits approval.
+func (r *CompletionRepo) GetPendingForTask(ctx context.Context, taskID string) (*Completion, error) {
+	var completion Completion
+	err := r.db.WithContext(ctx).First(&completion, "task_id = ? AND status = ?", taskID, CompletionPending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &completion, nil
+}
+
+func (r *CompletionRepo) GetPendingForGroup(ctx context.Context, groupID string) ([]Completion, error) {
+	var completions []Completion
+	err := r.db.WithContext(ctx).
+		Where("group_id = ? AND status = ?", groupID, CompletionPending).
+		Order("created_at").
+		Find(&completions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return completions, nil
+}
+
+// GetForUser returns the completions the user has made or decided on, oldest first.
+func (r *CompletionRepo) GetForUser(ctx context.Context, userID string) ([]Completion, error) {
+	var completions []Completion
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? OR decided_by = ?", userID, userID).
+		Order("created_at").
+		Find(&completions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return completions, nil
+}
+
+// Decide approves or rejects a completion. It returns gorm.ErrRecordNotFound if the completion is no longer pending.
+func (r *CompletionRepo) Decide(ctx context.Context, completionID string, status string, decidedBy string) error {
+	result := r.db.WithContext(ctx).Model(&Completion{}).
+		Where("id = ? AND status = ?", completionID, CompletionPending).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"decided_by": decidedBy,
+			"decided_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type DeviceRepo struct {
+	db *gorm.DB
+}
+
+// Device is a shared device, like a tablet on the fridge, which shows the tasks of a group in kiosk mode. It is
+// bound to the group rather than a user.
+type Device struct {
+	ID      string `gorm:"primaryKey;"`
+	GroupID string `gorm:"not null;index;"`
+	Name    string `gorm:"not null;"`
+	// HashedToken is the SHA-256 hash of the token stored on the device. Nil until the device has been set up.
+	HashedToken *string `gorm:"uniqueIndex;"`
+	// HashedSetupCode is the SHA-256 hash of the code in the setup link. It is removed when the device is set up, so
+	// the link only works once.
+	HashedSetupCode *string `gorm:"uniqueIndex;"`
+	SetupExpiresAt  *time.Time
+	CreatedBy       string `gorm:"not null;"`
+	LastSeenAt      *time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
+
+func NewDeviceRepo(db *gorm.DB) *DeviceRepo {
+	return &DeviceRepo{db: db}
+}
+
+func (r *DeviceRepo) Create(ctx context.Context, device Device) error {
+	return r.db.WithContext(ctx).Create(&device).Error
+}
+
+func (r *DeviceRepo) Get(ctx context.Context, deviceID string) (*Device, error) {
+	var device Device
+	err := r.db.WithContext(ctx).First(&device, "id = ?", deviceID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetActiveByHashedToken returns the device with the token, unless it has been revoked.
+func (r *DeviceRepo) GetActiveByHashedToken(ctx context.Context, hashedToken string) (*Device, error) {
+	var device Device
+	err := r.db.WithContext(ctx).First(&device, "hashed_token = ? AND revoked_at IS NULL", hashedToken).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetActiveForGroup returns the devices of the group which haven't been revoked.
+func (r *DeviceRepo) GetActiveForGroup(ctx context.Context, groupID string) ([]Device, error) {
+	var devices []Device
+	err := r.db.WithContext(ctx).
+		Where("group_id = ? AND revoked_at IS NULL", groupID).
+		Order("created_at").
+		Find(&devices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// SetUp stores the token of the device with the setup code, and removes the code so it can't be used again.
+// gorm.ErrRecordNotFound is returned if there is no such device, or its code has expired or been used.
+func (r *DeviceRepo) SetUp(ctx context.Context, hashedSetupCode string, hashedToken string, now time.Time) error {
+	result := r.db.WithContext(ctx).Model(&Device{}).
+		Where("hashed_setup_code = ? AND setup_expires_at > ? AND revoked_at IS NULL", hashedSetupCode, now).
+		Updates(map[string]interface{}{
+			"hashed_token":      hashedToken,
+			"hashed_setup_code": nil,
+			"setup_expires_at":  nil,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *DeviceRepo) SetLastSeenAt(ctx context.Context, deviceID string, lastSeenAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&Device{ID: deviceID}).Update("last_seen_at", lastSeenAt).Error
+}
+
+func (r *DeviceRepo) Revoke(ctx context.Context, deviceID string) error {
+	return r.db.WithContext(ctx).Model(&Device{ID: deviceID}).Update("revoked_at", time.Now()).Error
+}
@@ -11,17 +11,29 @@ type GroupRepo struct {
 }
 
 type Group struct {
-	ID          string    `gorm:"primaryKey;"`
-	Name        string    `gorm:"not null;"`
-	OwnerUserID string    `gorm:"not null;"`
-	CreatedAt   time.Time `gorm:"not null;default: current_timestamp;"`
-	DeletedAt   gorm.DeletedAt
+	ID          string `gorm:"primaryKey;"`
+	Name        string `gorm:"not null;"`
+	OwnerUserID string `gorm:"not null;"`
+	// Code is a short code other users can use to request to join the group. It is generated when first needed.
+	Code *string `gorm:"uniqueIndex;"`
+	// Timezone is the IANA name of the timezone the group lives in.
+	Timezone string `gorm:"not null;default:Europe/Copenhagen;"`
+	Language string `gorm:"not null;default:da;"`
+	// ReminderTime is the time of day, formatted as HH:MM, when the group is reminded about tasks due that day.
+	ReminderTime   string    `gorm:"not null;default:12:00;"`
+	LastRemindedAt time.Time `gorm:"not null;default: current_timestamp;"`
+	CreatedAt      time.Time `gorm:"not null;default: current_timestamp;"`
+	DeletedAt      gorm.DeletedAt
 }
 
 func NewGroupRepo(db *gorm.DB) *GroupRepo {
 	return &GroupRepo{db: db}
 }
 
+func (r *GroupRepo) WithTx(tx *gorm.DB) *GroupRepo {
+	return &GroupRepo{db: tx}
+}
+
 func (r *GroupRepo) Create(ctx context.Context, group Group) error {
 	return r.db.WithContext(ctx).Create(&group).Error
 }
@@ -36,6 +48,16 @@ func (r *GroupRepo) Get(ctx context.Context, groupID string) (*Group, error) {
 	return &group, nil
 }
 
+func (r *GroupRepo) GetByCode(ctx context.Context, code string) (*Group, error) {
+	var group Group
+	err := r.db.WithContext(ctx).First(&group, "code = ?", code).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
 func (r *GroupRepo) GetAll(ctx context.Context) ([]Group, error) {
 	var groups []Group
 	err := r.db.WithContext(ctx).Find(&groups).Error
@@ -45,3 +67,27 @@ func (r *GroupRepo) GetAll(ctx context.Context) ([]Group, error) {
 
 	return groups, nil
 }
+
+func (r *GroupRepo) SetOwner(ctx context.Context, groupID string, ownerUserID string) error {
+	return r.db.WithContext(ctx).Model(&Group{ID: groupID}).Update("owner_user_id", ownerUserID).Error
+}
+
+func (r *GroupRepo) SetCode(ctx context.Context, groupID string, code string) error {
+	return r.db.WithContext(ctx).Model(&Group{ID: groupID}).Update("code", code).Error
+}
+
+func (r *GroupRepo) Delete(ctx context.Context, groupID string) error {
+	return r.db.WithContext(ctx).Delete(&Group{ID: groupID}).Error
+}
+
+func (r *GroupRepo) UpdateSettings(ctx context.Context, groupID string, timezone string, language string, reminderTime string) error {
+	return r.db.WithContext(ctx).Model(&Group{ID: groupID}).Updates(map[string]interface{}{
+		"timezone":      timezone,
+		"language":      language,
+		"reminder_time": reminderTime,
+	}).Error
+}
+
+func (r *GroupRepo) SetLastRemindedAt(ctx context.Context, groupID string, lastRemindedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&Group{ID: groupID}).Update("last_reminded_at", lastRemindedAt).Error
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type InvitationRepo struct {
+	db *gorm.DB
+}
+
+type Invitation struct {
+	ID        string  `gorm:"primaryKey;"`
+	Token     string  `gorm:"not null;uniqueIndex;"`
+	GroupID   string  `gorm:"not null;index;"`
+	CreatedBy string  `gorm:"not null;"`
+	Email     *string `gorm:"index"`
+	MaxUses   int     `gorm:"not null;default: 0;"`
+	Uses      int     `gorm:"not null;default: 0;"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func NewInvitationRepo(db *gorm.DB) *InvitationRepo {
+	return &InvitationRepo{db: db}
+}
+
+func (r *InvitationRepo) WithTx(tx *gorm.DB) *InvitationRepo {
+	return &InvitationRepo{db: tx}
+}
+
+func (r *InvitationRepo) Create(ctx context.Context, invitation Invitation) error {
+	return r.db.WithContext(ctx).Create(&invitation).Error
+}
+
+func (r *InvitationRepo) Get(ctx context.Context, invitationID string) (*Invitation, error) {
+	var invitation Invitation
+	err := r.db.WithContext(ctx).First(&invitation, "id = ?", invitationID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+func (r *InvitationRepo) GetByToken(ctx context.Context, token string) (*Invitation, error) {
+	var invitation Invitation
+	err := r.db.WithContext(ctx).First(&invitation, "token = ?", token).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// GetActiveForGroup returns the invitations of a group which are neither revoked nor expired.
+func (r *InvitationRepo) GetActiveForGroup(ctx context.Context, groupID string) ([]Invitation, error) {
+	var invitations []Invitation
+	err := r.db.WithContext(ctx).
+		Where("group_id = ? AND revoked_at IS NULL AND expires_at > ?", groupID, time.Now()).
+		Order("created_at desc").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetActiveForEmail returns the invitations sent to an email which are neither revoked nor expired.
+func (r *InvitationRepo) GetActiveForEmail(ctx context.Context, email string) ([]Invitation, error) {
+	var invitations []Invitation
+	err := r.db.WithContext(ctx).
+		Where("lower(email) = lower(?) AND revoked_at IS NULL AND expires_at > ?", email, time.Now()).
+		Order("created_at desc").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Use counts a use of the invitation, if it is still valid at the given time. gorm.ErrRecordNotFound is returned if it
+// is revoked, expired or used up, so concurrent uses can't exceed the maximum.
+func (r *InvitationRepo) Use(ctx context.Context, invitationID string, now time.Time) error {
+	result := r.db.WithContext(ctx).Model(&Invitation{ID: invitationID}).
+		Where("revoked_at IS NULL AND expires_at > ? AND (max_uses = 0 OR uses < max_uses)", now).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *InvitationRepo) Revoke(ctx context.Context, invitationID string) error {
+	return r.db.WithContext(ctx).Model(&Invitation{ID: invitationID}).Update("revoked_at", time.Now()).Error
+}
+
+func (r *InvitationRepo) RevokeAllForGroup(ctx context.Context, groupID string) error {
+	return r.db.WithContext(ctx).Model(&Invitation{}).
+		Where("group_id = ? AND revoked_at IS NULL", groupID).
+		Update("revoked_at", time.Now()).Error
+}
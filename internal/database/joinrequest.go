@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	JoinRequestPending  = "pending"
+	JoinRequestApproved = "approved"
+	JoinRequestRejected = "rejected"
+)
+
+type JoinRequestRepo struct {
+	db *gorm.DB
+}
+
+type JoinRequest struct {
+	ID        string `gorm:"primaryKey;"`
+	GroupID   string `gorm:"not null;index;"`
+	UserID    string `gorm:"not null;index;"`
+	Status    string `gorm:"not null;default:pending;"`
+	DecidedBy *string
+	DecidedAt *time.Time
+	CreatedAt time.Time
+}
+
+func NewJoinRequestRepo(db *gorm.DB) *JoinRequestRepo {
+	return &JoinRequestRepo{db: db}
+}
+
+func (r *JoinRequestRepo) WithTx(tx *gorm.DB) *JoinRequestRepo {
+	return &JoinRequestRepo{db: tx}
+}
+
+func (r *JoinRequestRepo) Create(ctx context.Context, request JoinRequest) error {
+	return r.db.WithContext(ctx).Create(&request).Error
+}
+
+func (r *JoinRequestRepo) Get(ctx context.Context, requestID string) (*JoinRequest, error) {
+	var request JoinRequest
+	err := r.db.WithContext(ctx).First(&request, "id = ?", requestID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// GetPending returns the pending request of the user to join the group.
+func (r *JoinRequestRepo) GetPending(ctx context.Context, userID string, groupID string) (*JoinRequest, error) {
+	var request JoinRequest
+	err := r.db.WithContext(ctx).
+		First(&request, "user_id = ? AND group_id = ? AND status = ?", userID, groupID, JoinRequestPending).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func (r *JoinRequestRepo) GetPendingForGroup(ctx context.Context, groupID string) ([]JoinRequest, error) {
+	var requests []JoinRequest
+	err := r.db.WithContext(ctx).
+		Where("group_id = ? AND status = ?", groupID, JoinRequestPending).
+		Order("created_at").
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func (r *JoinRequestRepo) GetPendingForUser(ctx context.Context, userID string) ([]JoinRequest, error) {
+	var requests []JoinRequest
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, JoinRequestPending).
+		Order("created_at").
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// GetForUser returns all requests of the user, including those which have been decided.
+func (r *JoinRequestRepo) GetForUser(ctx context.Context, userID string) ([]JoinRequest, error) {
+	var requests []JoinRequest
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// Decide approves or rejects a request. It returns gorm.ErrRecordNotFound if the request is no longer pending.
+func (r *JoinRequestRepo) Decide(ctx context.Context, requestID string, status string, decidedBy string) error {
+	result := r.db.WithContext(ctx).Model(&JoinRequest{}).
+		Where("id = ? AND status = ?", requestID, JoinRequestPending).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"decided_by": decidedBy,
+			"decided_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RejectAllForUser rejects the pending requests of the user, e.g. when the user is deleted.
+func (r *JoinRequestRepo) RejectAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Model(&JoinRequest{}).
+		Where("user_id = ? AND status = ?", userID, JoinRequestPending).
+		Updates(map[string]interface{}{
+			"status":     JoinRequestRejected,
+			"decided_at": time.Now(),
+		}).Error
+}
+
+// RejectAllForGroup rejects the pending requests to join a group, e.g. when the group is deleted.
+func (r *JoinRequestRepo) RejectAllForGroup(ctx context.Context, groupID string, decidedBy string) error {
+	return r.db.WithContext(ctx).Model(&JoinRequest{}).
+		Where("group_id = ? AND status = ?", groupID, JoinRequestPending).
+		Updates(map[string]interface{}{
+			"status":     JoinRequestRejected,
+			"decided_by": decidedBy,
+			"decided_at": time.Now(),
+		}).Error
+}
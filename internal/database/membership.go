@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type MembershipRepo struct {
+	db *gorm.DB
+}
+
+type Membership struct {
+	UserID    string `gorm:"primaryKey"`
+	GroupID   string `gorm:"primaryKey;index"`
+	Role      string `gorm:"not null;default:member"`
+	CreatedAt time.Time
+}
+
+func NewMembershipRepo(db *gorm.DB) *MembershipRepo {
+	return &MembershipRepo{db: db}
+}
+
+func (r *MembershipRepo) WithTx(tx *gorm.DB) *MembershipRepo {
+	return &MembershipRepo{db: tx}
+}
+
+func (r *MembershipRepo) Create(ctx context.Context, userID string, groupID string, role string) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&Membership{
+		UserID:    userID,
+		GroupID:   groupID,
+		Role:      role,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+func (r *MembershipRepo) Get(ctx context.Context, userID string, groupID string) (*Membership, error) {
+	var membership Membership
+	err := r.db.WithContext(ctx).First(&membership, "user_id = ? AND group_id = ?", userID, groupID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+func (r *MembershipRepo) SetRole(ctx context.Context, userID string, groupID string, role string) error {
+	return r.db.WithContext(ctx).Model(&Membership{}).
+		Where("user_id = ? AND group_id = ?", userID, groupID).
+		Update("role", role).Error
+}
+
+// GetForGroup returns the memberships of the group, in the order the members joined.
+func (r *MembershipRepo) GetForGroup(ctx context.Context, groupID string) ([]Membership, error) {
+	var memberships []Membership
+	err := r.db.WithContext(ctx).Where("group_id = ?", groupID).Order("created_at").Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// GetForUser returns the memberships of the user, in the order the groups were joined.
+func (r *MembershipRepo) GetForUser(ctx context.Context, userID string) ([]Membership, error) {
+	var memberships []Membership
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&memberships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+func (r *MembershipRepo) Delete(ctx context.Context, userID string, groupID string) error {
+	return r.db.WithContext(ctx).Delete(&Membership{}, "user_id = ? AND group_id = ?", userID, groupID).Error
+}
+
+// GetGroupsForUser returns the groups the user is a member of, in the order they joined them.
+func (r *MembershipRepo) GetGroupsForUser(ctx context.Context, userID string) ([]Group, error) {
+	var groups []Group
+	err := r.db.WithContext(ctx).
+		Joins("JOIN memberships ON memberships.group_id = groups.id").
+		Where("memberships.user_id = ?", userID).
+		Order("memberships.created_at").
+		Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// MigrateUserGroups moves the group of each user from the users.group_id column, used before users could be members
+// of several groups, to memberships. The column is dropped afterwards.
+func MigrateUserGroups(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&User{}, "group_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Users from before groups had several members had every right, so they keep them as admins.
+		err := tx.Exec("INSERT INTO memberships (user_id, group_id, role, created_at) " +
+			"SELECT id, group_id, 'admin', created_at FROM users WHERE group_id IS NOT NULL " +
+			"ON CONFLICT DO NOTHING").Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&User{}, "group_id")
+	})
+}
+
+// MigrateMembershipRoles adds the role column, and makes the members from before roles existed admins, so they keep
+// the rights every member had then. It must run before AutoMigrate adds the column, as new members get the member role.
+func MigrateMembershipRoles(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Membership{}) || db.Migrator().HasColumn(&Membership{}, "role") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Migrator().AddColumn(&Membership{}, "Role")
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("UPDATE memberships SET role = 'admin'").Error
+	})
+}
@@ -27,6 +27,10 @@ func NewNotificationRepo(db *gorm.DB) *NotificationRepo {
 	return &NotificationRepo{db: db}
 }
 
+func (r *NotificationRepo) WithTx(tx *gorm.DB) *NotificationRepo {
+	return &NotificationRepo{db: tx}
+}
+
 func (r *NotificationRepo) CreateDiscord(ctx context.Context, discord GroupDiscord) error {
 	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "group_id"}},
@@ -54,6 +58,10 @@ func (r *NotificationRepo) CreateDiscordUsername(ctx context.Context, userID str
 	}).Error
 }
 
+func (r *NotificationRepo) DeleteDiscordUsername(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&DiscordUsername{}, "user_id = ?", userID).Error
+}
+
 func (r *NotificationRepo) GetDiscordUsername(ctx context.Context, userID string) (*DiscordUsername, error) {
 	var username *DiscordUsername
 	err := r.db.WithContext(ctx).First(&username, "user_id = ?", userID).Error
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type OAuthAccountRepo struct {
+	db *gorm.DB
+}
+
+// OAuthAccount links a user to their account at a provider like Google or GitHub, so they can log in with it.
+type OAuthAccount struct {
+	ID string `gorm:"primaryKey;"`
+	// UserID and Provider are unique together, so a user links at most one account of each provider.
+	UserID string `gorm:"not null;uniqueIndex:idx_oauth_accounts_user_provider;"`
+	// Provider is the ID of the provider, e.g. "google".
+	Provider string `gorm:"not null;uniqueIndex:idx_oauth_accounts_subject;uniqueIndex:idx_oauth_accounts_user_provider;"`
+	// Subject identifies the user at the provider. Unlike the email, it never changes.
+	Subject string `gorm:"not null;uniqueIndex:idx_oauth_accounts_subject;"`
+	// Email is the email the provider gave when the account was linked, so the user can tell accounts apart.
+	Email      string `gorm:"not null;"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func NewOAuthAccountRepo(db *gorm.DB) *OAuthAccountRepo {
+	return &OAuthAccountRepo{db: db}
+}
+
+func (r *OAuthAccountRepo) WithTx(tx *gorm.DB) *OAuthAccountRepo {
+	return &OAuthAccountRepo{db: tx}
+}
+
+func (r *OAuthAccountRepo) Create(ctx context.Context, account OAuthAccount) error {
+	return r.db.WithContext(ctx).Create(&account).Error
+}
+
+func (r *OAuthAccountRepo) GetBySubject(ctx context.Context, provider string, subject string) (*OAuthAccount, error) {
+	var account OAuthAccount
+	err := r.db.WithContext(ctx).First(&account, "provider = ? AND subject = ?", provider, subject).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func (r *OAuthAccountRepo) GetForUser(ctx context.Context, userID string) ([]OAuthAccount, error) {
+	var accounts []OAuthAccount
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func (r *OAuthAccountRepo) SetLastUsedAt(ctx context.Context, accountID string, usedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&OAuthAccount{ID: accountID}).Update("last_used_at", usedAt).Error
+}
+
+// Delete unlinks the account at the provider from the user. gorm.ErrRecordNotFound is returned if the user has no
+// account of the provider linked.
+func (r *OAuthAccountRepo) Delete(ctx context.Context, userID string, provider string) error {
+	result := r.db.WithContext(ctx).Delete(&OAuthAccount{}, "user_id = ? AND provider = ?", userID, provider)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *OAuthAccountRepo) DeleteAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&OAuthAccount{}, "user_id = ?", userID).Error
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ErrChallengeUsed is returned when a challenge for logging in with a passkey is used again.
+var ErrChallengeUsed = errors.New("passkey challenge has already been used")
+
+type PasskeyRepo struct {
+	db *gorm.DB
+}
+
+// Passkey is a WebAuthn credential a user can log in with instead of their password, like a fingerprint on their
+// phone.
+type Passkey struct {
+	ID     string `gorm:"primaryKey;"`
+	UserID string `gorm:"not null;index;"`
+	// CredentialID is the base64url encoded ID the authenticator gave the credential.
+	CredentialID string `gorm:"not null;uniqueIndex;"`
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte `gorm:"not null;"`
+	// SignCount is the number of signatures the authenticator reported at the last login, to detect cloned
+	// authenticators.
+	SignCount int64 `gorm:"not null;default: 0;"`
+	// Name is chosen by the user, so they can tell their passkeys apart.
+	Name       string `gorm:"not null;"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// PasskeyChallenge is a challenge given to the browser when registering a passkey or logging in with one. Each
+// challenge can only be used once, and only until it expires. Challenges for registering are stored when they are given
+// out, and deleted when used. Challenges for logging in are given out by anyone, so they are kept in a signed cookie
+// instead, and only stored when used, until they expire.
+type PasskeyChallenge struct {
+	Challenge string `gorm:"primaryKey;"`
+	// UserID is the user registering a passkey, and empty when logging in.
+	UserID    string
+	ExpiresAt time.Time `gorm:"not null;index;"`
+}
+
+func NewPasskeyRepo(db *gorm.DB) *PasskeyRepo {
+	return &PasskeyRepo{db: db}
+}
+
+func (r *PasskeyRepo) WithTx(tx *gorm.DB) *PasskeyRepo {
+	return &PasskeyRepo{db: tx}
+}
+
+func (r *PasskeyRepo) Create(ctx context.Context, passkey Passkey) error {
+	return r.db.WithContext(ctx).Create(&passkey).Error
+}
+
+func (r *PasskeyRepo) GetByCredentialID(ctx context.Context, credentialID string) (*Passkey, error) {
+	var passkey Passkey
+	err := r.db.WithContext(ctx).First(&passkey, "credential_id = ?", credentialID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+func (r *PasskeyRepo) GetForUser(ctx context.Context, userID string) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// SetUsed records a login with the passkey, and the sign count the authenticator reported.
+func (r *PasskeyRepo) SetUsed(ctx context.Context, passkeyID string, signCount int64, usedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&Passkey{ID: passkeyID}).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": usedAt,
+	}).Error
+}
+
+// Delete deletes the passkey of the user. gorm.ErrRecordNotFound is returned if the user has no such passkey.
+func (r *PasskeyRepo) Delete(ctx context.Context, userID string, passkeyID string) error {
+	result := r.db.WithContext(ctx).Delete(&Passkey{}, "id = ? AND user_id = ?", passkeyID, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *PasskeyRepo) DeleteAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&Passkey{}, "user_id = ?", userID).Error
+}
+
+func (r *PasskeyRepo) CreateChallenge(ctx context.Context, challenge PasskeyChallenge) error {
+	return r.db.WithContext(ctx).Create(&challenge).Error
+}
+
+// UseChallenge deletes the challenge, if it was given to the user and hasn't expired. gorm.ErrRecordNotFound is
+// returned otherwise, so a challenge can't be used twice, even by concurrent requests.
+func (r *PasskeyRepo) UseChallenge(ctx context.Context, challenge string, userID string, now time.Time) error {
+	result := r.db.WithContext(ctx).
+		Delete(&PasskeyChallenge{}, "challenge = ? AND user_id = ? AND expires_at > ?", challenge, userID, now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UseLoginChallenge stores the challenge of a login, so it can't be used again until it expires. ErrChallengeUsed is
+// returned if it has already been used, even by a concurrent request.
+func (r *PasskeyRepo) UseLoginChallenge(ctx context.Context, challenge string, expiresAt time.Time) error {
+	err := r.db.WithContext(ctx).Create(&PasskeyChallenge{Challenge: challenge, ExpiresAt: expiresAt}).Error
+	if isUniqueViolation(err) {
+		return ErrChallengeUsed
+	}
+	return err
+}
+
+// DeleteExpiredChallenges deletes the challenges which expired before the given time.
+func (r *PasskeyRepo) DeleteExpiredChallenges(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Delete(&PasskeyChallenge{}, "expires_at < ?", before).Error
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type PasswordResetRepo struct {
+	db *gorm.DB
+}
+
+// PasswordReset is a request to reset the password of a user, sent to the user's email. A reset can only be used
+// once, and only until it expires.
+type PasswordReset struct {
+	ID     string `gorm:"primaryKey;"`
+	UserID string `gorm:"not null;index;"`
+	// HashedToken is the SHA-256 hash of the token in the link sent to the user.
+	HashedToken string    `gorm:"not null;uniqueIndex;"`
+	ExpiresAt   time.Time `gorm:"not null;"`
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+func NewPasswordResetRepo(db *gorm.DB) *PasswordResetRepo {
+	return &PasswordResetRepo{db: db}
+}
+
+func (r *PasswordResetRepo) WithTx(tx *gorm.DB) *PasswordResetRepo {
+	return &PasswordResetRepo{db: tx}
+}
+
+func (r *PasswordResetRepo) Create(ctx context.Context, reset PasswordReset) error {
+	return r.db.WithContext(ctx).Create(&reset).Error
+}
+
+// GetValidByHashedToken returns the reset with the token, unless it has been used or has expired.
+func (r *PasswordResetRepo) GetValidByHashedToken(ctx context.Context, hashedToken string, now time.Time) (*PasswordReset, error) {
+	var reset PasswordReset
+	err := r.db.WithContext(ctx).
+		First(&reset, "hashed_token = ? AND used_at IS NULL AND expires_at > ?", hashedToken, now).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+// MarkUsed marks the reset as used. gorm.ErrRecordNotFound is returned if it has already been used, so a reset can't
+// be used twice, even by concurrent requests.
+func (r *PasswordResetRepo) MarkUsed(ctx context.Context, resetID string, now time.Time) error {
+	result := r.db.WithContext(ctx).
+		Model(&PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", resetID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// MarkAllUsedForUser marks all unused resets of the user as used, so older links stop working.
+func (r *PasswordResetRepo) MarkAllUsedForUser(ctx context.Context, userID string, now time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&PasswordReset{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", now).Error
+}
+
+func (r *PasswordResetRepo) DeleteAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&PasswordReset{}, "user_id = ?", userID).Error
+}
+
+// DeleteExpired deletes the resets which expired before the given time.
+func (r *PasswordResetRepo) DeleteExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&PasswordReset{}).Error
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
+
+type RecoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+// RecoveryCode lets a user with two-factor authentication log in without their authenticator app. Each code can only
+// be used once.
+type RecoveryCode struct {
+	ID     string `gorm:"primaryKey;"`
+	UserID string `gorm:"not null;index;"`
+	// HashedCode is the SHA-256 hash of the code, which is only shown to the user when it is created.
+	HashedCode string `gorm:"not null;"`
+	UsedAt     *time.Time
+	CreatedAt  time.Time
+}
+
+func NewRecoveryCodeRepo(db *gorm.DB) *RecoveryCodeRepo {
+	return &RecoveryCodeRepo{db: db}
+}
+
+func (r *RecoveryCodeRepo) WithTx(tx *gorm.DB) *RecoveryCodeRepo {
+	return &RecoveryCodeRepo{db: tx}
+}
+
+func (r *RecoveryCodeRepo) CreateAll(ctx context.Context, codes []RecoveryCode) error {
+	return r.db.WithContext(ctx).Create(&codes).Error
+}
+
+// CountUnused returns how many of the user's codes haven't been used.
+func (r *RecoveryCodeRepo) CountUnused(ctx context.Context, userID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Use marks the user's unused code with the hash as used. gorm.ErrRecordNotFound is returned if there is no such
+// code, so a code can't be used twice, even by concurrent requests.
+func (r *RecoveryCodeRepo) Use(ctx context.Context, userID string, hashedCode string, now time.Time) error {
+	result := r.db.WithContext(ctx).
+		Model(&RecoveryCode{}).
+		Where("user_id = ? AND hashed_code = ? AND used_at IS NULL", userID, hashedCode).
+		Update("used_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *RecoveryCodeRepo) DeleteAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&RecoveryCode{}, "user_id = ?", userID).Error
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"gorm.io/gorm"
 	"time"
 )
@@ -11,25 +12,105 @@ type SessionRepo struct {
 }
 
 type Session struct {
-	UserID    string `gorm:"primaryKey"`
-	Session   string `gorm:"primaryKey"`
-	CreatedAt time.Time
+	UserID string `gorm:"primaryKey"`
+	// HashedToken is the SHA-256 hash of the token in the session cookie, so the token itself is only known by the
+	// client. The column is named session, as it held the token itself before.
+	HashedToken string `gorm:"column:session;primaryKey;uniqueIndex:idx_sessions_session"`
+	// GroupID is the group the user currently works in, in this session.
+	GroupID *string
+	// ExpiresAt is moved forward when the session is used, so active sessions don't expire.
+	ExpiresAt time.Time `gorm:"index"`
+	// LastSeenAt is updated at most every few minutes, to avoid a write on every request.
+	LastSeenAt time.Time
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
 }
 
 func NewSessionRepo(db *gorm.DB) *SessionRepo {
 	return &SessionRepo{db: db}
 }
 
+func (r *SessionRepo) WithTx(tx *gorm.DB) *SessionRepo {
+	return &SessionRepo{db: tx}
+}
+
 func (r *SessionRepo) Create(ctx context.Context, session Session) error {
 	return r.db.WithContext(ctx).Create(&session).Error
 }
 
-func (r *SessionRepo) Get(ctx context.Context, userID string, session string) (*Session, error) {
+func (r *SessionRepo) GetByHashedToken(ctx context.Context, hashedToken string) (*Session, error) {
 	var output Session
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Where("session = ?", session).First(&output).Error
+	err := r.db.WithContext(ctx).Where("session = ?", hashedToken).First(&output).Error
 	if err != nil {
 		return nil, err
 	}
 
 	return &output, nil
 }
+
+// GetActiveForUser returns the sessions of the user which haven't expired, most recently used first.
+func (r *SessionRepo) GetActiveForUser(ctx context.Context, userID string) ([]Session, error) {
+	var sessions []Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *SessionRepo) SetGroup(ctx context.Context, hashedToken string, groupID *string) error {
+	return r.db.WithContext(ctx).Model(&Session{}).
+		Where("session = ?", hashedToken).
+		Update("group_id", groupID).Error
+}
+
+// Extend sets when the session was last seen, and moves its expiry forward.
+func (r *SessionRepo) Extend(ctx context.Context, hashedToken string, lastSeenAt time.Time, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).
+		Where("session = ?", hashedToken).
+		Updates(map[string]interface{}{
+			"last_seen_at": lastSeenAt,
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+func (r *SessionRepo) Delete(ctx context.Context, hashedToken string) error {
+	return r.db.WithContext(ctx).Delete(&Session{}, "session = ?", hashedToken).Error
+}
+
+func (r *SessionRepo) DeleteAllForUser(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Delete(&Session{}, "user_id = ?", userID).Error
+}
+
+// DeleteAllForUserExcept deletes all sessions of the user except the given one.
+func (r *SessionRepo) DeleteAllForUserExcept(ctx context.Context, userID string, hashedToken string) error {
+	return r.db.WithContext(ctx).Delete(&Session{}, "user_id = ? AND session <> ?", userID, hashedToken).Error
+}
+
+// DeleteExpired deletes the sessions which expired before the given time.
+func (r *SessionRepo) DeleteExpired(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Delete(&Session{}, "expires_at < ?", before).Error
+}
+
+// MigrateSessionExpiry gives sessions created before sessions could expire the same lifetime as new sessions.
+func MigrateSessionExpiry(db *gorm.DB, lifetime time.Duration) error {
+	return db.Model(&Session{}).
+		Where("expires_at IS NULL").
+		Updates(map[string]interface{}{
+			"expires_at":   gorm.Expr("created_at + ?::interval", fmt.Sprintf("%d seconds", int(lifetime.Seconds()))),
+			"last_seen_at": gorm.Expr("created_at"),
+		}).Error
+}
+
+// MigrateSessionTokens replaces the tokens of sessions created before only their hashes were stored with their
+// SHA-256 hashes. Tokens were UUIDs, while hashes are 64 hex characters.
+func MigrateSessionTokens(db *gorm.DB) error {
+	return db.Model(&Session{}).
+		Where("length(session) <> 64").
+		Update("session", gorm.Expr("encode(sha256(convert_to(session, 'UTF8')), 'hex')")).Error
+}
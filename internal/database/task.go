@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -14,21 +15,35 @@ type Task struct {
 	ID               string `gorm:"primaryKey;"`
 	Title            string `gorm:"not null;"`
 	Description      string
+	Category         string
 	GroupID          string  `gorm:"index"`
 	Assignee         *string `gorm:"index"`
 	RotatingAssignee bool    `gorm:"not null;default: false;"`
+	ClaimedBy        *string `gorm:"index"`
 	IntervalSize     int     `gorm:"not null;"`
 	IntervalUnit     string  `gorm:"not null;"`
-	NextDueDate      time.Time
-	CreatedAt        time.Time
-	UpdatedAt        time.Time
-	DeletedAt        gorm.DeletedAt
+	UsageCount       int     `gorm:"not null;default: 0;"`
+	// WebhookHashedToken is the SHA-256 hash of the token in the webhook URL. Nil when no webhook is enabled.
+	WebhookHashedToken *string `gorm:"column:webhook_token;uniqueIndex"`
+	WebhookSigned      bool    `gorm:"not null;default: false;"`
+	// WebhookEncryptedSecret is the key webhook requests are signed with, encrypted with the encryption key. It can't
+	// be hashed like the token, as it is needed to check the signatures.
+	WebhookEncryptedSecret *string `gorm:"column:webhook_secret"`
+	NextDueDate            time.Time
+	CreatedAt              time.Time
+	UpdatedAt              time.Time
+	DeletedAt              gorm.DeletedAt `gorm:"index"`
+	DeletedBy              *string
 }
 
 func NewTaskRepo(db *gorm.DB) *TaskRepo {
 	return &TaskRepo{db: db}
 }
 
+func (r *TaskRepo) WithTx(tx *gorm.DB) *TaskRepo {
+	return &TaskRepo{db: tx}
+}
+
 func (r *TaskRepo) Create(ctx context.Context, task Task) error {
 	return r.db.WithContext(ctx).Create(&task).Error
 }
@@ -43,8 +58,123 @@ func (r *TaskRepo) GetAllForGroup(ctx context.Context, groupID string) ([]Task,
 	return tasks, nil
 }
 
-func (r *TaskRepo) Delete(ctx context.Context, taskID string) error {
-	return r.db.WithContext(ctx).Delete(&Task{ID: taskID}).Error
+// GetAssignedToUser returns the tasks assigned to the user in all groups.
+func (r *TaskRepo) GetAssignedToUser(ctx context.Context, userID string) ([]Task, error) {
+	var tasks []Task
+	err := r.db.WithContext(ctx).Order("next_due_date").Find(&tasks, "assignee = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (r *TaskRepo) GetByWebhookHashedToken(ctx context.Context, hashedToken string) (*Task, error) {
+	var task Task
+	err := r.db.WithContext(ctx).First(&task, "webhook_token = ?", hashedToken).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (r *TaskRepo) SetWebhook(ctx context.Context, taskID string, hashedToken *string, encryptedSecret *string, signed bool) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Updates(map[string]interface{}{
+		"webhook_token":  hashedToken,
+		"webhook_secret": encryptedSecret,
+		"webhook_signed": signed,
+	}).Error
+}
+
+// MigrateWebhookTokens hashes the webhook tokens and encrypts the webhook secrets which were stored in plain text.
+// Plain tokens and secrets are hex encoded and shorter than hashes and encrypted secrets.
+func MigrateWebhookTokens(db *gorm.DB, encrypt func(string) (string, error)) error {
+	err := db.Model(&Task{}).Unscoped().
+		Where("webhook_token IS NOT NULL AND length(webhook_token) <> 64").
+		Update("webhook_token", gorm.Expr("encode(sha256(convert_to(webhook_token, 'UTF8')), 'hex')")).Error
+	if err != nil {
+		return err
+	}
+
+	var tasks []Task
+	err = db.Unscoped().Where("webhook_secret IS NOT NULL AND length(webhook_secret) <= 64").Find(&tasks).Error
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		encrypted, err := encrypt(*task.WebhookEncryptedSecret)
+		if err != nil {
+			return err
+		}
+		err = db.Model(&Task{ID: task.ID}).Unscoped().Update("webhook_secret", encrypted).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *TaskRepo) Delete(ctx context.Context, taskID string, deletedBy string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Task{ID: taskID}).Update("deleted_by", deletedBy).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&Task{ID: taskID}).Error
+	})
+}
+
+// DeleteAllForGroup moves all tasks of the group to the trash.
+func (r *TaskRepo) DeleteAllForGroup(ctx context.Context, groupID string, deletedBy string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Task{}).Where("group_id = ?", groupID).Update("deleted_by", deletedBy).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("group_id = ?", groupID).Delete(&Task{}).Error
+	})
+}
+
+func (r *TaskRepo) GetDeleted(ctx context.Context, taskID string) (*Task, error) {
+	var task Task
+	err := r.db.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL").First(&task, "id = ?", taskID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (r *TaskRepo) GetAllDeletedForGroup(ctx context.Context, groupID string) ([]Task, error) {
+	var tasks []Task
+	err := r.db.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at desc").
+		Find(&tasks, "group_id = ?", groupID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (r *TaskRepo) Restore(ctx context.Context, taskID string) error {
+	return r.db.WithContext(ctx).Unscoped().Model(&Task{ID: taskID}).Updates(map[string]interface{}{
+		"deleted_at": nil,
+		"deleted_by": nil,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+func (r *TaskRepo) Purge(ctx context.Context, taskID string) error {
+	return r.db.WithContext(ctx).Unscoped().Where("deleted_at IS NOT NULL").Delete(&Task{ID: taskID}).Error
+}
+
+// PurgeDeletedBefore permanently removes tasks that were soft-deleted before the given time, and returns how many
+// were removed.
+func (r *TaskRepo) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Unscoped().Where("deleted_at < ?", before).Delete(&Task{})
+	return result.RowsAffected, result.Error
 }
 
 func (r *TaskRepo) Get(ctx context.Context, taskID string) (*Task, error) {
@@ -57,18 +187,35 @@ func (r *TaskRepo) Get(ctx context.Context, taskID string) (*Task, error) {
 	return &task, nil
 }
 
+// GetForUpdate returns the task and locks it until the transaction ends, so it can't be changed in the meantime.
+func (r *TaskRepo) GetForUpdate(ctx context.Context, taskID string) (*Task, error) {
+	var task Task
+	err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&task, "id = ?", taskID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+// Update saves the edited fields of the task. A claim on the task is released if it is given an assignee.
 func (r *TaskRepo) Update(ctx context.Context, task Task) error {
-	return r.db.WithContext(ctx).Model(&task).Updates(map[string]interface{}{
+	updates := map[string]interface{}{
 		"title":             task.Title,
 		"description":       task.Description,
 		"group_id":          task.GroupID,
 		"assignee":          task.Assignee,
 		"rotating_assignee": task.RotatingAssignee,
+		"category":          task.Category,
 		"interval_size":     task.IntervalSize,
 		"interval_unit":     task.IntervalUnit,
 		"next_due_date":     task.NextDueDate,
 		"updated_at":        time.Now(),
-	}).Error
+	}
+	if task.Assignee != nil {
+		updates["claimed_by"] = nil
+	}
+	return r.db.WithContext(ctx).Model(&task).Updates(updates).Error
 }
 
 func (r *TaskRepo) UpdateCompleted(ctx context.Context, taskID string, updateTime time.Time, nextDueDate time.Time, assignee *string) error {
@@ -76,5 +223,68 @@ func (r *TaskRepo) UpdateCompleted(ctx context.Context, taskID string, updateTim
 		"updated_at":    updateTime,
 		"next_due_date": nextDueDate,
 		"assignee":      assignee,
+		"claimed_by":    nil,
+		"usage_count":   0,
 	}).Error
 }
+
+// IncrementUsage adds count to the usage counter of the task, and returns the updated task.
+func (r *TaskRepo) IncrementUsage(ctx context.Context, taskID string, count int) (*Task, error) {
+	var task Task
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Task{ID: taskID}).Update("usage_count", gorm.Expr("usage_count + ?", count)).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&task, "id = ?", taskID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (r *TaskRepo) SetNextDueDate(ctx context.Context, taskID string, nextDueDate time.Time) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Update("next_due_date", nextDueDate).Error
+}
+
+func (r *TaskRepo) SetAssignee(ctx context.Context, taskID string, assignee *string) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Updates(map[string]interface{}{
+		"assignee":   assignee,
+		"claimed_by": nil,
+	}).Error
+}
+
+// SetRotatingAssignee makes the task rotate between the members of the group, starting with the assignee.
+func (r *TaskRepo) SetRotatingAssignee(ctx context.Context, taskID string, assignee *string) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Updates(map[string]interface{}{
+		"assignee":          assignee,
+		"rotating_assignee": true,
+		"claimed_by":        nil,
+	}).Error
+}
+
+func (r *TaskRepo) SetCategory(ctx context.Context, taskID string, category string) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Update("category", category).Error
+}
+
+func (r *TaskRepo) SetClaimedBy(ctx context.Context, taskID string, userID *string) error {
+	return r.db.WithContext(ctx).Model(&Task{ID: taskID}).Update("claimed_by", userID).Error
+}
+
+// Claim sets the task as claimed by the user, if it is neither claimed nor assigned. gorm.ErrRecordNotFound is
+// returned otherwise, so only one of several members claiming the task at once gets it.
+func (r *TaskRepo) Claim(ctx context.Context, taskID string, userID string) error {
+	result := r.db.WithContext(ctx).Model(&Task{ID: taskID}).
+		Where("claimed_by IS NULL AND assignee IS NULL").
+		Update("claimed_by", userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
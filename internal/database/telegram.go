@@ -27,6 +27,10 @@ func NewTelegramRepo(db *gorm.DB) *TelegramRepo {
 	return &TelegramRepo{db: db}
 }
 
+func (r *TelegramRepo) WithTx(tx *gorm.DB) *TelegramRepo {
+	return &TelegramRepo{db: tx}
+}
+
 func (r *TelegramRepo) Create(ctx context.Context, telegram NewTelegram) error {
 	return r.db.WithContext(ctx).Create(&Telegram{
 		ID:             telegram.ID,
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// Transactor runs work across repositories in a single database transaction. Repositories take part in the
+// transaction by calling WithTx with the transaction handle.
+type Transactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+func (t *Transactor) Transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return t.db.WithContext(ctx).Transaction(fn)
+}
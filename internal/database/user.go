@@ -2,28 +2,57 @@ package database
 
 import (
 	"context"
+	"errors"
+	"github.com/jackc/pgconn"
 	"gorm.io/gorm"
 	"time"
 )
 
+// ErrEmailExists is returned when a user is given an email another user already has.
+var ErrEmailExists = errors.New("email belongs to another user")
+
 type UserRepo struct {
 	db *gorm.DB
 }
 
 type User struct {
-	ID             string `gorm:"primaryKey;"`
-	Email          string `gorm:"uniqueIndex;"`
+	ID string `gorm:"primaryKey;"`
+	// Email is empty for managed users, so only emails which are set must be unique.
+	Email          string `gorm:"uniqueIndex:idx_users_email_set,where:email <> '';"`
 	Name           string
 	HashedPassword string `gorm:"not null;"`
-	GroupID        *string
-	CreatedAt      time.Time
-	LastLogin      time.Time
+	// ManagedBy is the user who created a managed account, e.g. for a child. Managed users have no email or password,
+	// but may log in with a PIN.
+	ManagedBy *string `gorm:"index"`
+	HashedPIN string
+	// RequiresApproval is true if the completions of a managed user must be approved before they count.
+	RequiresApproval bool `gorm:"not null;default: false;"`
+	// EmailVerifiedAt is nil until the user has followed the link in the verification email.
+	EmailVerifiedAt *time.Time
+	// VerificationSentAt is when the last verification email was sent, to limit how often it can be resent.
+	VerificationSentAt *time.Time
+	// AnonymisedAt is set when the user deleted their account. The row is kept, so the activity log still refers to
+	// a user, but the name, email and password are removed.
+	AnonymisedAt *time.Time
+	// TOTPSecret is the encrypted secret shared with the user's authenticator app. It is set when the user starts
+	// enabling two-factor authentication, but only used once TOTPEnabledAt is set.
+	TOTPSecret string
+	// TOTPEnabledAt is set when the user has entered a code from the authenticator app, and must do so when logging in.
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last code used, so a code can't be used twice.
+	TOTPLastStep int64 `gorm:"not null;default: 0;"`
+	CreatedAt    time.Time
+	LastLogin    time.Time
 }
 
 func NewUserRepo(db *gorm.DB) *UserRepo {
 	return &UserRepo{db: db}
 }
 
+func (r *UserRepo) WithTx(tx *gorm.DB) *UserRepo {
+	return &UserRepo{db: tx}
+}
+
 func (r *UserRepo) Create(ctx context.Context, user User) error {
 	return r.db.WithContext(ctx).Create(&user).Error
 }
@@ -48,13 +77,173 @@ func (r *UserRepo) GetByEmail(ctx context.Context, email string) (*User, error)
 	return &user, nil
 }
 
-func (r *UserRepo) SetGroup(ctx context.Context, userID string, groupID *string) error {
-	return r.db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("group_id", groupID).Error
+// GetMember returns the user if they are a member of the group, and gorm.ErrRecordNotFound otherwise.
+func (r *UserRepo) GetMember(ctx context.Context, userID string, groupID string) (*User, error) {
+	var user User
+	err := r.db.WithContext(ctx).
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.group_id = ?", groupID).
+		First(&user, "users.id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetManagedByGroup returns the managed members of the group.
+func (r *UserRepo) GetManagedByGroup(ctx context.Context, groupID string) ([]User, error) {
+	var users []User
+	err := r.db.WithContext(ctx).
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.group_id = ? AND users.managed_by IS NOT NULL", groupID).
+		Order("memberships.created_at").
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *UserRepo) UpdateManaged(ctx context.Context, userID string, name string, requiresApproval bool) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Updates(map[string]interface{}{
+		"name":              name,
+		"requires_approval": requiresApproval,
+	}).Error
+}
+
+func (r *UserRepo) SetName(ctx context.Context, userID string, name string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("name", name).Error
+}
+
+// SetEmail changes the email of the user, which must then be verified again. ErrEmailExists is returned if another
+// user has the email, which can happen even if it was checked first, when two users take the same email at once.
+func (r *UserRepo) SetEmail(ctx context.Context, userID string, email string) error {
+	err := r.db.WithContext(ctx).Model(&User{ID: userID}).Updates(map[string]interface{}{
+		"email":             email,
+		"email_verified_at": nil,
+	}).Error
+	if isUniqueViolation(err) {
+		return ErrEmailExists
+	}
+	return err
+}
+
+func (r *UserRepo) SetPassword(ctx context.Context, userID string, hashedPassword string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("hashed_password", hashedPassword).Error
 }
 
+func (r *UserRepo) SetEmailVerifiedAt(ctx context.Context, userID string, verifiedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("email_verified_at", verifiedAt).Error
+}
+
+func (r *UserRepo) SetVerificationSentAt(ctx context.Context, userID string, sentAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("verification_sent_at", sentAt).Error
+}
+
+// Anonymise removes the personal data of the user, and gives them the given name.
+func (r *UserRepo) Anonymise(ctx context.Context, userID string, name string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Updates(map[string]interface{}{
+		"name":                 name,
+		"email":                "",
+		"hashed_password":      "",
+		"hashed_pin":           "",
+		"email_verified_at":    nil,
+		"verification_sent_at": nil,
+		"totp_secret":          "",
+		"totp_enabled_at":      nil,
+		"anonymised_at":        time.Now(),
+	}).Error
+}
+
+// SetTOTPSecret sets the encrypted secret of a user who is about to enable two-factor authentication.
+func (r *UserRepo) SetTOTPSecret(ctx context.Context, userID string, encryptedSecret string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("totp_secret", encryptedSecret).Error
+}
+
+// EnableTOTP enables two-factor authentication with the secret set by SetTOTPSecret. The code the user entered
+// belongs to the given time step, which can't be used again.
+func (r *UserRepo) EnableTOTP(ctx context.Context, userID string, enabledAt time.Time, step int64) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Updates(map[string]interface{}{
+		"totp_enabled_at": enabledAt,
+		"totp_last_step":  step,
+	}).Error
+}
+
+// UseTOTPStep records that a code from the time step was used. gorm.ErrRecordNotFound is returned if a code from the
+// step or a later one has already been used, so a code can't be used twice, even by concurrent requests.
+func (r *UserRepo) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	result := r.db.WithContext(ctx).
+		Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DisableTOTP disables two-factor authentication and removes the secret.
+func (r *UserRepo) DisableTOTP(ctx context.Context, userID string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Updates(map[string]interface{}{
+		"totp_secret":     "",
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}).Error
+}
+
+func (r *UserRepo) SetPIN(ctx context.Context, userID string, hashedPIN string) error {
+	return r.db.WithContext(ctx).Model(&User{ID: userID}).Update("hashed_pin", hashedPIN).Error
+}
+
+// MigrateUserEmailIndex drops the index which made every email unique, including the empty emails of managed users.
+func MigrateUserEmailIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&User{}, "idx_users_email") {
+		return nil
+	}
+
+	return db.Migrator().DropIndex(&User{}, "idx_users_email")
+}
+
+// MigrateEmailVerification adds the email_verified_at column, and marks the users who registered before emails were
+// verified as verified. It must run before AutoMigrate adds the column, as new users are unverified.
+func MigrateEmailVerification(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&User{}) || db.Migrator().HasColumn(&User{}, "email_verified_at") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Migrator().AddColumn(&User{}, "EmailVerifiedAt")
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&User{}).
+			Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error
+	})
+}
+
+// GetByGroup returns the members of the group, in the order they joined it.
 func (r *UserRepo) GetByGroup(ctx context.Context, groupID string) ([]User, error) {
 	var users []User
-	err := r.db.WithContext(ctx).Find(&users, "group_id = ?", groupID).Error
+	err := r.db.WithContext(ctx).
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.group_id = ?", groupID).
+		Order("memberships.created_at").
+		Find(&users).Error
 	return users, err
 
 }
+
+// isUniqueViolation tells whether the error is from a unique index, i.e. the row would have had the same value as
+// another row in a unique column.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
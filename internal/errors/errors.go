@@ -1,10 +1,99 @@
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 var (
 	ErrInvalidEmailOrPassword = fmt.Errorf("invalid email or password")
 	ErrUserNotInGroup         = fmt.Errorf("user is not in a group")
 	ErrUserNotMemberOfGroup   = fmt.Errorf("user is not a member of the group")
 	ErrUserNotOwner           = fmt.Errorf("user it not owner of group")
+	ErrMissingPermission      = fmt.Errorf("user does not have permission to do this in the group")
+	ErrInvalidRole            = fmt.Errorf("invalid role")
+	ErrOwnerCannotLeave       = fmt.Errorf("the owner must transfer ownership before leaving the group")
+	ErrInvalidTaskHandover    = fmt.Errorf("invalid handling of tasks assigned to departing member")
+	ErrInvalidTimezone        = fmt.Errorf("invalid timezone")
+	ErrInvalidLanguage        = fmt.Errorf("invalid language")
+	ErrInvalidReminderTime    = fmt.Errorf("invalid reminder time")
+	ErrTaskAlreadyAssigned    = fmt.Errorf("task is already assigned to a member")
+	ErrTaskAlreadyClaimed     = fmt.Errorf("task is already claimed by a member")
+	ErrTaskNotClaimedByUser   = fmt.Errorf("task is not claimed by user")
+	ErrNoTasksSelected        = fmt.Errorf("no tasks selected")
+	ErrInvalidBulkAction      = fmt.Errorf("invalid bulk action")
+	ErrTaskNotUsageBased      = fmt.Errorf("task is not usage based")
+	ErrInvalidSignature       = fmt.Errorf("invalid signature")
+	ErrUserAlreadyInGroup     = fmt.Errorf("user is already a member of the group")
+	ErrInvitationNotValid     = fmt.Errorf("invitation is no longer valid")
+	ErrInvalidGroupCode       = fmt.Errorf("no group has the given code")
+	ErrJoinRequestExists      = fmt.Errorf("user has already requested to join the group")
+	ErrJoinRequestNotFound    = fmt.Errorf("join request not found")
+	ErrManagedUser            = fmt.Errorf("managed users can't do this")
+	ErrNotManagedUser         = fmt.Errorf("user is not managed")
+	ErrInvalidPIN             = fmt.Errorf("PIN must be 4 to 8 digits")
+	ErrInvalidCodeNameOrPIN   = fmt.Errorf("invalid group code, name or PIN")
+	ErrCompletionPending      = fmt.Errorf("task already has a completion awaiting approval")
+	ErrCompletionNotFound     = fmt.Errorf("completion not found")
+	ErrInvalidDeviceToken     = fmt.Errorf("invalid or revoked device token")
+	ErrDeviceNotFound         = fmt.Errorf("device not found")
+	ErrInvalidSetupCode       = fmt.Errorf("invalid, used or expired device setup code")
+	ErrSessionNotFound        = fmt.Errorf("session not found")
+	ErrInvalidResetToken      = fmt.Errorf("password reset link is invalid, used or expired")
+	ErrEmptyPassword          = fmt.Errorf("password must not be empty")
+	ErrEmailNotVerified       = fmt.Errorf("the user must verify their email first")
+	ErrEmailAlreadyVerified   = fmt.Errorf("email is already verified")
+	ErrInvalidVerification    = fmt.Errorf("email verification link is invalid or expired")
+	ErrVerificationRateLimit  = fmt.Errorf("verification email was sent recently")
+	ErrEmptyName              = fmt.Errorf("name must not be empty")
+	ErrInvalidEmail           = fmt.Errorf("invalid email")
+	ErrEmailTaken             = fmt.Errorf("email is used by another user")
+	ErrWrongPassword          = fmt.Errorf("wrong password")
+	ErrOwnerCannotDelete      = fmt.Errorf("the owner must transfer ownership of groups with other members before deleting their account")
+	ErrTooManyAttempts        = fmt.Errorf("too many failed login attempts")
+	ErrTwoFactorRequired      = fmt.Errorf("a code from the authenticator app is required to log in")
+	ErrInvalidTwoFactorLogin  = fmt.Errorf("two-factor login is invalid or expired")
+	ErrInvalidTwoFactorCode   = fmt.Errorf("invalid authenticator or recovery code")
+	ErrTwoFactorEnabled       = fmt.Errorf("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled    = fmt.Errorf("two-factor authentication is not enabled")
+	ErrInvalidPasskey         = fmt.Errorf("passkey response is invalid or expired")
+	ErrPasskeyExists          = fmt.Errorf("passkey is already registered")
+	ErrPasskeyNotFound        = fmt.Errorf("passkey not found")
+	ErrUnknownOAuthProvider   = fmt.Errorf("unknown login provider")
+	ErrInvalidOAuthLogin      = fmt.Errorf("login with provider is invalid or expired")
+	ErrOAuthEmailNotVerified  = fmt.Errorf("the provider has not verified the email")
+	ErrOAuthNoAccount         = fmt.Errorf("no account has the email from the provider")
+	ErrOAuthAccountLinked     = fmt.Errorf("the account at the provider is linked to another user")
+	ErrOAuthProviderLinked    = fmt.Errorf("an account at the provider is already linked")
+	ErrOAuthAccountNotFound   = fmt.Errorf("linked account not found")
 )
+
+// TooManyAttemptsError is returned when logging in is blocked after too many failed attempts. It matches
+// ErrTooManyAttempts with errors.Is.
+type TooManyAttemptsError struct {
+	// RetryAfter is how long until logging in is allowed again.
+	RetryAfter time.Duration
+}
+
+func (e TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// TwoFactorRequiredError is returned when the password was right, but the user has two-factor authentication enabled
+// and must also enter a code. It matches ErrTwoFactorRequired with errors.Is.
+type TwoFactorRequiredError struct {
+	// Token identifies the login for the second step, without being a session.
+	Token string
+}
+
+func (e TwoFactorRequiredError) Error() string {
+	return ErrTwoFactorRequired.Error()
+}
+
+func (e TwoFactorRequiredError) Is(target error) bool {
+	return target == ErrTwoFactorRequired
+}
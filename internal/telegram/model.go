@@ -5,8 +5,9 @@ type UpdateResponse struct {
 }
 
 type Update struct {
-	UpdateID int      `json:"update_id"`
-	Message  *Message `json:"message"`
+	UpdateID      int            `json:"update_id"`
+	Message       *Message       `json:"message"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
 }
 
 type Message struct {
@@ -23,7 +24,28 @@ type From struct {
 	Username  string `json:"username"`
 }
 
+type CallbackQuery struct {
+	ID   string `json:"id"`
+	From From   `json:"from"`
+	Data string `json:"data"`
+}
+
 type SendMessageParameters struct {
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
+	ChatID      string                `json:"chat_id"`
+	Text        string                `json:"text"`
+	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type AnswerCallbackQueryParameters struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
 }
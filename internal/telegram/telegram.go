@@ -18,12 +18,18 @@ import (
 
 const telegramUrl = "https://api.telegram.org"
 
+// CallbackHandler handles a press on an inline button. The userID is the Taskeroo user connected to the Telegram
+// account that pressed the button, and the returned text is shown to them as a short notice.
+type CallbackHandler func(ctx context.Context, userID string, payload string) (string, error)
+
 type Telegram struct {
 	repo    *database.TelegramRepo
 	client  *http.Client
 	token   string
 	baseUrl string
 
+	callbackHandlers map[string]CallbackHandler
+
 	lastID  int
 	context context.Context
 }
@@ -32,7 +38,18 @@ func NewTelegram(repo *database.TelegramRepo, token string) *Telegram {
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	return &Telegram{repo: repo, client: client, token: token, baseUrl: fmt.Sprintf("%s/bot%s", telegramUrl, token)}
+	return &Telegram{
+		repo:             repo,
+		client:           client,
+		token:            token,
+		baseUrl:          fmt.Sprintf("%s/bot%s", telegramUrl, token),
+		callbackHandlers: map[string]CallbackHandler{},
+	}
+}
+
+// HandleCallback registers a handler for inline buttons whose callback data is "<action>:<payload>".
+func (t *Telegram) HandleCallback(action string, handler CallbackHandler) {
+	t.callbackHandlers[action] = handler
 }
 
 func (t *Telegram) Start() error {
@@ -95,20 +112,23 @@ func (t *Telegram) handleCommand(msg Message) error {
 }
 
 func (t *Telegram) sendMessage(telegramUserID int, text string) error {
-	body := SendMessageParameters{
+	return t.post("sendMessage", SendMessageParameters{
 		ChatID: strconv.Itoa(telegramUserID),
 		Text:   text,
-	}
-	formatted, err := json.Marshal(&body)
+	})
+}
+
+func (t *Telegram) post(method string, body interface{}) error {
+	formatted, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
-	resp, err := t.client.Post(fmt.Sprintf("%s/sendMessage", t.baseUrl), "application/json", bytes.NewReader(formatted))
+	resp, err := t.client.Post(fmt.Sprintf("%s/%s", t.baseUrl, method), "application/json", bytes.NewReader(formatted))
 	if err != nil {
 		return err
 	}
 	if resp.StatusCode >= 300 {
-		log.Printf("Telegram send message returned non OK status code: %d\n", resp.StatusCode)
+		log.Printf("Telegram %s returned non OK status code: %d\n", method, resp.StatusCode)
 		return nil
 	}
 	return nil
@@ -119,6 +139,10 @@ func (t *Telegram) handleUpdate(update Update) error {
 		t.lastID = update.UpdateID
 	}
 
+	if update.CallbackQuery != nil {
+		return t.handleCallbackQuery(*update.CallbackQuery)
+	}
+
 	if update.Message == nil {
 		return nil
 	}
@@ -155,6 +179,52 @@ func (t *Telegram) handleConnect(msg Message) error {
 	return nil
 }
 
+func (t *Telegram) handleCallbackQuery(query CallbackQuery) error {
+	parts := strings.SplitN(query.Data, ":", 2)
+	action, payload := parts[0], ""
+	if len(parts) == 2 {
+		payload = parts[1]
+	}
+	handler, ok := t.callbackHandlers[action]
+	if !ok {
+		log.Printf("Failed to handle callback query, as the action is unknown. Data was: %s\n", query.Data)
+		return t.answerCallbackQuery(query.ID, "Handlingen er ikke længere understøttet.")
+	}
+
+	tele, err := t.repo.GetByTelegramUserID(t.context, query.From.ID)
+	if err != nil || tele.UserID == nil {
+		return t.answerCallbackQuery(query.ID, "Din Telegram konto er ikke forbundet til Taskeroo. Skriv /connect.")
+	}
+
+	text, err := handler(t.context, *tele.UserID, payload)
+	if err != nil {
+		log.Printf("Failed to handle callback query with action=%s for user=%s: %s\n", action, *tele.UserID, err)
+		return t.answerCallbackQuery(query.ID, "Der skete en fejl. Prøv igen om lidt.")
+	}
+
+	return t.answerCallbackQuery(query.ID, text)
+}
+
+func (t *Telegram) answerCallbackQuery(callbackQueryID string, text string) error {
+	return t.post("answerCallbackQuery", AnswerCallbackQueryParameters{
+		CallbackQueryID: callbackQueryID,
+		Text:            text,
+	})
+}
+
 func (t *Telegram) SendMessage(ctx context.Context, telegramUserID int, msg string) error {
 	return t.sendMessage(telegramUserID, msg)
 }
+
+// SendMessageWithButtons sends a message with one row of inline buttons per entry in buttons.
+func (t *Telegram) SendMessageWithButtons(ctx context.Context, telegramUserID int, msg string, buttons []InlineKeyboardButton) error {
+	var keyboard [][]InlineKeyboardButton
+	for _, button := range buttons {
+		keyboard = append(keyboard, []InlineKeyboardButton{button})
+	}
+	return t.post("sendMessage", SendMessageParameters{
+		ChatID:      strconv.Itoa(telegramUserID),
+		Text:        msg,
+		ReplyMarkup: &InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+}
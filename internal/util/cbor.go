@@ -0,0 +1,120 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// cborMaxDepth limits how deeply CBOR values may be nested, so malicious input can't exhaust the stack.
+const cborMaxDepth = 16
+
+// DecodeCBOR decodes the first CBOR value in data, as used by WebAuthn, and returns the bytes after it. Integers are
+// returned as int64, byte strings as []byte, text strings as string, arrays as []interface{} and maps as
+// map[interface{}]interface{}. Tags, floats and indefinite lengths are not supported, as WebAuthn doesn't use them.
+func DecodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBOR(data, 0)
+}
+
+func decodeCBOR(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		}
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	var argument uint64
+	switch {
+	case info < 24:
+		argument = uint64(info)
+	case info == 24 && len(data) >= 1:
+		argument, data = uint64(data[0]), data[1:]
+	case info == 25 && len(data) >= 2:
+		argument, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26 && len(data) >= 4:
+		argument, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27 && len(data) >= 8:
+		argument, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported or truncated argument %d", info)
+	}
+
+	switch major {
+	case 0, 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer out of range")
+		}
+		if major == 1 {
+			return -1 - int64(argument), data, nil
+		}
+		return int64(argument), data, nil
+	case 2, 3:
+		if argument > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: string longer than data")
+		}
+		value := data[:argument]
+		if major == 3 {
+			return string(value), data[argument:], nil
+		}
+		return append([]byte(nil), value...), data[argument:], nil
+	case 4:
+		// Every value takes at least one byte, which bounds the allocation.
+		if argument > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: array longer than data")
+		}
+		array := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			var err error
+			item, data, err = decodeCBOR(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			array = append(array, item)
+		}
+		return array, data, nil
+	case 5:
+		if argument > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: map longer than data")
+		}
+		m := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			var err error
+			key, data, err = decodeCBOR(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key %T", key)
+			}
+			value, data, err = decodeCBOR(data, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	}
+
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
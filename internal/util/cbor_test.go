@@ -0,0 +1,72 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949, appendix A.
+	tests := map[string]interface{}{
+		"00":                 int64(0),
+		"17":                 int64(23),
+		"1818":               int64(24),
+		"1903e8":             int64(1000),
+		"1a000f4240":         int64(1000000),
+		"20":                 int64(-1),
+		"3903e7":             int64(-1000),
+		"4401020304":         []byte{1, 2, 3, 4},
+		"6449455446":         "IETF",
+		"f4":                 false,
+		"f5":                 true,
+		"f6":                 nil,
+		"83010203":           []interface{}{int64(1), int64(2), int64(3)},
+		"a201020304":         map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)},
+		"a26161016162820203": map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+	}
+	for input, expected := range tests {
+		data, _ := hex.DecodeString(input)
+		actual, rest, err := DecodeCBOR(data)
+		if err != nil {
+			t.Errorf("Expected no error for %s, got %s", input, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("Expected all of %s to be decoded, got %x left", input, rest)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %#v for %s, got %#v", expected, input, actual)
+		}
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	_, rest, err := DecodeCBOR([]byte{0x01, 0x02, 0x03})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if !bytes.Equal(rest, []byte{0x02, 0x03}) {
+		t.Errorf("Expected the bytes after the first value, got %x", rest)
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		// Truncated argument, string, array and map.
+		"19", "44010203", "830102", "a201",
+		// Float, tag and indefinite length string.
+		"f93c00", "c074", "5f",
+		// Map with a byte string key.
+		"a14101f6",
+		// Arrays nested too deeply.
+		"81818181818181818181818181818181818100",
+	} {
+		data, _ := hex.DecodeString(input)
+		if _, _, err := DecodeCBOR(data); err == nil {
+			t.Errorf("Expected error for %s", input)
+		}
+	}
+}
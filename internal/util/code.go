@@ -0,0 +1,53 @@
+package util
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+// codeAlphabet leaves out characters which are easily confused, like 0 and O or 1 and I.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const codeLength = 8
+
+// RandomCode returns a short, human-readable code of the form "ABCD-EFGH".
+func RandomCode() (string, error) {
+	var builder strings.Builder
+	max := big.NewInt(int64(len(codeAlphabet)))
+	for i := 0; i < codeLength; i++ {
+		if i == codeLength/2 {
+			builder.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteByte(codeAlphabet[n.Int64()])
+	}
+
+	return builder.String(), nil
+}
+
+// NormalizeCode turns a code as typed by a user into the form returned by RandomCode. It ignores case, spaces and
+// dashes, and returns false if the input can't be a code.
+func NormalizeCode(input string) (string, bool) {
+	var builder strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if r == ' ' || r == '-' {
+			continue
+		}
+		if !strings.ContainsRune(codeAlphabet, r) {
+			return "", false
+		}
+		if builder.Len() == codeLength/2 {
+			builder.WriteByte('-')
+		}
+		builder.WriteRune(r)
+	}
+	if builder.Len() != codeLength+1 {
+		return "", false
+	}
+
+	return builder.String(), true
+}
@@ -0,0 +1,38 @@
+package util
+
+import "testing"
+
+func TestRandomCode(t *testing.T) {
+	code, err := RandomCode()
+	if err != nil {
+		t.Fatalf("Failed to generate code: %s", err)
+	}
+
+	normalized, ok := NormalizeCode(code)
+	if !ok || normalized != code {
+		t.Errorf("Expected generated code %q to be normalized to itself, got %q", code, normalized)
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"ABCD-EFGH", "ABCD-EFGH", true},
+		{"abcdefgh", "ABCD-EFGH", true},
+		{" ab cd - ef gh ", "ABCD-EFGH", true},
+		{"ABCD-EFG", "", false},
+		{"ABCD-EFGHJ", "", false},
+		{"ABCD-EFG0", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		actual, ok := NormalizeCode(test.input)
+		if actual != test.expected || ok != test.ok {
+			t.Errorf("NormalizeCode(%q) = %q, %t; expected %q, %t", test.input, actual, ok, test.expected, test.ok)
+		}
+	}
+}
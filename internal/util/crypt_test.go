@@ -0,0 +1,39 @@
+package util
+
+import "testing"
+
+func TestEncrypt(t *testing.T) {
+	key := []byte("key")
+	encrypted, err := Encrypt(key, "secret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if encrypted == "secret" {
+		t.Errorf("Expected value to be encrypted")
+	}
+
+	decrypted, err := Decrypt(key, encrypted)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if decrypted != "secret" {
+		t.Errorf("Expected secret, got %s", decrypted)
+	}
+
+	again, err := Encrypt(key, "secret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if again == encrypted {
+		t.Errorf("Expected a new nonce for every encryption")
+	}
+
+	if _, err := Decrypt([]byte("other-key"), encrypted); err == nil {
+		t.Errorf("Expected error for another key")
+	}
+	for _, malformed := range []string{"", "not base64!", "c2hvcnQ=", encrypted[:len(encrypted)-4] + "AAAA"} {
+		if _, err := Decrypt(key, malformed); err == nil {
+			t.Errorf("Expected error for malformed value %q", malformed)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"bytes"
+	"mime"
+	"mime/quotedprintable"
+	"time"
+)
+
+// EmailMessage returns a plain text email in UTF-8, ready to be sent over SMTP. The subject is encoded, so it may
+// contain characters like æ, ø and å.
+func EmailMessage(from string, to string, subject string, body string, date time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + to + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	buf.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&buf)
+	writer.Write([]byte(body))
+	writer.Close()
+
+	return buf.Bytes()
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessage(t *testing.T) {
+	date := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := string(EmailMessage("taskeroo@example.com", "user@example.com", "Nulstil dit password", "Hej Søren", date))
+
+	expectedHeaders := []string{
+		"From: taskeroo@example.com\r\n",
+		"To: user@example.com\r\n",
+		"Subject: Nulstil dit password\r\n",
+		"Date: Mon, 01 Mar 2021 12:00:00 +0000\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+	}
+	for _, header := range expectedHeaders {
+		if !strings.Contains(message, header) {
+			t.Errorf("Expected message to contain header %q, got: %s", header, message)
+		}
+	}
+
+	if !strings.HasSuffix(message, "\r\n\r\nHej S=C3=B8ren") {
+		t.Errorf("Expected body to be quoted-printable encoded, got: %s", message)
+	}
+}
+
+func TestEmailMessageEncodesSubject(t *testing.T) {
+	message := string(EmailMessage("a@example.com", "b@example.com", "Bekræft din email", "", time.Now()))
+
+	if !strings.Contains(message, "Subject: =?utf-8?q?Bekr=C3=A6ft_din_email?=\r\n") {
+		t.Errorf("Expected subject to be encoded, got: %s", message)
+	}
+}
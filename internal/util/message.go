@@ -4,13 +4,17 @@ import (
 	"bytes"
 )
 
-func CommonTaskMessage(taskTitles []string) string {
+func CommonTaskMessage(language string, taskTitles []string) string {
 	if taskTitles == nil {
 		return ""
 	}
 
 	var buf bytes.Buffer
-	buf.WriteString("Fællesopgaver der skal udføres i dag:\n")
+	if language == "en" {
+		buf.WriteString("Common tasks due today:\n")
+	} else {
+		buf.WriteString("Fællesopgaver der skal udføres i dag:\n")
+	}
 	for _, title := range taskTitles {
 		buf.WriteString("• ")
 		buf.WriteString(title)
@@ -23,13 +27,17 @@ func CommonTaskMessage(taskTitles []string) string {
 	return buf.String()
 }
 
-func AssignedTasksMessage(taskTitles []string) string {
+func AssignedTasksMessage(language string, taskTitles []string) string {
 	if taskTitles == nil {
 		return ""
 	}
 
 	var buf bytes.Buffer
-	buf.WriteString("Du har følgende tildelte opgaver, som skal udføres i dag:\n")
+	if language == "en" {
+		buf.WriteString("You have the following assigned tasks due today:\n")
+	} else {
+		buf.WriteString("Du har følgende tildelte opgaver, som skal udføres i dag:\n")
+	}
 	for _, title := range taskTitles {
 		buf.WriteString("• ")
 		buf.WriteString(title)
@@ -41,3 +49,16 @@ func AssignedTasksMessage(taskTitles []string) string {
 
 	return buf.String()
 }
+
+func TaskListMessage(header string, taskTitles []string) string {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	buf.WriteString("\n")
+	for _, title := range taskTitles {
+		buf.WriteString("• ")
+		buf.WriteString(title)
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,11 @@
+package util
+
+import "testing"
+
+func TestTaskListMessage(t *testing.T) {
+	msg := TaskListMessage("Anna har lige udført 2 opgaver:", []string{"Støvsug", "Vask op"})
+	expected := "Anna har lige udført 2 opgaver:\n• Støvsug\n• Vask op\n"
+	if msg != expected {
+		t.Errorf("Expected %q but got: %q\n", expected, msg)
+	}
+}
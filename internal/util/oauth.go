@@ -0,0 +1,431 @@
+package util
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthClient is used for all requests to providers, so a provider which doesn't answer can't hold up a login.
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// oauthClockSkew is how much the clocks of a provider and this server may differ when checking ID tokens.
+const oauthClockSkew = 2 * time.Minute
+
+// OAuthProvider is a provider users can log in with through the OAuth 2.0 authorization code flow. OpenID Connect
+// providers, like Google, identify the user with an ID token. GitHub doesn't support OpenID Connect, so the user is
+// read from its API instead.
+type OAuthProvider struct {
+	// ID identifies the provider in URLs and in the database, e.g. "google".
+	ID string
+	// Name is shown to users, e.g. "Google".
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	Scopes       []string
+	// Issuer and JWKSURL are set for OpenID Connect providers.
+	Issuer  string
+	JWKSURL string
+	// APIURL is set for GitHub.
+	APIURL string
+
+	keysMutex sync.Mutex
+	keys      map[string]crypto.PublicKey
+}
+
+// OAuthIdentity is the user as told by a provider. Subject never changes for a user of the provider, while the email
+// may.
+type OAuthIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// DiscoverOIDCProvider returns an OpenID Connect provider, configured from the discovery document of the issuer.
+func DiscoverOIDCProvider(ctx context.Context, id string, name string, issuer string, clientID string, clientSecret string) (*OAuthProvider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	err := getOAuthJSON(ctx, issuer+"/.well-known/openid-configuration", "", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oauth: discovery document is for issuer %s, not %s", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oauth: discovery document of %s is missing endpoints", issuer)
+	}
+
+	return &OAuthProvider{
+		ID:           id,
+		Name:         name,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      discovery.AuthorizationEndpoint,
+		TokenURL:     discovery.TokenEndpoint,
+		Scopes:       []string{"openid", "email", "profile"},
+		Issuer:       discovery.Issuer,
+		JWKSURL:      discovery.JWKSURI,
+	}, nil
+}
+
+// NewGitHubProvider returns the provider for logging in with GitHub.
+func NewGitHubProvider(clientID string, clientSecret string) *OAuthProvider {
+	return &OAuthProvider{
+		ID:           "github",
+		Name:         "GitHub",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		Scopes:       []string{"read:user", "user:email"},
+		APIURL:       "https://api.github.com",
+	}
+}
+
+// AuthCodeURL returns the URL the user is sent to at the provider. The state is given back to the redirect URI, and
+// the nonce is put in the ID token. The code verifier must be kept until Exchange, as only a hash of it is sent here.
+func (p *OAuthProvider) AuthCodeURL(redirectURI string, state string, nonce string, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if p.Issuer != "" {
+		values.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + values.Encode()
+}
+
+// Exchange exchanges the code given to the redirect URI for the identity of the user.
+func (p *OAuthProvider) Exchange(ctx context.Context, redirectURI string, code string, codeVerifier string, nonce string) (OAuthIdentity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = doOAuthRequest(req, &token)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	// GitHub answers errors with status 200, so the error is checked in the body too.
+	if token.Error != "" {
+		return OAuthIdentity{}, fmt.Errorf("oauth: token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if p.Issuer != "" {
+		if token.IDToken == "" {
+			return OAuthIdentity{}, fmt.Errorf("oauth: token response has no ID token")
+		}
+		return p.verifyIDToken(ctx, token.IDToken, nonce, time.Now())
+	}
+	if token.AccessToken == "" {
+		return OAuthIdentity{}, fmt.Errorf("oauth: token response has no access token")
+	}
+	return p.gitHubIdentity(ctx, token.AccessToken)
+}
+
+// verifyIDToken checks the signature and claims of an ID token and returns the identity in it.
+func (p *OAuthProvider) verifyIDToken(ctx context.Context, idToken string, nonce string, now time.Time) (OAuthIdentity, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return OAuthIdentity{}, fmt.Errorf("oauth: ID token is not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return OAuthIdentity{}, fmt.Errorf("oauth: invalid ID token signature")
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+			return OAuthIdentity{}, fmt.Errorf("oauth: invalid ID token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return OAuthIdentity{}, fmt.Errorf("oauth: invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return OAuthIdentity{}, fmt.Errorf("oauth: invalid ID token signature")
+		}
+	default:
+		return OAuthIdentity{}, fmt.Errorf("oauth: unsupported ID token key")
+	}
+
+	var claims struct {
+		Issuer   string          `json:"iss"`
+		Subject  string          `json:"sub"`
+		Audience json.RawMessage `json:"aud"`
+		Expiry   int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+		Email    string          `json:"email"`
+		// EmailVerified is a string instead of a boolean for some providers.
+		EmailVerified interface{} `json:"email_verified"`
+		Name          string      `json:"name"`
+	}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	if claims.Issuer != p.Issuer {
+		return OAuthIdentity{}, fmt.Errorf("oauth: ID token is from issuer %s", claims.Issuer)
+	}
+	if !hasAudience(claims.Audience, p.ClientID) {
+		return OAuthIdentity{}, fmt.Errorf("oauth: ID token is for another client")
+	}
+	if now.After(time.Unix(claims.Expiry, 0).Add(oauthClockSkew)) {
+		return OAuthIdentity{}, fmt.Errorf("oauth: ID token has expired")
+	}
+	if claims.Nonce != nonce {
+		return OAuthIdentity{}, fmt.Errorf("oauth: wrong nonce in ID token")
+	}
+	if claims.Subject == "" {
+		return OAuthIdentity{}, fmt.Errorf("oauth: ID token has no subject")
+	}
+
+	emailVerified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	return OAuthIdentity{Subject: claims.Subject, Email: claims.Email, EmailVerified: emailVerified, Name: claims.Name}, nil
+}
+
+// signingKey returns the key of the provider with the key ID. The keys are fetched again if the key isn't known, as
+// providers rotate their keys.
+func (p *OAuthProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := getOAuthJSON(ctx, p.JWKSURL, "", &jwks)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oauth: unknown ID token key %s", kid)
+	}
+	return key, nil
+}
+
+// gitHubIdentity reads the user and their primary email from the GitHub API.
+func (p *OAuthProvider) gitHubIdentity(ctx context.Context, accessToken string) (OAuthIdentity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	err := getOAuthJSON(ctx, p.APIURL+"/user", accessToken, &user)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+	if user.ID == 0 {
+		return OAuthIdentity{}, fmt.Errorf("oauth: GitHub user has no ID")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	err = getOAuthJSON(ctx, p.APIURL+"/user/emails", accessToken, &emails)
+	if err != nil {
+		return OAuthIdentity{}, err
+	}
+
+	identity := OAuthIdentity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, fmt.Errorf("oauth: key is not for signatures")
+	}
+
+	var values []*big.Int
+	var encoded []string
+	switch {
+	case k.Kty == "RSA":
+		encoded = []string{k.N, k.E}
+	case k.Kty == "EC" && k.Crv == "P-256":
+		encoded = []string{k.X, k.Y}
+	default:
+		return nil, fmt.Errorf("oauth: unsupported key type %s", k.Kty)
+	}
+	for _, value := range encoded {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(decoded) == 0 {
+			return nil, fmt.Errorf("oauth: invalid key")
+		}
+		values = append(values, new(big.Int).SetBytes(decoded))
+	}
+
+	if k.Kty == "RSA" {
+		if !values[1].IsInt64() || values[1].Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("oauth: invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: values[0], E: int(values[1].Int64())}, nil
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: values[0], Y: values[1]}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("oauth: EC key is not on the curve")
+	}
+	return key, nil
+}
+
+// hasAudience tells whether the aud claim, which is either a string or a list of strings, contains the client ID.
+func hasAudience(audience json.RawMessage, clientID string) bool {
+	var single string
+	if json.Unmarshal(audience, &single) == nil {
+		return single == clientID
+	}
+	var list []string
+	if json.Unmarshal(audience, &list) == nil {
+		for _, value := range list {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("oauth: invalid ID token encoding")
+	}
+	err = json.Unmarshal(decoded, v)
+	if err != nil {
+		return fmt.Errorf("oauth: invalid ID token: %w", err)
+	}
+	return nil
+}
+
+func getOAuthJSON(ctx context.Context, url string, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doOAuthRequest(req, v)
+}
+
+func doOAuthRequest(req *http.Request, v interface{}) error {
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("oauth: request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("oauth: failed to read response from %s: %w", req.URL.Host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth: %s answered %d: %s", req.URL, resp.StatusCode, body)
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("oauth: invalid response from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package util
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOIDCServer is a stand-in OpenID Connect provider. Users "log in" by calling authorize with the URL from
+// AuthCodeURL, which returns the code the provider would have sent to the redirect URI.
+type testOIDCServer struct {
+	*httptest.Server
+	t      *testing.T
+	mutex  sync.Mutex
+	kid    string
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// useEC signs ID tokens with the EC key instead of the RSA key.
+	useEC bool
+	// claims are added to, or override, the claims of the ID tokens.
+	claims map[string]interface{}
+	codes  map[string]testAuthorization
+}
+
+type testAuthorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+func newTestOIDCServer(t *testing.T) *testOIDCServer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testOIDCServer{t: t, kid: "key-1", rsaKey: rsaKey, ecKey: ecKey, claims: map[string]interface{}{}, codes: map[string]testAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/authorize",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		writeTestJSON(w, map[string]interface{}{"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": s.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(s.rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": s.kid + "-ec",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(s.ecKey.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(s.ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		authorization, ok := s.codes[r.PostFormValue("code")]
+		delete(s.codes, r.PostFormValue("code"))
+		s.mutex.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+			r.PostFormValue("client_id") != authorization.clientID || r.PostFormValue("client_secret") != "secret" ||
+			r.PostFormValue("redirect_uri") != authorization.redirectURI ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeTestJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		writeTestJSON(w, map[string]string{"access_token": "access-token", "id_token": s.idToken(authorization)})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// authorize returns the code for the authorization request, as if the user logged in at the provider.
+func (s *testOIDCServer) authorize(authURL string) (code string, state string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid email profile" {
+		s.t.Fatalf("Unexpected authorization request: %s", authURL)
+	}
+
+	code, err = RandomToken(16)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.mutex.Lock()
+	s.codes[code] = testAuthorization{
+		clientID:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	s.mutex.Unlock()
+	return code, query.Get("state")
+}
+
+func (s *testOIDCServer) idToken(authorization testAuthorization) string {
+	claims := map[string]interface{}{
+		"iss":            s.URL,
+		"sub":            "user-123",
+		"aud":            authorization.clientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          authorization.nonce,
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "Søren",
+	}
+	for key, value := range s.claims {
+		claims[key] = value
+	}
+
+	alg, kid := "RS256", s.kid
+	if s.useEC {
+		alg, kid = "ES256", s.kid+"-ec"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(signed))
+	var signature []byte
+	if s.useEC {
+		r, ss, err := ecdsa.Sign(rand.Reader, s.ecKey, hash[:])
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	} else {
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, hash[:])
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+const testRedirectURI = "https://taskeroo.example.com/login/oauth/test/callback"
+
+func discoverTestProvider(t *testing.T, server *testOIDCServer) *OAuthProvider {
+	provider, err := DiscoverOIDCProvider(context.Background(), "test", "Test", server.URL, "client-id", "secret")
+	if err != nil {
+		t.Fatalf("Expected discovery to succeed, got %s", err)
+	}
+	return provider
+}
+
+func TestOAuthProviderOIDCLogin(t *testing.T) {
+	server := newTestOIDCServer(t)
+	provider := discoverTestProvider(t, server)
+
+	code, state := server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	if state != "state" {
+		t.Errorf("Expected state to be passed on, got %q", state)
+	}
+
+	identity, err := provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected exchange to succeed, got %s", err)
+	}
+	expected := OAuthIdentity{Subject: "user-123", Email: "user@example.com", EmailVerified: true, Name: "Søren"}
+	if identity != expected {
+		t.Errorf("Expected identity %+v, got %+v", expected, identity)
+	}
+
+	if _, err := provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce"); err == nil {
+		t.Errorf("Expected a code to only be usable once")
+	}
+}
+
+func TestOAuthProviderOIDCLoginES256(t *testing.T) {
+	server := newTestOIDCServer(t)
+	server.useEC = true
+	provider := discoverTestProvider(t, server)
+
+	code, _ := server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	identity, err := provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected exchange to succeed, got %s", err)
+	}
+	if identity.Subject != "user-123" {
+		t.Errorf("Expected subject user-123, got %s", identity.Subject)
+	}
+}
+
+func TestOAuthProviderOIDCLoginRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		claims   map[string]interface{}
+	}{
+		{name: "wrong code verifier", verifier: "other", nonce: "nonce"},
+		{name: "wrong nonce", verifier: "verifier", nonce: "other"},
+		{name: "wrong issuer", verifier: "verifier", nonce: "nonce", claims: map[string]interface{}{"iss": "https://evil.example.com"}},
+		{name: "wrong audience", verifier: "verifier", nonce: "nonce", claims: map[string]interface{}{"aud": []string{"other-client"}}},
+		{name: "expired", verifier: "verifier", nonce: "nonce", claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{name: "no subject", verifier: "verifier", nonce: "nonce", claims: map[string]interface{}{"sub": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestOIDCServer(t)
+			for key, value := range test.claims {
+				server.claims[key] = value
+			}
+			provider := discoverTestProvider(t, server)
+
+			code, _ := server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+			_, err := provider.Exchange(context.Background(), testRedirectURI, code, test.verifier, test.nonce)
+			if err == nil {
+				t.Errorf("Expected exchange to fail")
+			}
+		})
+	}
+}
+
+func TestOAuthProviderOIDCClaims(t *testing.T) {
+	server := newTestOIDCServer(t)
+	server.claims["aud"] = []string{"other-client", "client-id"}
+	server.claims["email_verified"] = "false"
+	provider := discoverTestProvider(t, server)
+
+	code, _ := server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	identity, err := provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected exchange to succeed, got %s", err)
+	}
+	if identity.EmailVerified {
+		t.Errorf("Expected email not to be verified")
+	}
+}
+
+func TestOAuthProviderOIDCKeyRotation(t *testing.T) {
+	server := newTestOIDCServer(t)
+	provider := discoverTestProvider(t, server)
+
+	code, _ := server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	_, err := provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected exchange to succeed, got %s", err)
+	}
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.mutex.Lock()
+	server.kid, server.rsaKey = "key-2", newKey
+	server.mutex.Unlock()
+
+	code, _ = server.authorize(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	_, err = provider.Exchange(context.Background(), testRedirectURI, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected the new key to be fetched, got %s", err)
+	}
+}
+
+func TestOAuthProviderOIDCForgedSignature(t *testing.T) {
+	server := newTestOIDCServer(t)
+	provider := discoverTestProvider(t, server)
+
+	// The ID token is signed with another key, but claims the key ID of the provider's key.
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forger := &testOIDCServer{Server: server.Server, t: t, kid: server.kid, rsaKey: otherKey, claims: map[string]interface{}{}}
+	idToken := forger.idToken(testAuthorization{clientID: "client-id", nonce: "nonce"})
+
+	if _, err := provider.verifyIDToken(context.Background(), idToken, "nonce", time.Now()); err == nil {
+		t.Errorf("Expected ID token signed with another key to be rejected")
+	}
+	if _, err := provider.verifyIDToken(context.Background(), server.idToken(testAuthorization{clientID: "client-id", nonce: "nonce"}), "nonce", time.Now()); err != nil {
+		t.Errorf("Expected ID token signed by the provider to be valid, got %s", err)
+	}
+}
+
+func TestDiscoverOIDCProviderWrongIssuer(t *testing.T) {
+	server := newTestOIDCServer(t)
+	_, err := DiscoverOIDCProvider(context.Background(), "test", "Test", server.URL+"/other", "client-id", "secret")
+	if err == nil {
+		t.Errorf("Expected discovery of another issuer to fail")
+	}
+}
+
+func TestOAuthProviderGitHubLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "code" || r.Header.Get("Accept") != "application/json" {
+			// GitHub answers errors with status 200.
+			writeTestJSON(w, map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		writeTestJSON(w, map[string]string{"access_token": "access-token"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeTestJSON(w, map[string]interface{}{"id": 42, "login": "soren", "name": ""})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, []map[string]interface{}{
+			{"email": "other@example.com", "primary": false, "verified": true},
+			{"email": "user@example.com", "primary": true, "verified": true},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := NewGitHubProvider("client-id", "secret")
+	provider.AuthURL = server.URL + "/login/oauth/authorize"
+	provider.TokenURL = server.URL + "/login/oauth/access_token"
+	provider.APIURL = server.URL
+
+	authURL, err := url.Parse(provider.AuthCodeURL(testRedirectURI, "state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL.Query().Has("nonce") {
+		t.Errorf("Expected no nonce for GitHub, got %s", authURL)
+	}
+
+	identity, err := provider.Exchange(context.Background(), testRedirectURI, "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Expected exchange to succeed, got %s", err)
+	}
+	expected := OAuthIdentity{Subject: "42", Email: "user@example.com", EmailVerified: true, Name: "soren"}
+	if identity != expected {
+		t.Errorf("Expected identity %+v, got %+v", expected, identity)
+	}
+
+	if _, err := provider.Exchange(context.Background(), testRedirectURI, "wrong", "verifier", "nonce"); err == nil {
+		t.Errorf("Expected exchange of a wrong code to fail")
+	}
+}
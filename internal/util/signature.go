@@ -0,0 +1,74 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// ValidSignature checks a signature of the form "sha256=<hex encoded HMAC-SHA256 of body>" against the secret.
+func ValidSignature(secret string, body []byte, signature string) bool {
+	signature = strings.TrimPrefix(signature, "sha256=")
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// SignToken returns a token which contains the payload and an HMAC-SHA256 signature of it with the key, so the
+// payload can be trusted when the token is given back. The payload is readable by anyone holding the token.
+func SignToken(key []byte, payload string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignedToken returns the payload of a token from SignToken, and false if the token wasn't signed with the key.
+func VerifySignedToken(key []byte, token string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	given, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return "", false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+
+	return string(payload), true
+}
+
+// RandomToken returns a hex encoded random token of the given number of bytes.
+func RandomToken(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token, so tokens can be looked up without storing them.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"event":"washer_done"}`)
+	// echo -n '{"event":"washer_done"}' | openssl dgst -sha256 -hmac secret
+	signature := "sha256=fec0bab118ea7f72e70d8d7cf540b5c6aa52a3b4e3dbb9670042342039351ac2"
+
+	if !ValidSignature("secret", body, signature) {
+		t.Errorf("Expected signature to be valid for the right secret")
+	}
+	if ValidSignature("other-secret", body, signature) {
+		t.Errorf("Expected signature to be invalid for another secret")
+	}
+	if ValidSignature("secret", []byte(`{}`), signature) {
+		t.Errorf("Expected signature to be invalid for another body")
+	}
+	if ValidSignature("secret", body, "sha256=not-hex") {
+		t.Errorf("Expected malformed signature to be invalid")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	// echo -n token | sha256sum
+	expected := "3c469e9d6c5875d37a43f353d4f88e61fcf812c66eee3457465a40b0da4153e0"
+	if actual := HashToken("token"); actual != expected {
+		t.Errorf("Expected hash %s, got %s", expected, actual)
+	}
+}
+
+func TestSignToken(t *testing.T) {
+	key := []byte("key")
+	token := SignToken(key, "user|user@example.com|1614600000")
+
+	payload, ok := VerifySignedToken(key, token)
+	if !ok {
+		t.Fatalf("Expected token to be valid for the right key")
+	}
+	if payload != "user|user@example.com|1614600000" {
+		t.Errorf("Expected payload to be returned, got %s", payload)
+	}
+
+	if _, ok := VerifySignedToken([]byte("other-key"), token); ok {
+		t.Errorf("Expected token to be invalid for another key")
+	}
+
+	tampered := SignToken(key, "other|user@example.com|1614600000")
+	tampered = tampered[:strings.Index(tampered, ".")] + token[strings.Index(token, "."):]
+	if _, ok := VerifySignedToken(key, tampered); ok {
+		t.Errorf("Expected token with another payload to be invalid")
+	}
+
+	for _, malformed := range []string{"", "no-dot", "a.b.c", token + "!"} {
+		if _, ok := VerifySignedToken(key, malformed); ok {
+			t.Errorf("Expected malformed token %q to be invalid", malformed)
+		}
+	}
+}
@@ -2,13 +2,26 @@ package util
 
 import "time"
 
-func DurationToNextNoon(now time.Time) time.Duration {
-	hour := 12
-	minute := 00
-	if now.Hour() <= hour && now.Minute() < minute {
-		return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location()).Sub(now)
-	} else {
-		tomorrow := now.AddDate(0, 0, 1)
-		return time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), hour, minute, 0, 0, tomorrow.Location()).Sub(now)
+// LatestOccurrence returns the latest time, not after now, when the clock showed hour:minute in the location of now.
+func LatestOccurrence(now time.Time, hour int, minute int) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if today.After(now) {
+		yesterday := now.AddDate(0, 0, -1)
+		return time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), hour, minute, 0, 0, now.Location())
 	}
+	return today
+}
+
+// DurationToNextMinute returns the duration until the next whole minute.
+func DurationToNextMinute(now time.Time) time.Duration {
+	return now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+}
+
+// DaysBetween returns the number of calendar days from the date of from to the date of to, in the given location.
+func DaysBetween(from time.Time, to time.Time, location *time.Location) int {
+	from = from.In(location)
+	to = to.In(location)
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDate.Sub(fromDate).Hours() / 24)
 }
@@ -1,16 +1,55 @@
 package util
 
-// TODO: Include again when running at noon :P
-//func TestDurationTillNoon(t *testing.T) {
-//	duration := DurationToNextNoon(time.Date(2022, 02, 23, 10, 0, 00, 00, time.Local))
-//	expected := 2.0
-//	if duration.Hours() != expected {
-//		t.Errorf("Expected %f but got: %f\n", expected, duration.Hours())
-//	}
-//
-//	duration = DurationToNextNoon(time.Date(2022, 02, 23, 22, 0, 00, 00, time.Local))
-//	expected = 14.0
-//	if duration.Hours() != expected {
-//		t.Errorf("Expected %f but got: %f\n", expected, duration.Hours())
-//	}
-//}
+import (
+	"testing"
+	"time"
+)
+
+func TestLatestOccurrence(t *testing.T) {
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load location: %s", err)
+	}
+
+	latest := LatestOccurrence(time.Date(2022, 02, 23, 10, 0, 0, 0, copenhagen), 12, 0)
+	expected := time.Date(2022, 02, 22, 12, 0, 0, 0, copenhagen)
+	if !latest.Equal(expected) {
+		t.Errorf("Expected %s but got: %s\n", expected, latest)
+	}
+
+	latest = LatestOccurrence(time.Date(2022, 02, 23, 22, 0, 0, 0, copenhagen), 12, 0)
+	expected = time.Date(2022, 02, 23, 12, 0, 0, 0, copenhagen)
+	if !latest.Equal(expected) {
+		t.Errorf("Expected %s but got: %s\n", expected, latest)
+	}
+
+	latest = LatestOccurrence(time.Date(2022, 02, 23, 12, 0, 0, 0, copenhagen), 12, 0)
+	expected = time.Date(2022, 02, 23, 12, 0, 0, 0, copenhagen)
+	if !latest.Equal(expected) {
+		t.Errorf("Expected %s but got: %s\n", expected, latest)
+	}
+}
+
+func TestDaysBetween(t *testing.T) {
+	copenhagen, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatalf("Failed to load location: %s", err)
+	}
+
+	// 23:30 UTC is already the next day in Copenhagen.
+	now := time.Date(2022, 02, 23, 23, 30, 0, 0, time.UTC)
+	due := time.Date(2022, 02, 24, 8, 0, 0, 0, time.UTC)
+	if days := DaysBetween(now, due, copenhagen); days != 0 {
+		t.Errorf("Expected 0 days in Copenhagen but got: %d\n", days)
+	}
+	if days := DaysBetween(now, due, time.UTC); days != 1 {
+		t.Errorf("Expected 1 day in UTC but got: %d\n", days)
+	}
+
+	// Across the change to summer time.
+	now = time.Date(2022, 03, 26, 12, 0, 0, 0, copenhagen)
+	due = time.Date(2022, 03, 28, 12, 0, 0, 0, copenhagen)
+	if days := DaysBetween(now, due, copenhagen); days != 2 {
+		t.Errorf("Expected 2 days but got: %d\n", days)
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is how long each TOTP code is valid, as used by authenticator apps.
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSecretBytes is the length of TOTP secrets, as recommended by RFC 4226.
+	totpSecretBytes = 20
+)
+
+// totpEncoding is how TOTP secrets are shown to users and authenticator apps.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RandomTOTPSecret returns a new TOTP secret, encoded as base32 like authenticator apps expect.
+func RandomTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPURI returns the otpauth URI of the secret, which authenticator apps read from a QR code.
+func TOTPURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// TOTP returns the RFC 6238 code of the base32 encoded secret for the time step, using HMAC-SHA1, 30 second steps
+// and 6 digits like authenticator apps do.
+func TOTP(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// TOTPStep returns the time step of the time.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// ValidTOTP checks the code against the secret at the time, allowing one step before and after for clocks which are
+// a little off. It returns the step the code belongs to, so the caller can reject codes which have already been used.
+func ValidTOTP(secret string, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	now := TOTPStep(t)
+	for step := now - 1; step <= now+1; step++ {
+		expected, err := TOTP(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
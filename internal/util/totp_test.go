@@ -0,0 +1,78 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 secret "12345678901234567890" from the test vectors in RFC 6238, encoded as base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP(t *testing.T) {
+	// The last 6 digits of the 8 digit codes in RFC 6238, appendix B.
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range tests {
+		actual, err := TOTP(rfc6238Secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err)
+		}
+		if actual != expected {
+			t.Errorf("Expected code %s at %d, got %s", expected, unix, actual)
+		}
+	}
+
+	if _, err := TOTP("not base32!", 1); err == nil {
+		t.Errorf("Expected error for invalid secret")
+	}
+}
+
+func TestValidTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+
+	if actual, ok := ValidTOTP(rfc6238Secret, "081804", now); !ok || actual != step {
+		t.Errorf("Expected current code to be valid for step %d, got %d, %t", step, actual, ok)
+	}
+	if actual, ok := ValidTOTP(rfc6238Secret, "081 804", now.Add(30*time.Second)); !ok || actual != step {
+		t.Errorf("Expected code from the step before to be valid for step %d, got %d, %t", step, actual, ok)
+	}
+	if _, ok := ValidTOTP(rfc6238Secret, "081804", now.Add(90*time.Second)); ok {
+		t.Errorf("Expected code from two steps before to be invalid")
+	}
+	for _, code := range []string{"", "123456", "08180", "0818044"} {
+		if _, ok := ValidTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("Expected code %q to be invalid", code)
+		}
+	}
+}
+
+func TestRandomTOTPSecret(t *testing.T) {
+	secret, err := RandomTOTPSecret()
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("Expected 32 base32 characters, got %q", secret)
+	}
+	if _, err := TOTP(secret, 1); err != nil {
+		t.Errorf("Expected secret to be usable, got %s", err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Taskeroo", "user@example.com", "ABC")
+	expected := "otpauth://totp/Taskeroo:user@example.com?issuer=Taskeroo&secret=ABC"
+	if uri != expected {
+		t.Errorf("Expected %s, got %s", expected, uri)
+	}
+	if !strings.HasPrefix(TOTPURI("Task eroo", "a b", "ABC"), "otpauth://totp/Task%20eroo:a%20b?") {
+		t.Errorf("Expected label to be escaped")
+	}
+}
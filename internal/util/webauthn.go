@@ -0,0 +1,253 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+const (
+	webAuthnFlagUserPresent  = 0x01
+	webAuthnFlagUserVerified = 0x04
+	webAuthnFlagAttestedData = 0x40
+
+	// CoseAlgES256 and CoseAlgRS256 are the COSE algorithms of the public keys which are supported. ES256 is used by
+	// almost all authenticators, and RS256 by Windows Hello.
+	CoseAlgES256 = -7
+	CoseAlgRS256 = -257
+)
+
+// WebAuthnRelyingParty is the site credentials are registered with. ID is its domain, and Origin is the scheme and
+// host of the pages which use the credentials.
+type WebAuthnRelyingParty struct {
+	ID     string
+	Origin string
+}
+
+// WebAuthnCredential is a credential created by an authenticator, like a passkey on a phone.
+type WebAuthnCredential struct {
+	ID []byte
+	// PublicKey is the public key of the credential, encoded as a COSE key.
+	PublicKey []byte
+	// SignCount is the number of signatures the authenticator has made with the credential. Authenticators which don't
+	// count signatures, like most passkeys, always give 0.
+	SignCount uint32
+}
+
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type webAuthnAuthenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// credentialID and publicKey are only set during registration.
+	credentialID []byte
+	publicKey    []byte
+}
+
+// VerifyWebAuthnRegistration checks the response of an authenticator to navigator.credentials.create(), and returns
+// the new credential. The challenge is the base64url encoded challenge given to the browser. Attestation is not
+// checked, as credentials are requested with attestation "none", but the user must have been verified, e.g. by a
+// fingerprint.
+func VerifyWebAuthnRegistration(rp WebAuthnRelyingParty, challenge string, clientDataJSON []byte, attestationObject []byte) (WebAuthnCredential, error) {
+	err := checkWebAuthnClientData(clientDataJSON, "webauthn.create", challenge, rp.Origin)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+
+	decoded, _, err := DecodeCBOR(attestationObject)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return WebAuthnCredential{}, fmt.Errorf("webauthn: attestation object is not a map")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return WebAuthnCredential{}, fmt.Errorf("webauthn: attestation object has no authenticator data")
+	}
+
+	authData, err := parseWebAuthnAuthenticatorData(rawAuthData)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	err = checkWebAuthnAuthenticatorData(authData, rp.ID)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if authData.credentialID == nil {
+		return WebAuthnCredential{}, fmt.Errorf("webauthn: no credential in authenticator data")
+	}
+
+	_, err = parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+
+	return WebAuthnCredential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyWebAuthnAssertion checks the response of an authenticator to navigator.credentials.get() against the
+// credential, and returns the new sign count of the credential. The user must have been verified, and the sign count
+// must have increased, unless the authenticator doesn't count signatures, as it may be cloned otherwise.
+func VerifyWebAuthnAssertion(rp WebAuthnRelyingParty, challenge string, credential WebAuthnCredential, clientDataJSON []byte, authenticatorData []byte, signature []byte) (uint32, error) {
+	err := checkWebAuthnClientData(clientDataJSON, "webauthn.get", challenge, rp.Origin)
+	if err != nil {
+		return 0, err
+	}
+
+	authData, err := parseWebAuthnAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	err = checkWebAuthnAuthenticatorData(authData, rp.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	publicKey, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte(nil), authenticatorData...), clientDataHash[:]...))
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, signed[:], signature) {
+			return 0, fmt.Errorf("webauthn: invalid signature")
+		}
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, signed[:], signature)
+		if err != nil {
+			return 0, fmt.Errorf("webauthn: invalid signature")
+		}
+	}
+
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, fmt.Errorf("webauthn: sign count did not increase, the authenticator may be cloned")
+	}
+
+	return authData.signCount, nil
+}
+
+func checkWebAuthnClientData(clientDataJSON []byte, expectedType string, challenge string, origin string) error {
+	var clientData webAuthnClientData
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	if clientData.Type != expectedType {
+		return fmt.Errorf("webauthn: expected client data of type %s, got %s", expectedType, clientData.Type)
+	}
+	if challenge == "" || clientData.Challenge != challenge {
+		return fmt.Errorf("webauthn: wrong challenge")
+	}
+	if clientData.Origin != origin {
+		return fmt.Errorf("webauthn: wrong origin %s", clientData.Origin)
+	}
+
+	return nil
+}
+
+func checkWebAuthnAuthenticatorData(authData webAuthnAuthenticatorData, rpID string) error {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("webauthn: credential is for another relying party")
+	}
+	if authData.flags&webAuthnFlagUserPresent == 0 {
+		return fmt.Errorf("webauthn: user was not present")
+	}
+	if authData.flags&webAuthnFlagUserVerified == 0 {
+		return fmt.Errorf("webauthn: user was not verified")
+	}
+
+	return nil
+}
+
+func parseWebAuthnAuthenticatorData(data []byte) (webAuthnAuthenticatorData, error) {
+	if len(data) < 37 {
+		return webAuthnAuthenticatorData{}, fmt.Errorf("webauthn: authenticator data is too short")
+	}
+	authData := webAuthnAuthenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&webAuthnFlagAttestedData == 0 {
+		return authData, nil
+	}
+
+	// The attested credential data is the AAGUID of the authenticator, the length of the credential ID, the
+	// credential ID and the public key.
+	rest := data[37:]
+	if len(rest) < 18 {
+		return webAuthnAuthenticatorData{}, fmt.Errorf("webauthn: attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || len(rest) < idLength {
+		return webAuthnAuthenticatorData{}, fmt.Errorf("webauthn: invalid credential ID")
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, after, err := DecodeCBOR(rest)
+	if err != nil {
+		return webAuthnAuthenticatorData{}, err
+	}
+	authData.publicKey = rest[:len(rest)-len(after)]
+
+	return authData, nil
+}
+
+// parseCOSEKey returns the ES256 or RS256 public key in the COSE key.
+func parseCOSEKey(data []byte) (crypto.PublicKey, error) {
+	decoded, _, err := DecodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("webauthn: public key is not a map")
+	}
+
+	alg, _ := key[int64(3)].(int64)
+	switch alg {
+	case CoseAlgES256:
+		x, xOK := key[int64(-2)].([]byte)
+		y, yOK := key[int64(-3)].([]byte)
+		if key[int64(1)] != int64(2) || key[int64(-1)] != int64(1) || !xOK || !yOK || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("webauthn: invalid ES256 public key")
+		}
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, fmt.Errorf("webauthn: ES256 public key is not on the curve")
+		}
+		return publicKey, nil
+	case CoseAlgRS256:
+		n, nOK := key[int64(-1)].([]byte)
+		e, eOK := key[int64(-2)].([]byte)
+		if key[int64(1)] != int64(3) || !nOK || !eOK || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("webauthn: invalid RS256 public key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+
+	return nil, fmt.Errorf("webauthn: unsupported algorithm %d", alg)
+}
@@ -0,0 +1,206 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+)
+
+var testRelyingParty = WebAuthnRelyingParty{ID: "example.com", Origin: "https://example.com"}
+
+func TestVerifyWebAuthnRegistration(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	credentialID := []byte("credential-id")
+	clientData := testClientData("webauthn.create", "challenge", testRelyingParty.Origin)
+	authData := testAuthData("example.com", webAuthnFlagUserPresent|webAuthnFlagUserVerified|webAuthnFlagAttestedData, 0, credentialID, testCOSEKey(&key.PublicKey))
+	attestation := encodeTestCBOR(map[interface{}]interface{}{"fmt": "none", "attStmt": map[interface{}]interface{}{}, "authData": authData})
+
+	credential, err := VerifyWebAuthnRegistration(testRelyingParty, "challenge", clientData, attestation)
+	if err != nil {
+		t.Fatalf("Expected registration to be valid, got %s", err)
+	}
+	if string(credential.ID) != "credential-id" {
+		t.Errorf("Expected credential ID, got %q", credential.ID)
+	}
+	if string(credential.PublicKey) != string(testCOSEKey(&key.PublicKey)) {
+		t.Errorf("Expected the COSE key of the credential")
+	}
+
+	if _, err := VerifyWebAuthnRegistration(testRelyingParty, "other-challenge", clientData, attestation); err == nil {
+		t.Errorf("Expected error for another challenge")
+	}
+	if _, err := VerifyWebAuthnRegistration(WebAuthnRelyingParty{ID: "example.com", Origin: "https://evil.com"}, "challenge", clientData, attestation); err == nil {
+		t.Errorf("Expected error for another origin")
+	}
+	if _, err := VerifyWebAuthnRegistration(WebAuthnRelyingParty{ID: "other.com", Origin: testRelyingParty.Origin}, "challenge", clientData, attestation); err == nil {
+		t.Errorf("Expected error for another relying party")
+	}
+	getClientData := testClientData("webauthn.get", "challenge", testRelyingParty.Origin)
+	if _, err := VerifyWebAuthnRegistration(testRelyingParty, "challenge", getClientData, attestation); err == nil {
+		t.Errorf("Expected error for client data of an assertion")
+	}
+
+	unverified := testAuthData("example.com", webAuthnFlagUserPresent|webAuthnFlagAttestedData, 0, credentialID, testCOSEKey(&key.PublicKey))
+	attestation = encodeTestCBOR(map[interface{}]interface{}{"fmt": "none", "attStmt": map[interface{}]interface{}{}, "authData": unverified})
+	if _, err := VerifyWebAuthnRegistration(testRelyingParty, "challenge", clientData, attestation); err == nil {
+		t.Errorf("Expected error when the user was not verified")
+	}
+}
+
+func TestVerifyWebAuthnAssertion(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signers := map[string]struct {
+		publicKey []byte
+		sign      func(digest []byte) []byte
+	}{
+		"ES256": {testCOSEKey(&ecKey.PublicKey), func(digest []byte) []byte {
+			signature, _ := ecdsa.SignASN1(rand.Reader, ecKey, digest)
+			return signature
+		}},
+		"RS256": {testCOSEKey(&rsaKey.PublicKey), func(digest []byte) []byte {
+			signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+			return signature
+		}},
+	}
+
+	for name, signer := range signers {
+		clientData := testClientData("webauthn.get", "challenge", testRelyingParty.Origin)
+		authData := testAuthData("example.com", webAuthnFlagUserPresent|webAuthnFlagUserVerified, 5, nil, nil)
+		signature := signer.sign(testSignedDigest(authData, clientData))
+		credential := WebAuthnCredential{ID: []byte("id"), PublicKey: signer.publicKey, SignCount: 4}
+
+		signCount, err := VerifyWebAuthnAssertion(testRelyingParty, "challenge", credential, clientData, authData, signature)
+		if err != nil {
+			t.Fatalf("%s: Expected assertion to be valid, got %s", name, err)
+		}
+		if signCount != 5 {
+			t.Errorf("%s: Expected sign count 5, got %d", name, signCount)
+		}
+
+		if _, err := VerifyWebAuthnAssertion(testRelyingParty, "other-challenge", credential, clientData, authData, signature); err == nil {
+			t.Errorf("%s: Expected error for another challenge", name)
+		}
+		signature[len(signature)-1] ^= 0xff
+		if _, err := VerifyWebAuthnAssertion(testRelyingParty, "challenge", credential, clientData, authData, signature); err == nil {
+			t.Errorf("%s: Expected error for an invalid signature", name)
+		}
+	}
+}
+
+func TestVerifyWebAuthnAssertionSignCount(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientData := testClientData("webauthn.get", "challenge", testRelyingParty.Origin)
+	tests := []struct {
+		stored  uint32
+		given   uint32
+		invalid bool
+	}{
+		{stored: 0, given: 0},
+		{stored: 0, given: 1},
+		{stored: 1, given: 2},
+		{stored: 2, given: 2, invalid: true},
+		{stored: 2, given: 0, invalid: true},
+	}
+	for _, test := range tests {
+		authData := testAuthData("example.com", webAuthnFlagUserPresent|webAuthnFlagUserVerified, test.given, nil, nil)
+		signature, _ := ecdsa.SignASN1(rand.Reader, key, testSignedDigest(authData, clientData))
+		credential := WebAuthnCredential{PublicKey: testCOSEKey(&key.PublicKey), SignCount: test.stored}
+
+		_, err := VerifyWebAuthnAssertion(testRelyingParty, "challenge", credential, clientData, authData, signature)
+		if (err != nil) != test.invalid {
+			t.Errorf("Expected invalid=%t for stored sign count %d and given %d, got %v", test.invalid, test.stored, test.given, err)
+		}
+	}
+}
+
+func testClientData(typ string, challenge string, origin string) []byte {
+	return []byte(fmt.Sprintf(`{"type":%q,"challenge":%q,"origin":%q,"crossOrigin":false}`, typ, challenge, origin))
+}
+
+func testAuthData(rpID string, flags byte, signCount uint32, credentialID []byte, publicKey []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], signCount)
+	if credentialID != nil {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(credentialID)>>8), byte(len(credentialID)))
+		data = append(data, credentialID...)
+		data = append(data, publicKey...)
+	}
+	return data
+}
+
+func testSignedDigest(authData []byte, clientData []byte) []byte {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	return digest[:]
+}
+
+func testCOSEKey(publicKey crypto.PublicKey) []byte {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return encodeTestCBOR(map[interface{}]interface{}{
+			int64(1): int64(2), int64(3): int64(CoseAlgES256), int64(-1): int64(1),
+			int64(-2): key.X.FillBytes(make([]byte, 32)), int64(-3): key.Y.FillBytes(make([]byte, 32)),
+		})
+	case *rsa.PublicKey:
+		return encodeTestCBOR(map[interface{}]interface{}{
+			int64(1): int64(3), int64(3): int64(CoseAlgRS256),
+			int64(-1): key.N.Bytes(), int64(-2): big.NewInt(int64(key.E)).Bytes(),
+		})
+	}
+	return nil
+}
+
+// encodeTestCBOR encodes the values DecodeCBOR returns. Map keys are sorted, so the encoding is deterministic.
+func encodeTestCBOR(value interface{}) []byte {
+	header := func(major byte, argument uint64) []byte {
+		switch {
+		case argument < 24:
+			return []byte{major<<5 | byte(argument)}
+		case argument < 256:
+			return []byte{major<<5 | 24, byte(argument)}
+		default:
+			return []byte{major<<5 | 25, byte(argument >> 8), byte(argument)}
+		}
+	}
+
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		var keys []string
+		encoded := map[string][]byte{}
+		for key, item := range v {
+			k := string(encodeTestCBOR(key))
+			keys = append(keys, k)
+			encoded[k] = encodeTestCBOR(item)
+		}
+		sort.Strings(keys)
+		data := header(5, uint64(len(v)))
+		for _, k := range keys {
+			data = append(data, k...)
+			data = append(data, encoded[k]...)
+		}
+		return data
+	}
+	panic(fmt.Sprintf("unsupported value %T", value))
+}
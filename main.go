@@ -2,6 +2,8 @@ package main
 
 import (
 	"github.com/dentych/taskeroo/internal"
+	// Embed the timezone database, as the timezones of groups must be available regardless of the host.
+	_ "time/tzdata"
 )
 
 func main() {